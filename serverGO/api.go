@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Connection metadata exposed by the admin API
+type ConnectionInfo struct {
+	ID              string        `json:"id"`
+	Role            Role          `json:"role"`
+	PanelRole       PanelRole     `json:"panelRole,omitempty"`
+	Name            string        `json:"name,omitempty"`
+	Subject         string        `json:"subject,omitempty"`
+	RemoteIP        string        `json:"remoteIp"`
+	ProtocolVersion int           `json:"protocolVersion,omitempty"`
+	Connected       time.Time     `json:"connected"`
+	Outbound        OutboundStats `json:"outbound"`
+}
+
+// Snapshot of a connection for the admin API
+func (c *Connection) Info() ConnectionInfo {
+	return ConnectionInfo{
+		ID:              c.ID,
+		Role:            c.Role,
+		PanelRole:       c.PanelRole,
+		Name:            c.Name,
+		Subject:         c.Subject,
+		RemoteIP:        c.RemoteIP,
+		ProtocolVersion: c.Protocol,
+		Connected:       c.Connected,
+		Outbound:        c.OutboundStats(),
+	}
+}
+
+// Session states reported by the admin API
+const (
+	SessionStatusPending = "pending" // code handed out, no client yet
+	SessionStatusActive  = "active"  // client registered
+)
+
+// Session entry in GET /api/sessions
+type SessionSummary struct {
+	Code         string      `json:"code"`
+	ID           string      `json:"id,omitempty"` // key of the audit log and recordings
+	Status       string      `json:"status"`
+	Subject      string      `json:"subject,omitempty"`
+	Mode         SessionMode `json:"mode,omitempty"`
+	CreatedAt    time.Time   `json:"createdAt"`
+	Node         string      `json:"node"`
+	ClientOnline bool        `json:"clientOnline"`
+	Viewers      int         `json:"viewers"`
+	Recording    bool        `json:"recording"`
+}
+
+// Full session in GET /api/sessions/{code}
+type SessionDetail struct {
+	SessionSummary
+	Info          *SessionInfo     `json:"info,omitempty"`
+	Client        *ConnectionInfo  `json:"client,omitempty"`
+	RemoteClient  bool             `json:"remoteClient"`
+	ViewerList    []ConnectionInfo `json:"viewerList"`
+	RemoteViewers []ViewerIdentity `json:"remoteViewers"`
+}
+
+// Body of POST /api/codes
+type CreateCodeRequest struct {
+	Subject string      `json:"subject,omitempty"` // interviewer the code is issued for, admins only, defaults to the caller
+	Mode    SessionMode `json:"mode,omitempty"`    // defaults to the server's session mode
+}
+
+// Response of POST /api/codes
+type CreateCodeResponse struct {
+	Code      string      `json:"code"`
+	Subject   string      `json:"subject"`
+	Mode      SessionMode `json:"mode"`
+	ExpiresAt int64       `json:"expiresAt"`
+}
+
+// Verify the credentials of an HTTP request, writing 401 on failure
+func (s *Server) authenticateRequest(w http.ResponseWriter, r *http.Request) (*TokenClaims, bool) {
+	claims, err := s.auth.VerifyRequest(r)
+	if err != nil {
+		writeAPIError(w, http.StatusUnauthorized, ErrUnauthenticated, "Authentication required")
+		return nil, false
+	}
+	return claims, true
+}
+
+// Write a JSON response body
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Write a structured JSON error
+func writeAPIError(w http.ResponseWriter, status int, code ErrorCode, message string) {
+	writeJSON(w, status, map[string]ErrorPayload{
+		"error": {Code: code, Message: message},
+	})
+}
+
+// Summary of an active session, caller must hold session.mu
+func (s *Server) sessionSummary(code string, session *Session) SessionSummary {
+	summary := SessionSummary{
+		Code:         code,
+		Status:       SessionStatusActive,
+		Node:         s.nodeID,
+		ClientOnline: session.hasClient(),
+		Viewers:      len(session.openViewers()) + len(session.remoteViewers),
+		Recording:    session.recorder != nil,
+	}
+	if session.Info != nil {
+		summary.ID = session.Info.ID
+		summary.Subject = session.Info.Subject
+		summary.Mode = session.Info.Mode
+		summary.CreatedAt = session.Info.CreatedAt
+	}
+	return summary
+}
+
+// Summary of a pending code, caller must hold s.mu
+func (s *Server) pendingSummary(code string, pending *PendingCode) SessionSummary {
+	return SessionSummary{
+		Code:      code,
+		ID:        pending.ID,
+		Status:    SessionStatusPending,
+		Subject:   pending.Subject,
+		Mode:      pending.Mode,
+		CreatedAt: pending.CreatedAt,
+		Node:      s.nodeID,
+		Viewers:   len(pending.Viewers),
+	}
+}
+
+// Handle GET /api/sessions, listing the sessions the caller may see
+func (s *Server) handleAPISessions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, ErrInvalidMessage, "Method not allowed")
+		return
+	}
+
+	s.mu.RLock()
+	summaries := make([]SessionSummary, 0, len(s.sessions)+len(s.pendingCodes))
+	for code, session := range s.sessions {
+		session.mu.RLock()
+		summaries = append(summaries, s.sessionSummary(code, session))
+		session.mu.RUnlock()
+	}
+	for code, pending := range s.pendingCodes {
+		summaries = append(summaries, s.pendingSummary(code, pending))
+	}
+	s.mu.RUnlock()
+
+	visible := summaries[:0]
+	for _, summary := range summaries {
+		if s.authorizeSession(claims, summary.Code) {
+			visible = append(visible, summary)
+		}
+	}
+	summaries = visible
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].CreatedAt.Before(summaries[j].CreatedAt)
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"sessions": summaries,
+	})
+}
+
+// Handle GET and DELETE /api/sessions/{code}
+func (s *Server) handleAPISession(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+
+	code := s.codeFormat.Normalize(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/sessions/"), "/"))
+	if code == "" || strings.Contains(code, "/") {
+		writeAPIError(w, http.StatusNotFound, ErrInvalidCode, "Session not found")
+		return
+	}
+	if !s.authorizeSession(claims, code) {
+		log.Printf("🚫 Refused %s of session %s to %s", r.Method, code, claims.Subject)
+		writeAPIError(w, http.StatusForbidden, ErrForbidden, "Not on the panel for this session")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		detail, found := s.sessionDetail(code)
+		if !found {
+			writeAPIError(w, http.StatusNotFound, ErrInvalidCode, "Session not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, detail)
+
+	case http.MethodDelete:
+		endedHere := s.endLocalSession(code, "Ended by "+claims.Subject)
+		endedRemote := s.isRemoteCode(code)
+		if !endedHere && !endedRemote {
+			writeAPIError(w, http.StatusNotFound, ErrInvalidCode, "Session not found")
+			return
+		}
+
+		// Other nodes close their side of the session too
+		s.publishSessionEvent(code, clusterEvent{Kind: clusterSessionEnded, Reason: "Ended by " + claims.Subject})
+
+		log.Printf("🛑 Session %s ended through the API by %s", code, claims.Subject)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"code":  code,
+			"ended": true,
+		})
+
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, ErrInvalidMessage, "Method not allowed")
+	}
+}
+
+// Detail view of a session or pending code
+func (s *Server) sessionDetail(code string) (*SessionDetail, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if session := s.sessions[code]; session != nil {
+		session.mu.RLock()
+		defer session.mu.RUnlock()
+
+		detail := &SessionDetail{
+			SessionSummary: s.sessionSummary(code, session),
+			Info:           session.Info,
+			RemoteClient:   session.remoteClient,
+			ViewerList:     []ConnectionInfo{},
+			RemoteViewers:  []ViewerIdentity{},
+		}
+		if session.Client != nil && session.Client.IsOpen() {
+			info := session.Client.Info()
+			detail.Client = &info
+		}
+		for _, viewer := range session.openViewers() {
+			detail.ViewerList = append(detail.ViewerList, viewer.Info())
+		}
+		for _, viewer := range session.remoteViewers {
+			detail.RemoteViewers = append(detail.RemoteViewers, viewer)
+		}
+		return detail, true
+	}
+
+	if pending := s.pendingCodes[code]; pending != nil {
+		detail := &SessionDetail{
+			SessionSummary: s.pendingSummary(code, pending),
+			ViewerList:     []ConnectionInfo{},
+			RemoteViewers:  []ViewerIdentity{},
+		}
+		for _, viewer := range pending.Viewers {
+			detail.ViewerList = append(detail.ViewerList, viewer.Info())
+		}
+		return detail, true
+	}
+
+	return nil, false
+}
+
+// Handle POST /api/codes, issuing a code before any viewer connects
+func (s *Server) handleAPICodes(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, ErrInvalidMessage, "Method not allowed")
+		return
+	}
+
+	var req CreateCodeRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, ErrInvalidPayload, "Invalid request body")
+			return
+		}
+	}
+	if req.Subject != "" && !claims.Admin {
+		log.Printf("🚫 %s tried to issue a code for %s", claims.Subject, req.Subject)
+		writeAPIError(w, http.StatusForbidden, ErrForbidden, "Only admins may choose who a code is issued for")
+		return
+	}
+	if req.Subject == "" {
+		req.Subject = claims.Subject
+	}
+	if req.Mode != "" && !req.Mode.IsValid() {
+		writeAPIError(w, http.StatusBadRequest, ErrInvalidPayload, "Mode must be mesh or sfu")
+		return
+	}
+
+	code, err := s.generateUniqueCode()
+	if err != nil {
+		log.Printf("Error generating code: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, ErrInternal, "Could not generate code")
+		return
+	}
+
+	now := time.Now()
+	pending := &PendingCode{
+		ID:        newSessionID(code, now),
+		CreatedAt: now,
+		Subject:   req.Subject,
+		Mode:      s.sessionMode(req.Mode),
+		Viewers:   make(map[string]*Connection),
+	}
+
+	s.mu.Lock()
+	s.pendingCodes[code] = pending
+	s.mu.Unlock()
+
+	s.persistPending(code, pending)
+	s.holdCode(code)
+
+	log.Printf("🎲 Generated new code through the API for %s: %s", req.Subject, code)
+
+	writeJSON(w, http.StatusCreated, CreateCodeResponse{
+		Code:      code,
+		Subject:   req.Subject,
+		Mode:      pending.Mode,
+		ExpiresAt: pending.CreatedAt.Add(s.config.PendingCodeTTL).UnixMilli(),
+	})
+}
+
+// Tear down a session or pending code on this node and disconnect its peers
+func (s *Server) endLocalSession(code, reason string) bool {
+	s.mu.Lock()
+	session := s.sessions[code]
+	pending := s.pendingCodes[code]
+	var conns []*Connection
+	if pending != nil {
+		for _, viewer := range pending.Viewers {
+			conns = append(conns, viewer)
+		}
+	}
+	delete(s.sessions, code)
+	delete(s.pendingCodes, code)
+	s.closeWaitingRoom(code, reason)
+	if session != nil || pending != nil {
+		s.retireCode(code)
+	}
+	s.mu.Unlock()
+
+	if session == nil && pending == nil {
+		return false
+	}
+
+	var client *Connection
+	var id string
+	if pending != nil {
+		id = pending.ID
+	}
+	if session != nil {
+		session.mu.Lock()
+		id = session.id()
+		if session.Client != nil && session.Client.IsOpen() {
+			client = session.Client
+		}
+		conns = append(conns, session.openViewers()...)
+		recorder := session.recorder
+		session.recorder = nil
+		if session.detector != nil {
+			session.detector.stop()
+			session.detector = nil
+		}
+		if session.sfu != nil {
+			session.sfu.Close()
+			session.sfu = nil
+		}
+		session.mu.Unlock()
+
+		if recorder != nil {
+			recorder.Close()
+		}
+		s.forgetSession(code)
+	}
+	if pending != nil {
+		s.forgetPending(code)
+	}
+
+	notice := SessionEndedPayload{
+		Timestamp: getCurrentTimestamp(),
+		Code:      code,
+		Reason:    reason,
+	}
+	if client != nil {
+		// Older clients only know how to leave on an admin disconnect
+		client.Send(createSimpleResponseMessage(AdminCommand, AdminCommandPayload{Command: "disconnect", Timestamp: getCurrentTimestamp()}))
+		client.Send(createSimpleResponseMessage(SessionEnded, notice))
+		conns = append(conns, client)
+	}
+	for _, viewer := range conns {
+		if viewer != client {
+			viewer.Send(createSimpleResponseMessage(SessionEnded, notice))
+		}
+	}
+
+	s.auditServerEvent(id, SessionEnded, notice)
+
+	log.Printf("🛑 Ended session %s: %s", code, reason)
+
+	go func() {
+		time.Sleep(s.config.ErrorCloseDelay)
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	return true
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPICodesSubjectIsAdminOnly(t *testing.T) {
+	s := newTestServer(t)
+	signer := NewTokenSigner([]byte("secret"), time.Hour)
+	s.auth = &Authenticator{tokens: signer}
+
+	post := func(subject string, admin bool, body string) *httptest.ResponseRecorder {
+		token, _, _ := signer.Issue(subject, admin)
+		request := httptest.NewRequest("POST", "/api/codes", strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		s.handleAPICodes(recorder, request)
+		return recorder
+	}
+	issued := func(response *httptest.ResponseRecorder) string {
+		var created CreateCodeResponse
+		json.Unmarshal(response.Body.Bytes(), &created)
+		if s.pendingCodes[created.Code] == nil || s.pendingCodes[created.Code].Subject != created.Subject {
+			t.Fatalf("pending code for %s missing", created.Code)
+		}
+		return created.Subject
+	}
+
+	for _, body := range []string{`{"subject":"alice"}`, `{"subject":"dave"}`} {
+		if response := post("dave", false, body); response.Code != 403 {
+			t.Fatalf("non-admin set %s: %d", body, response.Code)
+		}
+	}
+	if len(s.pendingCodes) != 0 {
+		t.Fatalf("refused requests left %d pending code(s)", len(s.pendingCodes))
+	}
+
+	if response := post("dave", false, `{"mode":"mesh"}`); response.Code != 201 || issued(response) != "dave" {
+		t.Fatalf("non-admin without a subject: %d %s", response.Code, response.Body)
+	}
+	if response := post("carol", true, `{"subject":"alice"}`); response.Code != 201 || issued(response) != "alice" {
+		t.Fatalf("admin with a subject: %d %s", response.Code, response.Body)
+	}
+}
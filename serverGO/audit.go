@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Audit defaults
+const (
+	DEFAULT_AUDIT_DIR   = "audit"
+	AUDIT_MAX_LINE_SIZE = 1 << 20 // longest event line the timeline reader accepts
+)
+
+// Message types whose payload is too noisy or large to keep in the audit log
+var auditOmitPayload = map[MessageType]bool{
+	Signal:      true,
+	ProcessInfo: true,
+}
+
+// One entry in a session's timeline
+type AuditEvent struct {
+	Time      int64           `json:"time"`
+	Type      MessageType     `json:"type"`
+	ConnID    string          `json:"connId"`
+	Role      Role            `json:"role,omitempty"`
+	PanelRole PanelRole       `json:"panelRole,omitempty"`
+	Subject   string          `json:"subject,omitempty"`
+	Target    string          `json:"target,omitempty"`
+	Size      int             `json:"size,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// Append-only per-session event log, one JSON-lines file per session ID.
+// Events are queued and written by a single writer goroutine, so callers
+// holding session locks never wait on the disk.
+type AuditLog struct {
+	dir     string
+	ready   bool          // directory created, only the writer touches it
+	queue   []auditLine   // events awaiting the writer
+	queued  uint64        // events queued since open
+	written uint64        // queued events the writer has finished with
+	flushed *sync.Cond    // broadcast whenever written moves
+	wake    chan struct{} // signals the writer, closed on Close
+	done    chan struct{} // closed once the writer has flushed and exited
+	closed  bool
+	mu      sync.Mutex
+}
+
+// Encoded event waiting for the writer
+type auditLine struct {
+	id   string
+	data []byte
+}
+
+// Create an audit log writing under dir
+func NewAuditLog(dir string) *AuditLog {
+	a := &AuditLog{
+		dir:  dir,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	a.flushed = sync.NewCond(&a.mu)
+	go a.writeLoop()
+	return a
+}
+
+func (a *AuditLog) path(id string) string {
+	return filepath.Join(a.dir, id+".jsonl")
+}
+
+// Queue an event for a session's log
+func (a *AuditLog) Append(id string, event AuditEvent) error {
+	if !isSafePathElement(id) {
+		return fmt.Errorf("invalid session ID %q", id)
+	}
+	if event.Time == 0 {
+		event.Time = getCurrentTimestamp()
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return fmt.Errorf("audit log is closed")
+	}
+	a.queue = append(a.queue, auditLine{id: id, data: line})
+	a.queued++
+
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Write queued events until the log closes, then flush what is left
+func (a *AuditLog) writeLoop() {
+	defer close(a.done)
+	for range a.wake {
+		a.flush()
+	}
+	a.flush()
+}
+
+// Write the queued events as one batch
+func (a *AuditLog) flush() {
+	a.mu.Lock()
+	batch := a.queue
+	a.queue = nil
+	a.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+	a.write(batch)
+
+	a.mu.Lock()
+	a.written += uint64(len(batch))
+	a.flushed.Broadcast()
+	a.mu.Unlock()
+}
+
+// Write a batch, opening each session's log once
+func (a *AuditLog) write(batch []auditLine) {
+	if !a.ready {
+		if err := os.MkdirAll(a.dir, 0750); err != nil {
+			log.Printf("Error creating audit directory %s: %v", a.dir, err)
+			return
+		}
+		a.ready = true
+	}
+
+	var ids []string
+	lines := make(map[string][]byte)
+	for _, line := range batch {
+		if _, seen := lines[line.id]; !seen {
+			ids = append(ids, line.id)
+		}
+		lines[line.id] = append(lines[line.id], line.data...)
+	}
+
+	for _, id := range ids {
+		file, err := os.OpenFile(a.path(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			log.Printf("Error opening audit log %s: %v", id, err)
+			continue
+		}
+		if _, err := file.Write(lines[id]); err != nil {
+			log.Printf("Error writing audit log %s: %v", id, err)
+		}
+		file.Close()
+	}
+}
+
+// Wait until every event queued so far is on disk
+func (a *AuditLog) wait() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	target := a.queued
+	for a.written < target {
+		a.flushed.Wait()
+	}
+}
+
+// Flush queued events and stop the writer
+func (a *AuditLog) Close() {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return
+	}
+	a.closed = true
+	close(a.wake)
+	a.mu.Unlock()
+
+	<-a.done
+}
+
+// Read a session's events at or after since (unix millis), once the events
+// queued so far are written
+func (a *AuditLog) Timeline(id string, since int64) ([]AuditEvent, error) {
+	events := []AuditEvent{}
+	if !isSafePathElement(id) {
+		return events, nil
+	}
+	a.wait()
+
+	file, err := os.Open(a.path(id))
+	if os.IsNotExist(err) {
+		return events, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), AUDIT_MAX_LINE_SIZE)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			// A torn final line from a crash should not hide the rest
+			continue
+		}
+		if event.Time >= since {
+			events = append(events, event)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading audit log: %w", err)
+	}
+
+	return events, nil
+}
+
+// Check if an interviewer took part in a session, going by its log
+func (a *AuditLog) Involves(id, subject string) (bool, error) {
+	if subject == "" {
+		// Server events and clients carry no subject
+		return false, nil
+	}
+	events, err := a.Timeline(id, 0)
+	if err != nil {
+		return false, err
+	}
+	for _, event := range events {
+		if event.Subject == subject {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Record an event for a connection in its session's log, errors are logged
+// and otherwise ignored
+func (s *Server) auditEvent(conn *Connection, eventType MessageType, event AuditEvent) {
+	id := conn.SessionID
+	if s.audit == nil || id == "" {
+		return
+	}
+
+	event.Type = eventType
+	event.ConnID = conn.ID
+	event.Role = conn.Role
+	event.PanelRole = conn.PanelRole
+	event.Subject = conn.Subject
+
+	if err := s.audit.Append(id, event); err != nil {
+		log.Printf("Error writing audit event for %s: %v", id, err)
+	}
+}
+
+// Record a server event with its payload in a session's log
+func (s *Server) auditServerEvent(id string, eventType MessageType, payload interface{}) {
+	if s.audit == nil || id == "" {
+		return
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	if err := s.audit.Append(id, AuditEvent{Type: eventType, Payload: data}); err != nil {
+		log.Printf("Error writing audit event for %s: %v", id, err)
+	}
+}
+
+// Record a handled message once the connection belongs to a session
+func (s *Server) auditMessage(conn *Connection, msg *Message) {
+	code := conn.SessionCode
+	if code == "" || (msg.Code != "" && msg.Code != code) {
+		// Rejected registrations and foreign codes never get a log file
+		return
+	}
+
+	event := AuditEvent{
+		Target: msg.Target,
+		Size:   len(msg.Payload),
+	}
+	if !auditOmitPayload[msg.Type] && len(msg.Payload) > 0 && json.Valid(msg.Payload) {
+		event.Payload = msg.Payload
+	}
+
+	s.auditEvent(conn, msg.Type, event)
+}
+
+// Handle GET /audit/{id}, optionally filtered with ?since=<unix millis>
+func (s *Server) handleAuditTimeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims, err := s.auth.VerifyRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/audit/"), "/")
+	if !isSafePathElement(id) {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.authorizeSessionID(claims, id) {
+		log.Printf("🚫 %s asked for the timeline of %s without being on its panel", claims.Subject, id)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var since int64
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid since parameter", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+
+	events, err := s.audit.Timeline(id, since)
+	if err != nil {
+		log.Printf("Error reading audit timeline for %s: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":          id,
+		"code":        sessionIDCode(id),
+		"generatedAt": time.Now().UnixMilli(),
+		"events":      events,
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuditLogInvolves(t *testing.T) {
+	audit := NewAuditLog(t.TempDir())
+
+	// Two sessions that drew the same code
+	audit.Append("123456-1000", AuditEvent{Time: 1000, Type: Register, ConnID: "a", Subject: "alice"})
+	audit.Append("123456-1000", AuditEvent{Time: 2000, Type: ClientDisconnected, ConnID: "c"})
+	audit.Append("123456-5000", AuditEvent{Time: 5000, Type: Register, ConnID: "b", Subject: "bob"})
+
+	cases := []struct {
+		id, subject string
+		want        bool
+	}{
+		{"123456-1000", "alice", true},
+		{"123456-1000", "bob", false},
+		{"123456-5000", "bob", true},
+		{"123456-5000", "alice", false},
+		{"123456-9000", "alice", false},
+		{"123456-1000", "", false},
+	}
+	for _, c := range cases {
+		if got, err := audit.Involves(c.id, c.subject); err != nil || got != c.want {
+			t.Errorf("Involves(%s, %q) = %t, %v", c.id, c.subject, got, err)
+		}
+	}
+
+	if events, _ := audit.Timeline("123456-1000", 1500); len(events) != 1 || events[0].Type != ClientDisconnected {
+		t.Fatalf("timeline since 1500: %+v", events)
+	}
+	if err := audit.Append("../123456", AuditEvent{Type: Register}); err == nil {
+		t.Fatal("appended outside the audit directory")
+	}
+}
+
+func TestAuthorizeSessionID(t *testing.T) {
+	s := newTestServer(t)
+	s.audit = NewAuditLog(t.TempDir())
+
+	// alice ran the first interview on 111111, bob opened the code again since
+	ended := newSessionID("111111", time.UnixMilli(1000))
+	s.audit.Append(ended, AuditEvent{Type: Register, ConnID: "a", Subject: "alice"})
+	s.pendingCodes["111111"] = &PendingCode{
+		ID:        newSessionID("111111", time.UnixMilli(2000)),
+		CreatedAt: time.UnixMilli(2000),
+		Subject:   "bob",
+		Viewers:   make(map[string]*Connection),
+	}
+	live := s.pendingCodes["111111"].ID
+
+	// erin watches 222222 opened by carol
+	s.sessions["222222"] = &Session{
+		Viewers: map[string]*Connection{"e": {ID: "e", Subject: "erin"}},
+		Info:    &SessionInfo{ID: newSessionID("222222", time.UnixMilli(3000)), Subject: "carol"},
+	}
+	watched := s.sessions["222222"].Info.ID
+
+	cases := []struct {
+		subject string
+		admin   bool
+		id      string
+		want    bool
+	}{
+		{"alice", false, ended, true},
+		{"alice", false, live, false},
+		{"bob", false, live, true},
+		{"bob", false, ended, false},
+		{"erin", false, watched, true},
+		{"carol", false, watched, true},
+		{"mallory", false, ended, false},
+		{"mallory", false, live, false},
+		{"mallory", false, watched, false},
+		{"mallory", true, ended, true},
+		{"alice", false, "111111", false},
+	}
+	for _, c := range cases {
+		claims := &TokenClaims{Subject: c.subject, Admin: c.admin}
+		if got := s.authorizeSessionID(claims, c.id); got != c.want {
+			t.Errorf("%s (admin %t) on %s: got %t", c.subject, c.admin, c.id, got)
+		}
+	}
+
+	// The live code answers to its current panel only
+	if s.authorizeSession(&TokenClaims{Subject: "alice"}, "111111") {
+		t.Fatal("alice reached the new session on a reused code")
+	}
+	if !s.authorizeSession(&TokenClaims{Subject: "bob"}, "111111") {
+		t.Fatal("bob refused on the code they opened")
+	}
+}
+
+func TestAuditLogWritesQueuedEvents(t *testing.T) {
+	dir := t.TempDir()
+	audit := NewAuditLog(dir)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				id := fmt.Sprintf("12345%d-1000", i%3)
+				audit.Append(id, AuditEvent{Time: int64(i + 1), Type: Signal, ConnID: fmt.Sprint(worker)})
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	// Reads wait for the writer
+	if events, err := audit.Timeline("123450-1000", 0); err != nil || len(events) != 4*34 {
+		t.Fatalf("read %d events, %v", len(events), err)
+	}
+
+	audit.Append("123451-1000", AuditEvent{Type: SessionEnded})
+	audit.Close()
+	if err := audit.Append("123451-1000", AuditEvent{Type: SessionEnded}); err == nil {
+		t.Fatal("appended after close")
+	}
+
+	events, err := NewAuditLog(dir).Timeline("123451-1000", 0)
+	if err != nil || len(events) != 4*33+1 || events[len(events)-1].Type != SessionEnded {
+		t.Fatalf("read %d events after close, %v", len(events), err)
+	}
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
+)
+
+// Authentication defaults
+const (
+	DEFAULT_USERS_FILE = "users.json"
+	DEFAULT_TOKEN_TTL  = 12 * time.Hour
+)
+
+// Interviewer account
+type User struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"passwordHash"`
+	Admin        bool      `json:"admin,omitempty"` // may see and end every session
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// Hash compared against when the username is unknown
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("interview-dummy-password"), bcrypt.DefaultCost)
+
+// Local user store backed by a JSON file
+type UserStore struct {
+	path  string
+	users map[string]*User
+	mu    sync.RWMutex
+}
+
+// Load user store from disk, a missing file yields an empty store
+func LoadUserStore(path string) (*UserStore, error) {
+	store := &UserStore{
+		path:  path,
+		users: make(map[string]*User),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading user store: %w", err)
+	}
+
+	var users []*User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("parsing user store: %w", err)
+	}
+	for _, user := range users {
+		store.users[user.Username] = user
+	}
+
+	return store, nil
+}
+
+// Add or replace a user and persist the store
+func (u *UserStore) Add(username, password string, admin bool) error {
+	if username == "" || password == "" {
+		return fmt.Errorf("username and password are required")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("hashing password: %w", err)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.users[username] = &User{
+		Username:     username,
+		PasswordHash: string(hash),
+		Admin:        admin,
+		CreatedAt:    time.Now(),
+	}
+
+	return u.save()
+}
+
+// Verify a username and password pair
+func (u *UserStore) Verify(username, password string) bool {
+	u.mu.RLock()
+	user := u.users[username]
+	u.mu.RUnlock()
+
+	if user == nil {
+		// Compare anyway so unknown users take as long as wrong passwords
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+}
+
+// Check whether a user holds the admin role
+func (u *UserStore) IsAdmin(username string) bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	user := u.users[username]
+	return user != nil && user.Admin
+}
+
+// Number of users in the store
+func (u *UserStore) Count() int {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return len(u.users)
+}
+
+// Write the store to disk, caller must hold u.mu
+func (u *UserStore) save() error {
+	users := make([]*User, 0, len(u.users))
+	for _, user := range u.users {
+		users = append(users, user)
+	}
+
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := u.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("writing user store: %w", err)
+	}
+	return os.Rename(tmp, u.path)
+}
+
+// Signed token claims
+type TokenClaims struct {
+	Subject   string `json:"sub"`
+	Admin     bool   `json:"adm,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// HMAC-SHA256 signer for expiring bearer tokens (JWT compatible)
+type TokenSigner struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Create new token signer
+func NewTokenSigner(secret []byte, ttl time.Duration) *TokenSigner {
+	return &TokenSigner{
+		secret: secret,
+		ttl:    ttl,
+	}
+}
+
+// Issue a token for the given subject
+func (t *TokenSigner) Issue(subject string, admin bool) (string, *TokenClaims, error) {
+	now := time.Now()
+	claims := &TokenClaims{
+		Subject:   subject,
+		Admin:     admin,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(t.ttl).Unix(),
+	}
+
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(body)
+	return unsigned + "." + t.sign(unsigned), claims, nil
+}
+
+// Verify a token signature and expiry
+func (t *TokenSigner) Verify(token string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	expected := t.sign(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+
+	var claims TokenClaims
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("token has no subject")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("token expired")
+	}
+
+	return &claims, nil
+}
+
+func (t *TokenSigner) sign(unsigned string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Authenticator ties the user store and token signer together
+type Authenticator struct {
+	users  *UserStore
+	tokens *TokenSigner
+}
+
+// Build authenticator from the auth settings
+func NewAuthenticator(c *Config) (*Authenticator, error) {
+	users, err := LoadUserStore(c.AuthUsersFile)
+	if err != nil {
+		return nil, err
+	}
+	if users.Count() == 0 {
+		log.Printf("Warning: No interviewer accounts in %s, add one with: interview-server adduser <username>", c.AuthUsersFile)
+	}
+
+	secret := []byte(c.AuthSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("generating token secret: %w", err)
+		}
+		log.Println("Warning: AUTH_SECRET not set, tokens will not survive a restart")
+	}
+
+	return &Authenticator{
+		users:  users,
+		tokens: NewTokenSigner(secret, c.AuthTokenTTL),
+	}, nil
+}
+
+// Extract a bearer token from the Authorization header or token query param
+func bearerToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	// Browsers cannot set headers on WebSocket upgrades
+	return r.URL.Query().Get("token")
+}
+
+// Whether the request carries an interviewer credential
+func hasCredentials(r *http.Request) bool {
+	return bearerToken(r) != "" || clientCertificate(r) != nil
+}
+
+// Authenticate a request by verified client certificate or bearer token
+func (a *Authenticator) VerifyRequest(r *http.Request) (*TokenClaims, error) {
+	if cert := clientCertificate(r); cert != nil {
+		if cert.Subject.CommonName == "" {
+			return nil, fmt.Errorf("client certificate has no common name")
+		}
+		return &TokenClaims{
+			Subject:   cert.Subject.CommonName,
+			IssuedAt:  cert.NotBefore.Unix(),
+			ExpiresAt: cert.NotAfter.Unix(),
+		}, nil
+	}
+	return a.tokens.Verify(bearerToken(r))
+}
+
+// Check if an interviewer may see the session or pending code live under
+// code: the interviewer who opened it, anyone on its panel now or earlier,
+// or the panel of its scheduled interview. Admins may see every session.
+func (s *Server) authorizeSession(claims *TokenClaims, code string) bool {
+	if claims.Admin {
+		return true
+	}
+	subject := claims.Subject
+	if interview := s.schedule.Lookup(code); interview != nil && interview.Allows(subject) {
+		return true
+	}
+
+	id, onPanel := s.sessionPanel(code, subject)
+	if onPanel || id == "" {
+		return onPanel
+	}
+	return s.involvedIn(id, subject)
+}
+
+// Check if an interviewer may see the audit log and recordings of one
+// session. Codes are reused, so a session that has ended only answers to
+// those its own log shows took part.
+func (s *Server) authorizeSessionID(claims *TokenClaims, id string) bool {
+	if claims.Admin {
+		return true
+	}
+	code := sessionIDCode(id)
+	if current, _ := s.sessionPanel(code, claims.Subject); code != "" && current == id {
+		return s.authorizeSession(claims, code)
+	}
+	return s.involvedIn(id, claims.Subject)
+}
+
+// ID of the session or pending code live under code, and whether subject
+// opened it or sits on its panel
+func (s *Server) sessionPanel(code, subject string) (string, bool) {
+	s.mu.RLock()
+	session := s.sessions[code]
+	id := ""
+	onPanel := false
+	if pending := s.pendingCodes[code]; pending != nil {
+		id = pending.ID
+		onPanel = pending.Subject == subject
+		for _, viewer := range pending.Viewers {
+			onPanel = onPanel || viewer.Subject == subject
+		}
+	}
+	s.mu.RUnlock()
+
+	if session != nil {
+		session.mu.RLock()
+		id = session.id()
+		onPanel = onPanel || (session.Info != nil && session.Info.Subject == subject)
+		for _, viewer := range session.Viewers {
+			onPanel = onPanel || viewer.Subject == subject
+		}
+		for _, viewer := range session.remoteViewers {
+			onPanel = onPanel || viewer.Subject == subject
+		}
+		session.mu.RUnlock()
+	}
+	return id, onPanel
+}
+
+// Check if a session's audit log shows subject took part
+func (s *Server) involvedIn(id, subject string) bool {
+	if s.audit == nil {
+		return false
+	}
+	involved, err := s.audit.Involves(id, subject)
+	if err != nil {
+		log.Printf("Error checking audit log of %s for %s: %v", id, subject, err)
+	}
+	return involved
+}
+
+// Login request body
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Login response body
+type LoginResponse struct {
+	Token     string `json:"token"`
+	Subject   string `json:"subject"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// Handle interviewer login
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if !s.allowCORS(w, r, http.MethodPost) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LoginRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Throttle password guessing against one account or from one address
+	ip := s.clientIP(r)
+	wait, locked := s.attempts.Locked(loginIPKey(ip))
+	if userWait, userLocked := s.attempts.Locked(loginUserKey(req.Username)); userLocked && userWait > wait {
+		wait, locked = userWait, true
+	}
+	if locked {
+		log.Printf("🔒 Refused locked-out login for %q from %s (%s remaining)", req.Username, ip, wait.Round(time.Second))
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(wait.Seconds())+1))
+		http.Error(w, "Too many failed attempts", http.StatusTooManyRequests)
+		return
+	}
+
+	if !s.auth.users.Verify(req.Username, req.Password) {
+		log.Printf("🔒 Failed login for %q from %s", req.Username, ip)
+		if lockout := s.attempts.Fail(loginIPKey(ip)); lockout > 0 {
+			log.Printf("🔒 Locking out logins from %s for %s after repeated failures", ip, lockout)
+		}
+		if lockout := s.attempts.Fail(loginUserKey(req.Username)); lockout > 0 {
+			log.Printf("🔒 Locking out logins for %q for %s after repeated failures", req.Username, lockout)
+		}
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+	s.attempts.Reset(loginUserKey(req.Username))
+
+	token, claims, err := s.auth.tokens.Issue(req.Username, s.auth.users.IsAdmin(req.Username))
+	if err != nil {
+		log.Printf("Error issuing token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("🔑 Interviewer %s logged in", req.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LoginResponse{
+		Token:     token,
+		Subject:   claims.Subject,
+		ExpiresAt: claims.ExpiresAt * 1000,
+	})
+}
+
+// Handle the adduser command line mode, the password is read from stdin so
+// it stays out of shell history and the process list
+func runAddUser(args []string) {
+	admin := len(args) > 0 && args[0] == "--admin"
+	if admin {
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		log.Fatal("Usage: interview-server adduser [--admin] <username> (password on stdin)")
+	}
+
+	password, err := readPassword(os.Stdin)
+	if err != nil {
+		log.Fatal("Failed to read password:", err)
+	}
+
+	// Accounts go wherever the server will look for them
+	config, _, err := LoadConfig(nil)
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	usersFile := config.AuthUsersFile
+
+	store, err := LoadUserStore(usersFile)
+	if err != nil {
+		log.Fatal("Failed to load user store:", err)
+	}
+	if err := store.Add(args[0], password, admin); err != nil {
+		log.Fatal("Failed to add user:", err)
+	}
+
+	if admin {
+		log.Printf("✅ Saved admin %s to %s", args[0], usersFile)
+	} else {
+		log.Printf("✅ Saved interviewer %s to %s", args[0], usersFile)
+	}
+}
+
+// Read a password line, prompting without echo when stdin is a terminal
+func readPassword(in *os.File) (string, error) {
+	var line string
+	if fd := int(in.Fd()); term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		raw, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		line = string(raw)
+	} else {
+		var err error
+		line, err = bufio.NewReader(in).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("no password given")
+	}
+	return password, nil
+}
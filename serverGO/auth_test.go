@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Replace the claims of a signed token, keeping its signature
+func withClaims(t *testing.T, token string, claims TokenClaims) string {
+	t.Helper()
+	body, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString(body)
+	return strings.Join(parts, ".")
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	signer := NewTokenSigner([]byte("secret"), time.Hour)
+	token, issued, err := signer.Issue("alice", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := signer.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *claims != *issued || claims.Subject != "alice" || !claims.Admin {
+		t.Fatalf("verified %+v, issued %+v", claims, issued)
+	}
+	if claims.ExpiresAt-claims.IssuedAt != int64(time.Hour/time.Second) {
+		t.Fatalf("token lives %ds, want an hour", claims.ExpiresAt-claims.IssuedAt)
+	}
+}
+
+func TestTokenRejectsForgeries(t *testing.T) {
+	signer := NewTokenSigner([]byte("secret"), time.Hour)
+	token, claims, err := signer.Issue("bob", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	elevated := *claims
+	elevated.Admin = true
+	renamed := *claims
+	renamed.Subject = "alice"
+	otherKey, _, _ := NewTokenSigner([]byte("other"), time.Hour).Issue("bob", false)
+	parts := strings.Split(token, ".")
+	noneHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+
+	cases := map[string]string{
+		"admin claim added":  withClaims(t, token, elevated),
+		"subject changed":    withClaims(t, token, renamed),
+		"signature replaced": parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2])),
+		"signature dropped":  parts[0] + "." + parts[1] + ".",
+		"wrong key":          otherKey,
+		"unsigned":           noneHeader + "." + parts[1] + ".",
+		"extra segment":      token + ".x",
+		"empty":              "",
+	}
+	for name, forged := range cases {
+		if claims, err := signer.Verify(forged); err == nil {
+			t.Errorf("%s: accepted as %+v", name, claims)
+		}
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	signer := NewTokenSigner([]byte("secret"), time.Hour)
+
+	expired, _, err := NewTokenSigner([]byte("secret"), -time.Second).Issue("alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signer.Verify(expired); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("expired token gave %v", err)
+	}
+
+	// Pushing the expiry forward breaks the signature
+	token, claims, _ := NewTokenSigner([]byte("secret"), -time.Second).Issue("alice", false)
+	extended := *claims
+	extended.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	if _, err := signer.Verify(withClaims(t, token, extended)); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Fatalf("extended token gave %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Backplane kinds
+const (
+	BackplaneInProcess = "inprocess" // single node, or nodes sharing one process in tests
+	BackplaneRedis     = "redis"     // Redis pub/sub at redisAddr
+)
+
+// Redis address used when none is configured
+const DEFAULT_REDIS_ADDR = "localhost:6379"
+
+// Backplane is a pub/sub bus connecting server nodes. Messages published on a
+// channel are delivered, in order, to every node subscribed to it, including
+// the publisher.
+type Backplane interface {
+	Publish(channel string, payload []byte) error
+	Subscribe(channel string, handler func(payload []byte)) error
+	Unsubscribe(channel string) error
+	Close() error
+}
+
+// Open the backplane selected by the backplane setting
+func NewBackplane(c *Config) (Backplane, error) {
+	switch c.Backplane {
+	case BackplaneInProcess:
+		return NewInProcessBackplane(nil), nil
+	case BackplaneRedis:
+		return DialRedisBackplane(c.RedisAddr, c.RedisPassword)
+	default:
+		return nil, fmt.Errorf("unknown backplane %q", c.Backplane)
+	}
+}
+
+// Hub shared by in-process backplanes, one per simulated node
+type InProcessHub struct {
+	subscribers map[string]map[*InProcessBackplane]bool
+	mu          sync.RWMutex
+}
+
+// Create new in-process hub
+func NewInProcessHub() *InProcessHub {
+	return &InProcessHub{
+		subscribers: make(map[string]map[*InProcessBackplane]bool),
+	}
+}
+
+type inProcessDelivery struct {
+	channel string
+	payload []byte
+}
+
+// In-process backplane, a single node unless several share a hub.
+// Deliveries are queued without bound so publishers never block.
+type InProcessBackplane struct {
+	hub      *InProcessHub
+	handlers map[string]func([]byte)
+	queue    []inProcessDelivery
+	wake     chan struct{}
+	closed   bool
+	mu       sync.Mutex
+}
+
+// Create new in-process backplane attached to hub, or a private hub if nil
+func NewInProcessBackplane(hub *InProcessHub) *InProcessBackplane {
+	if hub == nil {
+		hub = NewInProcessHub()
+	}
+
+	b := &InProcessBackplane{
+		hub:      hub,
+		handlers: make(map[string]func([]byte)),
+		wake:     make(chan struct{}, 1),
+	}
+	go b.deliver()
+	return b
+}
+
+// Queue a delivery for this node
+func (b *InProcessBackplane) enqueue(delivery inProcessDelivery) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.queue = append(b.queue, delivery)
+
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Deliver queued messages on a single goroutine to preserve ordering
+func (b *InProcessBackplane) deliver() {
+	for range b.wake {
+		for {
+			b.mu.Lock()
+			if b.closed || len(b.queue) == 0 {
+				b.mu.Unlock()
+				break
+			}
+			delivery := b.queue[0]
+			b.queue = b.queue[1:]
+			handler := b.handlers[delivery.channel]
+			b.mu.Unlock()
+
+			if handler != nil {
+				handler(delivery.payload)
+			}
+		}
+	}
+}
+
+func (b *InProcessBackplane) Publish(channel string, payload []byte) error {
+	b.hub.mu.RLock()
+	subscribers := make([]*InProcessBackplane, 0, len(b.hub.subscribers[channel]))
+	for subscriber := range b.hub.subscribers[channel] {
+		subscribers = append(subscribers, subscriber)
+	}
+	b.hub.mu.RUnlock()
+
+	for _, subscriber := range subscribers {
+		subscriber.enqueue(inProcessDelivery{channel: channel, payload: payload})
+	}
+	return nil
+}
+
+func (b *InProcessBackplane) Subscribe(channel string, handler func([]byte)) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return fmt.Errorf("backplane is closed")
+	}
+	b.handlers[channel] = handler
+	b.mu.Unlock()
+
+	b.hub.mu.Lock()
+	defer b.hub.mu.Unlock()
+	if b.hub.subscribers[channel] == nil {
+		b.hub.subscribers[channel] = make(map[*InProcessBackplane]bool)
+	}
+	b.hub.subscribers[channel][b] = true
+	return nil
+}
+
+func (b *InProcessBackplane) Unsubscribe(channel string) error {
+	b.mu.Lock()
+	delete(b.handlers, channel)
+	b.mu.Unlock()
+
+	b.hub.mu.Lock()
+	defer b.hub.mu.Unlock()
+	delete(b.hub.subscribers[channel], b)
+	if len(b.hub.subscribers[channel]) == 0 {
+		delete(b.hub.subscribers, channel)
+	}
+	return nil
+}
+
+func (b *InProcessBackplane) Close() error {
+	b.hub.mu.Lock()
+	for channel, subscribers := range b.hub.subscribers {
+		delete(subscribers, b)
+		if len(subscribers) == 0 {
+			delete(b.hub.subscribers, channel)
+		}
+	}
+	b.hub.mu.Unlock()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		b.queue = nil
+		close(b.wake)
+	}
+	return nil
+}
+
+// Log and drop backplane errors, routing is best effort
+func logBackplaneError(action string, err error) {
+	if err != nil {
+		log.Printf("Warning: Backplane %s failed: %v", action, err)
+	}
+}
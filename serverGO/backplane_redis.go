@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Redis backplane connection settings
+const (
+	REDIS_DIAL_TIMEOUT    = 5 * time.Second
+	REDIS_IO_TIMEOUT      = 5 * time.Second  // deadline for a command and its reply
+	REDIS_PING_INTERVAL   = 15 * time.Second // keepalive on the subscriber connection
+	REDIS_RECONNECT_DELAY = 1 * time.Second
+	REDIS_RECONNECT_MAX   = 30 * time.Second
+)
+
+// Error reply from a Redis-protocol server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// Backplane speaking the Redis protocol (RESP2) over plain TCP. It works with
+// Redis, Valkey, KeyDB or any local stand-in implementing PUBLISH/SUBSCRIBE.
+type RedisBackplane struct {
+	addr      string
+	password  string
+	timeout   time.Duration // deadline for each command and reply
+	pub       net.Conn
+	pubReader *bufio.Reader
+	pubMu     sync.Mutex
+	sub       net.Conn
+	subMu     sync.Mutex
+	handlers  map[string]func([]byte)
+	closed    bool
+	mu        sync.RWMutex
+}
+
+// Connect to a Redis-protocol server and start the subscriber loop
+func DialRedisBackplane(addr, password string) (*RedisBackplane, error) {
+	return dialRedisBackplane(addr, password, REDIS_IO_TIMEOUT)
+}
+
+func dialRedisBackplane(addr, password string, timeout time.Duration) (*RedisBackplane, error) {
+	b := &RedisBackplane{
+		addr:     addr,
+		password: password,
+		timeout:  timeout,
+		handlers: make(map[string]func([]byte)),
+	}
+
+	pub, pubReader, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	b.pub, b.pubReader = pub, pubReader
+
+	sub, subReader, err := b.dial()
+	if err != nil {
+		pub.Close()
+		return nil, err
+	}
+	b.sub = sub
+
+	go b.readLoop(subReader)
+	go b.pingLoop()
+
+	log.Printf("📡 Connected to Redis backplane at %s", addr)
+	return b, nil
+}
+
+// Open and authenticate a connection
+func (b *RedisBackplane) dial() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", b.addr, REDIS_DIAL_TIMEOUT)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to redis at %s: %w", b.addr, err)
+	}
+	reader := bufio.NewReader(conn)
+
+	if b.password != "" {
+		if err := b.write(conn, "AUTH", b.password); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(b.timeout))
+		if _, err := readRESP(reader); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("authenticating to redis: %w", err)
+		}
+		conn.SetReadDeadline(time.Time{})
+	}
+
+	return conn, reader, nil
+}
+
+// Write a command, giving up once the write deadline passes
+func (b *RedisBackplane) write(conn net.Conn, args ...string) error {
+	conn.SetWriteDeadline(time.Now().Add(b.timeout))
+	return writeRESPCommand(conn, args...)
+}
+
+func (b *RedisBackplane) Publish(channel string, payload []byte) error {
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+
+	// Retry once on a fresh connection if the cached one went stale
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if b.pub == nil {
+			b.pub, b.pubReader, err = b.dial()
+			if err != nil {
+				return err
+			}
+		}
+
+		err = b.write(b.pub, "PUBLISH", channel, string(payload))
+		if err == nil {
+			b.pub.SetReadDeadline(time.Now().Add(b.timeout))
+			_, err = readRESP(b.pubReader)
+			if _, isReply := err.(redisError); err == nil || isReply {
+				return err
+			}
+		}
+
+		b.pub.Close()
+		b.pub, b.pubReader = nil, nil
+	}
+	return err
+}
+
+func (b *RedisBackplane) Subscribe(channel string, handler func([]byte)) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return fmt.Errorf("backplane is closed")
+	}
+	b.handlers[channel] = handler
+	b.mu.Unlock()
+
+	return b.writeSub("SUBSCRIBE", channel)
+}
+
+func (b *RedisBackplane) Unsubscribe(channel string) error {
+	b.mu.Lock()
+	delete(b.handlers, channel)
+	b.mu.Unlock()
+
+	return b.writeSub("UNSUBSCRIBE", channel)
+}
+
+// Send a command on the subscriber connection, replies arrive in readLoop
+func (b *RedisBackplane) writeSub(args ...string) error {
+	b.subMu.Lock()
+	defer b.subMu.Unlock()
+
+	if b.sub == nil {
+		// readLoop resubscribes everything once it reconnects
+		return nil
+	}
+	return b.write(b.sub, args...)
+}
+
+// Ping the subscriber connection so readMessages notices a dead server
+func (b *RedisBackplane) pingLoop() {
+	ticker := time.NewTicker(REDIS_PING_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		b.mu.RLock()
+		closed := b.closed
+		b.mu.RUnlock()
+		if closed {
+			return
+		}
+		if err := b.writeSub("PING"); err != nil {
+			log.Printf("Warning: Redis backplane ping failed: %v", err)
+		}
+	}
+}
+
+// Read pushed messages, reconnecting and resubscribing on failure
+func (b *RedisBackplane) readLoop(reader *bufio.Reader) {
+	delay := REDIS_RECONNECT_DELAY
+
+	for {
+		err := b.readMessages(reader, b.currentSub())
+
+		b.mu.RLock()
+		closed := b.closed
+		b.mu.RUnlock()
+		if closed {
+			return
+		}
+
+		log.Printf("Warning: Redis backplane subscriber lost: %v", err)
+
+		b.subMu.Lock()
+		if b.sub != nil {
+			b.sub.Close()
+			b.sub = nil
+		}
+		b.subMu.Unlock()
+
+		for {
+			time.Sleep(delay)
+
+			conn, newReader, err := b.dial()
+			if err == nil {
+				b.subMu.Lock()
+				b.sub = conn
+				b.subMu.Unlock()
+
+				if err = b.resubscribe(); err == nil {
+					log.Printf("📡 Redis backplane subscriber reconnected")
+					reader = newReader
+					delay = REDIS_RECONNECT_DELAY
+					break
+				}
+			}
+
+			log.Printf("Warning: Redis backplane reconnect failed: %v", err)
+			if delay < REDIS_RECONNECT_MAX {
+				delay *= 2
+			}
+		}
+	}
+}
+
+// Subscribe again to every channel with a handler
+func (b *RedisBackplane) resubscribe() error {
+	b.mu.RLock()
+	channels := make([]string, 0, len(b.handlers))
+	for channel := range b.handlers {
+		channels = append(channels, channel)
+	}
+	b.mu.RUnlock()
+
+	if len(channels) == 0 {
+		return nil
+	}
+	return b.writeSub(append([]string{"SUBSCRIBE"}, channels...)...)
+}
+
+// Subscriber connection in use, nil while reconnecting
+func (b *RedisBackplane) currentSub() net.Conn {
+	b.subMu.Lock()
+	defer b.subMu.Unlock()
+	return b.sub
+}
+
+// Dispatch pushed messages until the connection fails. Pings keep traffic
+// flowing, so a read that outlasts two ping intervals means the server is gone.
+func (b *RedisBackplane) readMessages(reader *bufio.Reader, conn net.Conn) error {
+	if conn == nil {
+		return fmt.Errorf("subscriber connection closed")
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(2*REDIS_PING_INTERVAL + b.timeout))
+		reply, err := readRESP(reader)
+		if err != nil {
+			if _, isReply := err.(redisError); isReply {
+				log.Printf("Warning: Redis backplane error reply: %v", err)
+				continue
+			}
+			return err
+		}
+
+		// Pushed messages look like ["message", channel, payload]
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 3 || parts[0] != "message" {
+			continue
+		}
+		channel, _ := parts[1].(string)
+		payload, _ := parts[2].(string)
+
+		b.mu.RLock()
+		handler := b.handlers[channel]
+		b.mu.RUnlock()
+
+		if handler != nil {
+			handler([]byte(payload))
+		}
+	}
+}
+
+func (b *RedisBackplane) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+
+	b.subMu.Lock()
+	if b.sub != nil {
+		b.sub.Close()
+		b.sub = nil
+	}
+	b.subMu.Unlock()
+
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+	if b.pub != nil {
+		b.pub.Close()
+		b.pub = nil
+	}
+	return nil
+}
+
+// Write a command as a RESP array of bulk strings
+func writeRESPCommand(w io.Writer, args ...string) error {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// Read one RESP value: string, int64, nil or []interface{}
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	body := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", body)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", body)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			items[i], err = readRESP(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply type %q", line[0])
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Minimal Redis stand-in speaking just enough RESP for the backplane
+type fakeRedis struct {
+	listener    net.Listener
+	password    string
+	silent      bool // read commands but never reply
+	subscribers map[string]map[*fakeRedisConn]bool
+	mu          sync.Mutex
+}
+
+type fakeRedisConn struct {
+	conn net.Conn
+	mu   sync.Mutex
+}
+
+func (c *fakeRedisConn) reply(args ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeRESPCommand(c.conn, args...)
+}
+
+func (c *fakeRedisConn) raw(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.Write([]byte(line))
+}
+
+func startFakeRedis(t *testing.T, password string, silent bool) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{
+		listener:    listener,
+		password:    password,
+		silent:      silent,
+		subscribers: make(map[string]map[*fakeRedisConn]bool),
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			go f.serve(&fakeRedisConn{conn: conn})
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeRedis) subscriberCount(channel string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subscribers[channel])
+}
+
+func (f *fakeRedis) serve(c *fakeRedisConn) {
+	reader := bufio.NewReader(c.conn)
+	for {
+		value, err := readRESP(reader)
+		if err != nil {
+			return
+		}
+		if f.silent {
+			continue
+		}
+		items, _ := value.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		switch args[0] {
+		case "AUTH":
+			if len(args) == 2 && args[1] == f.password {
+				c.raw("+OK\r\n")
+			} else {
+				c.raw("-WRONGPASS invalid password\r\n")
+			}
+		case "PING":
+			c.raw("+PONG\r\n")
+		case "SUBSCRIBE":
+			f.mu.Lock()
+			for _, channel := range args[1:] {
+				if f.subscribers[channel] == nil {
+					f.subscribers[channel] = make(map[*fakeRedisConn]bool)
+				}
+				f.subscribers[channel][c] = true
+			}
+			f.mu.Unlock()
+			for _, channel := range args[1:] {
+				c.reply("subscribe", channel)
+			}
+		case "UNSUBSCRIBE":
+			f.mu.Lock()
+			for _, channel := range args[1:] {
+				delete(f.subscribers[channel], c)
+			}
+			f.mu.Unlock()
+		case "PUBLISH":
+			f.mu.Lock()
+			var targets []*fakeRedisConn
+			for target := range f.subscribers[args[1]] {
+				targets = append(targets, target)
+			}
+			f.mu.Unlock()
+			for _, target := range targets {
+				target.reply("message", args[1], args[2])
+			}
+			c.raw(":1\r\n")
+		}
+	}
+}
+
+// Wait for cond, failing the test after a second
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Collects payloads delivered to a subscription
+type received struct {
+	payloads []string
+	mu       sync.Mutex
+}
+
+func (r *received) handle(payload []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.payloads = append(r.payloads, string(payload))
+}
+
+func (r *received) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.payloads...)
+}
+
+func TestRESPRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeRESPCommand(&buf, "PUBLISH", "chan", "hello\r\nworld"); err != nil {
+		t.Fatal(err)
+	}
+	value, err := readRESP(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{"PUBLISH", "chan", "hello\r\nworld"}
+	if !reflect.DeepEqual(value, want) {
+		t.Fatalf("got %#v, want %#v", value, want)
+	}
+
+	replies := bufio.NewReader(bytes.NewBufferString("+OK\r\n:42\r\n$-1\r\n-ERR boom\r\n"))
+	if v, err := readRESP(replies); v != "OK" || err != nil {
+		t.Fatalf("simple string: %#v, %v", v, err)
+	}
+	if v, err := readRESP(replies); v != int64(42) || err != nil {
+		t.Fatalf("integer: %#v, %v", v, err)
+	}
+	if v, err := readRESP(replies); v != nil || err != nil {
+		t.Fatalf("nil bulk: %#v, %v", v, err)
+	}
+	if _, err := readRESP(replies); err == nil {
+		t.Fatal("error reply was not returned as an error")
+	} else if _, isReply := err.(redisError); !isReply {
+		t.Fatalf("error reply has type %T", err)
+	}
+}
+
+func TestRedisBackplaneDeliversInOrder(t *testing.T) {
+	server := startFakeRedis(t, "", false)
+
+	publisher, err := DialRedisBackplane(server.addr(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	subscriber, err := DialRedisBackplane(server.addr(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Close()
+
+	var got received
+	if err := subscriber.Subscribe("interview:codes", got.handle); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "subscription", func() bool { return server.subscriberCount("interview:codes") == 1 })
+
+	want := []string{"one", "two", "three"}
+	for _, payload := range want {
+		if err := publisher.Publish("interview:codes", []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eventually(t, "deliveries", func() bool { return len(got.get()) == len(want) })
+	if !reflect.DeepEqual(got.get(), want) {
+		t.Fatalf("got %v, want %v", got.get(), want)
+	}
+}
+
+func TestRedisBackplaneAuth(t *testing.T) {
+	server := startFakeRedis(t, "secret", false)
+
+	if _, err := DialRedisBackplane(server.addr(), "wrong"); err == nil {
+		t.Fatal("dial with a wrong password succeeded")
+	}
+	b, err := DialRedisBackplane(server.addr(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+}
+
+func TestRedisBackplanePublishTimesOut(t *testing.T) {
+	server := startFakeRedis(t, "", true)
+
+	b, err := dialRedisBackplane(server.addr(), "", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- b.Publish("interview:codes", []byte("stalled")) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("publish to a silent server succeeded")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("publish did not give up on a silent server")
+	}
+}
+
+func TestInProcessBackplaneSharedHub(t *testing.T) {
+	hub := NewInProcessHub()
+	a := NewInProcessBackplane(hub)
+	defer a.Close()
+	b := NewInProcessBackplane(hub)
+	defer b.Close()
+
+	var gotA, gotB received
+	a.Subscribe("interview:session:123456", gotA.handle)
+	b.Subscribe("interview:session:123456", gotB.handle)
+
+	for _, payload := range []string{"1", "2", "3"} {
+		a.Publish("interview:session:123456", []byte(payload))
+	}
+	eventually(t, "deliveries", func() bool { return len(gotA.get()) == 3 && len(gotB.get()) == 3 })
+	if want := []string{"1", "2", "3"}; !reflect.DeepEqual(gotB.get(), want) {
+		t.Fatalf("got %v, want %v", gotB.get(), want)
+	}
+
+	b.Unsubscribe("interview:session:123456")
+	a.Publish("interview:session:123456", []byte("4"))
+	eventually(t, "delivery to the publisher", func() bool { return len(gotA.get()) == 4 })
+	time.Sleep(20 * time.Millisecond)
+	if len(gotB.get()) != 3 {
+		t.Fatalf("unsubscribed node received %v", gotB.get())
+	}
+}
+
+// Backplane whose Publish blocks until released, like a stalled Redis
+type stalledBackplane struct {
+	*InProcessBackplane
+	release chan struct{}
+}
+
+func (b *stalledBackplane) Publish(channel string, payload []byte) error {
+	<-b.release
+	return b.InProcessBackplane.Publish(channel, payload)
+}
+
+func TestHoldCodeDoesNotWaitForBackplane(t *testing.T) {
+	hub := NewInProcessHub()
+	stalled := &stalledBackplane{InProcessBackplane: NewInProcessBackplane(hub), release: make(chan struct{})}
+	s := NewServer(DefaultConfig())
+	s.backplane = stalled
+	if err := s.startCluster(); err != nil {
+		t.Fatal(err)
+	}
+
+	observer := NewInProcessBackplane(hub)
+	defer observer.Close()
+	var got received
+	observer.Subscribe(CLUSTER_CODES_CHANNEL, got.handle)
+
+	done := make(chan struct{})
+	go func() {
+		s.holdCode("111111")
+		s.holdCode("222222")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("holdCode blocked on a stalled backplane")
+	}
+
+	// Once the backplane recovers the queued events go out in order
+	close(stalled.release)
+	eventually(t, "queued events", func() bool { return len(got.get()) == 3 })
+	var codes []string
+	for _, payload := range got.get() {
+		var event clusterEvent
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			t.Fatal(err)
+		}
+		codes = append(codes, event.Kind+":"+event.Code)
+	}
+	want := []string{clusterSyncRequest + ":", clusterCodeHeld + ":111111", clusterCodeHeld + ":222222"}
+	if !reflect.DeepEqual(codes, want) {
+		t.Fatalf("got %v, want %v", codes, want)
+	}
+	s.outbox.Close(time.Second)
+}
+
+func TestGeneratedCodesSkipCodesHeldElsewhere(t *testing.T) {
+	hub := NewInProcessHub()
+	var nodes []*Server
+	for i := 0; i < 2; i++ {
+		s := newTestServer(t)
+		s.backplane = NewInProcessBackplane(hub)
+		s.codeFormat = CodeFormat{Length: 1, Alphabet: "01"}
+		if err := s.startCluster(); err != nil {
+			t.Fatal(err)
+		}
+		defer s.outbox.Close(time.Second)
+		nodes = append(nodes, s)
+	}
+
+	held, err := nodes[0].generateUniqueCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes[0].holdCode(held)
+	eventually(t, "code announcement", func() bool { return nodes[1].isRemoteCode(held) })
+
+	for i := 0; i < 20; i++ {
+		code, err := nodes[1].generateUniqueCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code == held {
+			t.Fatalf("issued %s, already held by node %s", code, nodes[0].nodeID)
+		}
+		nodes[1].mu.Lock()
+		nodes[1].retireCode(code)
+		nodes[1].mu.Unlock()
+	}
+}
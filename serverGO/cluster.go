@@ -0,0 +1,611 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Backplane channels
+const (
+	CLUSTER_CODES_CHANNEL          = "interview:codes"
+	CLUSTER_SESSION_CHANNEL_PREFIX = "interview:session:"
+)
+
+// Cluster outbox limits
+const (
+	CLUSTER_OUTBOX_LIMIT      = 10000           // queued events beyond which new ones are dropped
+	CLUSTER_OUTBOX_FLUSH_WAIT = 5 * time.Second // how long shutdown waits for queued events
+)
+
+// Cluster event kinds
+const (
+	clusterSyncRequest  = "syncRequest"
+	clusterCodeHeld     = "codeHeld"
+	clusterCodeDropped  = "codeDropped"
+	clusterRelay        = "relay"
+	clusterClientJoined = "clientJoined"
+	clusterClientLeft   = "clientLeft"
+	clusterViewerJoined = "viewerJoined"
+	clusterViewerLeft   = "viewerLeft"
+	clusterSessionEnded = "sessionEnded"
+)
+
+// Event exchanged between nodes over the backplane
+type clusterEvent struct {
+	Kind       string           `json:"kind"`
+	Node       string           `json:"node"`
+	Code       string           `json:"code,omitempty"`
+	ToRole     Role             `json:"toRole,omitempty"`
+	Target     string           `json:"target,omitempty"`
+	Message    *ResponseMessage `json:"message,omitempty"`
+	Viewer     *ViewerIdentity  `json:"viewer,omitempty"`
+	ClientInfo interface{}      `json:"clientInfo,omitempty"`
+	Reconnect  bool             `json:"reconnect,omitempty"`
+	Reason     string           `json:"reason,omitempty"`
+	Mode       SessionMode      `json:"mode,omitempty"`
+
+	Interview *ScheduledInterview `json:"interview,omitempty"` // window of a scheduled code, with codeHeld
+}
+
+// Node identity from the nodeId setting, or hostname plus a random suffix
+func newNodeID(id string) string {
+	if id != "" {
+		return id
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "node"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+func sessionChannel(code string) string {
+	return CLUSTER_SESSION_CHANNEL_PREFIX + code
+}
+
+// Event encoded for the backplane, waiting in the outbox
+type outboxEntry struct {
+	channel string
+	payload []byte
+}
+
+// Cluster events waiting to be published. Callers often hold s.mu or
+// clusterMu, so events are queued and published in order by a single
+// goroutine and a stalled backplane never blocks them.
+type ClusterOutbox struct {
+	queue   []outboxEntry
+	dropped uint64
+	wake    chan struct{} // signals the publisher, closed on Close
+	done    chan struct{} // closed once the publisher exits
+	closed  bool
+	mu      sync.Mutex
+}
+
+// Create an empty outbox, nothing is published until Run starts
+func NewClusterOutbox() *ClusterOutbox {
+	return &ClusterOutbox{
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+}
+
+// Queue an event, dropping it if the backplane has fallen too far behind
+func (o *ClusterOutbox) Push(channel string, payload []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return
+	}
+	if len(o.queue) >= CLUSTER_OUTBOX_LIMIT {
+		o.dropped++
+		if o.dropped == 1 || o.dropped%1000 == 0 {
+			log.Printf("Warning: Backplane is not keeping up, dropped %d cluster event(s)", o.dropped)
+		}
+		return
+	}
+	o.queue = append(o.queue, outboxEntry{channel: channel, payload: payload})
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Publish queued events until the outbox closes
+func (o *ClusterOutbox) Run(backplane Backplane) {
+	defer close(o.done)
+	for range o.wake {
+		for {
+			o.mu.Lock()
+			if len(o.queue) == 0 {
+				o.mu.Unlock()
+				break
+			}
+			entry := o.queue[0]
+			o.queue[0] = outboxEntry{}
+			o.queue = o.queue[1:]
+			o.mu.Unlock()
+
+			logBackplaneError("publish", backplane.Publish(entry.channel, entry.payload))
+		}
+	}
+}
+
+// Stop accepting events and wait a while for the queue to drain
+func (o *ClusterOutbox) Close(wait time.Duration) {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return
+	}
+	o.closed = true
+	close(o.wake)
+	o.mu.Unlock()
+
+	select {
+	case <-o.done:
+	case <-time.After(wait):
+		o.mu.Lock()
+		log.Printf("Warning: Gave up publishing %d queued cluster event(s)", len(o.queue))
+		o.queue = nil
+		o.mu.Unlock()
+	}
+}
+
+// Join the cluster and ask peers which codes they hold
+func (s *Server) startCluster() error {
+	err := s.backplane.Subscribe(CLUSTER_CODES_CHANNEL, s.handleCodesEvent)
+	if err != nil {
+		return err
+	}
+	go s.outbox.Run(s.backplane)
+
+	s.publishEvent(CLUSTER_CODES_CHANNEL, clusterEvent{Kind: clusterSyncRequest})
+	log.Printf("📡 Joined cluster as node %s", s.nodeID)
+	return nil
+}
+
+// Queue an event stamped with this node's ID, safe to call holding any lock
+func (s *Server) publishEvent(channel string, event clusterEvent) {
+	event.Node = s.nodeID
+	data, err := json.Marshal(&event)
+	if err != nil {
+		log.Printf("Error encoding cluster event: %v", err)
+		return
+	}
+	s.outbox.Push(channel, data)
+}
+
+// Publish an event on a session's channel
+func (s *Server) publishSessionEvent(code string, event clusterEvent) {
+	event.Code = code
+	s.publishEvent(sessionChannel(code), event)
+}
+
+// Relay a message to peers of the given role held by other nodes
+func (s *Server) relayRemote(code string, toRole Role, target string, message ResponseMessage) {
+	s.publishSessionEvent(code, clusterEvent{
+		Kind:    clusterRelay,
+		ToRole:  toRole,
+		Target:  target,
+		Message: &message,
+	})
+}
+
+// Start routing a code through this node
+func (s *Server) holdCode(code string) {
+	s.clusterMu.Lock()
+	defer s.clusterMu.Unlock()
+
+	if s.heldCodes[code] {
+		return
+	}
+	s.heldCodes[code] = true
+
+	logBackplaneError("subscribe", s.backplane.Subscribe(sessionChannel(code), func(payload []byte) {
+		s.handleSessionEvent(code, payload)
+	}))
+	s.publishEvent(CLUSTER_CODES_CHANNEL, clusterEvent{Kind: clusterCodeHeld, Code: code, Interview: s.schedule.Local(code)})
+}
+
+// Stop routing a code through this node
+func (s *Server) releaseCode(code string) {
+	s.clusterMu.Lock()
+	defer s.clusterMu.Unlock()
+
+	if !s.heldCodes[code] {
+		return
+	}
+	delete(s.heldCodes, code)
+
+	logBackplaneError("unsubscribe", s.backplane.Unsubscribe(sessionChannel(code)))
+	s.publishEvent(CLUSTER_CODES_CHANNEL, clusterEvent{Kind: clusterCodeDropped, Code: code})
+}
+
+// Check if another node holds the code
+func (s *Server) isRemoteCode(code string) bool {
+	s.clusterMu.Lock()
+	defer s.clusterMu.Unlock()
+	return len(s.remoteCodes[code]) > 0
+}
+
+// Handle code ownership announcements
+func (s *Server) handleCodesEvent(payload []byte) {
+	var event clusterEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		log.Printf("Warning: Ignoring malformed cluster event: %v", err)
+		return
+	}
+	if event.Node == s.nodeID {
+		return
+	}
+
+	s.clusterMu.Lock()
+	defer s.clusterMu.Unlock()
+
+	switch event.Kind {
+	case clusterSyncRequest:
+		for code := range s.heldCodes {
+			s.publishEvent(CLUSTER_CODES_CHANNEL, clusterEvent{Kind: clusterCodeHeld, Code: code, Interview: s.schedule.Local(code)})
+		}
+	case clusterCodeHeld:
+		if s.remoteCodes[event.Code] == nil {
+			s.remoteCodes[event.Code] = make(map[string]bool)
+		}
+		s.remoteCodes[event.Code][event.Node] = true
+		if event.Interview != nil {
+			s.schedule.SetRemote(event.Interview)
+		}
+	case clusterCodeDropped:
+		delete(s.remoteCodes[event.Code], event.Node)
+		if len(s.remoteCodes[event.Code]) == 0 {
+			delete(s.remoteCodes, event.Code)
+			s.schedule.ForgetRemote(event.Code)
+		}
+	}
+}
+
+// Handle an event published on a session channel by another node
+func (s *Server) handleSessionEvent(code string, payload []byte) {
+	var event clusterEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		log.Printf("Warning: Ignoring malformed cluster event: %v", err)
+		return
+	}
+	if event.Node == s.nodeID {
+		return
+	}
+
+	switch event.Kind {
+	case clusterRelay:
+		s.deliverRelay(code, &event)
+	case clusterClientJoined:
+		s.handleRemoteClientJoined(code, &event)
+	case clusterClientLeft:
+		s.handleRemoteClientLeft(code)
+	case clusterViewerJoined:
+		s.handleRemoteViewerJoined(code, &event)
+	case clusterViewerLeft:
+		s.handleRemoteViewerLeft(code, &event)
+	case clusterSessionEnded:
+		s.endLocalSession(code, event.Reason)
+	}
+}
+
+// Deliver a relayed message to local connections
+func (s *Server) deliverRelay(code string, event *clusterEvent) {
+	if event.Message == nil {
+		return
+	}
+
+	// Viewers elsewhere decide on a client in our waiting room
+	if event.ToRole == ClientRole && s.deliverWaitingRoomCommand(code, event.Message) {
+		return
+	}
+
+	s.mu.RLock()
+	session := s.sessions[code]
+	var waitingViewers []*Connection
+	if pending := s.pendingCodes[code]; session == nil && pending != nil && event.ToRole == ViewerRole {
+		for _, viewer := range pending.Viewers {
+			if viewer.IsOpen() && (event.Target == "" || event.Target == viewer.ID) {
+				waitingViewers = append(waitingViewers, viewer)
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	// Viewers still waiting on a pending code hear about the waiting room
+	for _, viewer := range waitingViewers {
+		viewer.Send(*event.Message)
+	}
+	if session == nil {
+		return
+	}
+
+	// Each node diffs process lists for its own viewers
+	if event.ToRole == ViewerRole && event.Target == "" && event.Message.Type == ProcessInfo {
+		var payload ProcessInfoPayload
+		if data, err := json.Marshal(event.Message.Payload); err == nil && json.Unmarshal(data, &payload) == nil {
+			s.sendProcessInfo(code, session, &payload, false)
+		}
+		return
+	}
+
+	session.mu.Lock()
+
+	// Viewers elsewhere cannot signal our client before it consents either
+	if event.ToRole == ClientRole && event.Message.Type == Signal && session.Client != nil && s.consentNeeded(session) {
+		session.mu.Unlock()
+		log.Printf("✍️ Refused signal from remote viewer %s for %s before the client consented", event.Message.From, code)
+		if event.Message.From != "" {
+			s.relayRemote(code, ViewerRole, event.Message.From, createErrorMessage(ErrConsentRequired, "The candidate has not consented yet"))
+		}
+		return
+	}
+
+	// Remote viewers in sfu mode negotiate with our SFU rather than the client
+	if event.ToRole == ClientRole && session.sfu != nil && event.Message.From != "" {
+		switch event.Message.Type {
+		case Connect:
+			s.subscribeRemoteViewer(code, session, event.Message.From)
+			session.mu.Unlock()
+			return
+		case Signal:
+			if payload, err := json.Marshal(event.Message.Payload); err == nil {
+				session.sfu.ViewerSignal(event.Message.From, payload)
+				s.metrics.MessageRelayed(Signal, 1)
+			}
+			session.mu.Unlock()
+			return
+		}
+	}
+
+	// Viewers elsewhere wait on our client's consent like local ones
+	if event.ToRole == ClientRole && event.Message.Type == Connect && session.Client != nil && s.consentNeeded(session) {
+		session.heldConnects = append(session.heldConnects, *event.Message)
+		session.mu.Unlock()
+		log.Printf("✍️ Holding connect for %s until the client consents", code)
+		return
+	}
+
+	var recorder *Recorder
+	if event.ToRole != ClientRole && event.Message.Type == Signal && session.recorder != nil &&
+		(event.Target == "" || event.Target == session.recorder.ID) {
+		recorder = session.recorder
+	}
+	var targets []*Connection
+	if event.ToRole == ClientRole {
+		if session.Client != nil && session.Client.IsOpen() {
+			targets = append(targets, session.Client)
+		}
+	} else if event.Target != "" {
+		if viewer := session.Viewers[event.Target]; viewer != nil && viewer.IsOpen() {
+			targets = append(targets, viewer)
+		}
+	} else {
+		targets = session.openViewers()
+
+		// Keep the snapshot current for viewers joining this node later
+		if session.Info != nil {
+			switch event.Message.Type {
+			case MonitorInfo:
+				session.Info.MonitorInfo = event.Message.Payload
+				session.dirty = true
+			}
+		}
+	}
+	session.mu.Unlock()
+
+	for _, target := range targets {
+		target.Send(*event.Message)
+	}
+	relayed := len(targets)
+	if recorder != nil {
+		if payload, err := json.Marshal(event.Message.Payload); err == nil {
+			recorder.Signal(payload)
+			relayed++
+		}
+	}
+	s.metrics.MessageRelayed(event.Message.Type, relayed)
+}
+
+// A client registered on another node for a code this node holds
+func (s *Server) handleRemoteClientJoined(code string, event *clusterEvent) {
+	s.mu.Lock()
+	session := s.sessions[code]
+	if session == nil {
+		// A scheduled interview nobody is watching yet still owns the code
+		pendingData := s.openScheduled(code)
+		if pendingData == nil {
+			s.mu.Unlock()
+			return
+		}
+
+		// Promote our pending code, the client lives elsewhere
+		session = &Session{
+			Viewers: pendingData.Viewers,
+			Info: &SessionInfo{
+				ID:         pendingData.ID,
+				CreatedAt:  time.Now(),
+				ClientInfo: event.ClientInfo,
+				Subject:    pendingData.Subject,
+				Mode:       pendingData.Mode,
+			},
+		}
+		s.sessions[code] = session
+		delete(s.pendingCodes, code)
+		s.forgetPending(code)
+		s.persistSession(code, session)
+	}
+	s.mu.Unlock()
+
+	session.mu.Lock()
+	alreadyKnown := session.remoteClient
+	session.remoteClient = true
+	if session.Info != nil && session.Info.ClientInfo == nil {
+		session.Info.ClientInfo = event.ClientInfo
+		session.dirty = true
+	}
+	var viewers []*Connection
+	if event.Target != "" {
+		if viewer := session.Viewers[event.Target]; viewer != nil && viewer.IsOpen() {
+			viewers = append(viewers, viewer)
+		}
+	} else {
+		viewers = session.openViewers()
+	}
+	var mode SessionMode
+	if session.Info != nil {
+		mode = session.Info.Mode
+	}
+	session.mu.Unlock()
+
+	// Introductions are only for viewers that have not heard of the client yet
+	if event.Target != "" && alreadyKnown {
+		return
+	}
+
+	log.Printf("📡 Client for code %s joined on node %s", code, event.Node)
+
+	response := createSimpleResponseMessage(ClientConnected, ClientInfoPayload{
+		Timestamp:  getCurrentTimestamp(),
+		Code:       code,
+		ClientInfo: event.ClientInfo,
+		ICEServers: s.iceServers(code),
+		Mode:       mode,
+	})
+	if event.Reconnect {
+		response = createSimpleResponseMessage(ClientReconnected, ClientReconnectedPayload{
+			Timestamp: getCurrentTimestamp(),
+		})
+	}
+
+	for _, viewer := range viewers {
+		viewer.Send(response)
+
+		// Introduce our viewers to the client's node, along with the mode it should run in
+		identity := viewer.Identity()
+		s.publishSessionEvent(code, clusterEvent{Kind: clusterViewerJoined, Viewer: &identity, Mode: mode})
+	}
+}
+
+// The client held by another node disconnected
+func (s *Server) handleRemoteClientLeft(code string) {
+	s.mu.RLock()
+	session := s.sessions[code]
+	s.mu.RUnlock()
+	if session == nil {
+		return
+	}
+
+	session.mu.Lock()
+	session.remoteClient = false
+	viewers := session.openViewers()
+	session.mu.Unlock()
+
+	response := createSimpleResponseMessage(ClientDisconnected, ClientDisconnectedPayload{
+		Timestamp: getCurrentTimestamp(),
+		Code:      code,
+	})
+	for _, viewer := range viewers {
+		viewer.Send(response)
+	}
+}
+
+// A viewer joined on another node
+func (s *Server) handleRemoteViewerJoined(code string, event *clusterEvent) {
+	if event.Viewer == nil {
+		return
+	}
+
+	s.mu.RLock()
+	session := s.sessions[code]
+	s.mu.RUnlock()
+	if session == nil {
+		return
+	}
+
+	session.mu.Lock()
+	if session.remoteViewers == nil {
+		session.remoteViewers = make(map[string]ViewerIdentity)
+	}
+	_, known := session.remoteViewers[event.Viewer.ID]
+	session.remoteViewers[event.Viewer.ID] = *event.Viewer
+	client := session.Client
+
+	// The client's node runs the SFU, the viewers' node knows the mode
+	if session.sfu != nil && !known {
+		s.subscribeRemoteViewer(code, session, event.Viewer.ID)
+	} else if session.sfu == nil && event.Mode == SFUMode && client != nil && client.IsOpen() {
+		if session.Info != nil {
+			session.Info.Mode = SFUMode
+			session.dirty = true
+		}
+		s.startSFU(code, session)
+	}
+	identities := session.viewerIdentities()
+	var clientInfo interface{}
+	if session.Info != nil {
+		clientInfo = session.Info.ClientInfo
+	}
+	session.mu.Unlock()
+
+	if known || client == nil || !client.IsOpen() {
+		return
+	}
+
+	client.Send(createSimpleResponseMessage(ViewerConnected, ViewerConnectedPayload{
+		Timestamp: getCurrentTimestamp(),
+		Viewer:    *event.Viewer,
+		Viewers:   identities,
+	}))
+
+	// Let the new viewer know our client is here
+	s.publishSessionEvent(code, clusterEvent{
+		Kind:       clusterClientJoined,
+		Target:     event.Viewer.ID,
+		ClientInfo: clientInfo,
+	})
+}
+
+// A viewer left on another node
+func (s *Server) handleRemoteViewerLeft(code string, event *clusterEvent) {
+	if event.Viewer == nil {
+		return
+	}
+
+	s.mu.RLock()
+	session := s.sessions[code]
+	s.mu.RUnlock()
+	if session == nil {
+		return
+	}
+
+	session.mu.Lock()
+	delete(session.remoteViewers, event.Viewer.ID)
+	if session.sfu != nil {
+		session.sfu.Unsubscribe(event.Viewer.ID)
+	}
+	client := session.Client
+	identities := session.viewerIdentities()
+	session.mu.Unlock()
+
+	if client != nil && client.IsOpen() {
+		client.Send(createSimpleResponseMessage(ViewerDisconnected, ViewerDisconnectedPayload{
+			Timestamp: getCurrentTimestamp(),
+			Code:      code,
+			Viewer:    *event.Viewer,
+			Viewers:   identities,
+		}))
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Join code alphabets
+const (
+	NUMERIC_CODE_ALPHABET      = "0123456789"
+	ALPHANUMERIC_CODE_ALPHABET = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // no 0/O or 1/I
+	DEFAULT_CODE_LENGTH        = 6
+	MIN_CODE_LENGTH            = 6
+	MAX_CODE_LENGTH            = 32
+)
+
+// Shape of generated join codes
+type CodeFormat struct {
+	Length   int
+	Alphabet string
+}
+
+// Default six-digit numeric format understood by every client
+func DefaultCodeFormat() CodeFormat {
+	return CodeFormat{
+		Length:   DEFAULT_CODE_LENGTH,
+		Alphabet: NUMERIC_CODE_ALPHABET,
+	}
+}
+
+// Code format from the codeLength and codeAlphanumeric settings
+func NewCodeFormat(c *Config) (CodeFormat, error) {
+	format := DefaultCodeFormat()
+	if c.CodeLength < MIN_CODE_LENGTH || c.CodeLength > MAX_CODE_LENGTH {
+		return format, fmt.Errorf("codeLength must be between %d and %d, got %d", MIN_CODE_LENGTH, MAX_CODE_LENGTH, c.CodeLength)
+	}
+	format.Length = c.CodeLength
+	if c.CodeAlphanumeric {
+		format.Alphabet = ALPHANUMERIC_CODE_ALPHABET
+	}
+	return format, nil
+}
+
+// Check if codes are alphanumeric
+func (f CodeFormat) IsAlphanumeric() bool {
+	return f.Alphabet == ALPHANUMERIC_CODE_ALPHABET
+}
+
+// Generate a random code from crypto/rand
+func (f CodeFormat) Generate() (string, error) {
+	max := big.NewInt(int64(len(f.Alphabet)))
+	code := make([]byte, f.Length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = f.Alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// Normalize user-entered codes so alphanumeric codes are case-insensitive
+func (f CodeFormat) Normalize(code string) string {
+	code = strings.TrimSpace(code)
+	if f.IsAlphanumeric() {
+		code = strings.ToUpper(code)
+	}
+	return code
+}
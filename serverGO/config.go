@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Printed in place of secrets by --print-config
+const REDACTED_SECRET = "<redacted>"
+
+// Server settings. Each field is read from the YAML config file, then the env
+// var in its env tag, then the command line flag in its flag tag, with later
+// sources taking precedence. Fields tagged secret are redacted when printed.
+type Config struct {
+	Port                 string        `yaml:"port" env:"PORT" flag:"port" help:"HTTP listen port"`
+	MaxBuffer            int           `yaml:"maxBuffer" env:"MAX_BUFFER" flag:"max-buffer" help:"largest message or session store journal line, in bytes"`
+	DetectionInterval    time.Duration `yaml:"detectionInterval" env:"DETECTION_INTERVAL" flag:"detection-interval" help:"minimum time between process scans of a session"`
+	CleanupInterval      time.Duration `yaml:"cleanupInterval" env:"CLEANUP_INTERVAL" flag:"cleanup-interval" help:"time between expired code sweeps"`
+	PendingCodeTTL       time.Duration `yaml:"pendingCodeTtl" env:"PENDING_CODE_TTL" flag:"pending-code-ttl" help:"how long an unclaimed code stays valid"`
+	ClientConnectedDelay time.Duration `yaml:"clientConnectedDelay" env:"CLIENT_CONNECTED_DELAY" flag:"client-connected-delay" help:"wait before telling viewers a client joined"`
+	ConnectDelay         time.Duration `yaml:"connectDelay" env:"CONNECT_DELAY" flag:"connect-delay" help:"wait before asking a client to start WebRTC"`
+	ErrorCloseDelay      time.Duration `yaml:"errorCloseDelay" env:"ERROR_CLOSE_DELAY" flag:"error-close-delay" help:"wait after a fatal error before closing the socket"`
+	HeartbeatInterval    time.Duration `yaml:"heartbeatInterval" env:"HEARTBEAT_INTERVAL" flag:"heartbeat-interval" help:"time between server pings"`
+	HeartbeatGrace       time.Duration `yaml:"heartbeatGrace" env:"HEARTBEAT_GRACE" flag:"heartbeat-grace" help:"silence tolerated before a peer is dead"`
+	ShutdownDrain        time.Duration `yaml:"shutdownDrain" env:"SHUTDOWN_DRAIN" flag:"shutdown-drain" help:"time peers get to wrap up on shutdown"`
+	RateLimit            float64       `yaml:"rateLimit" env:"RATE_LIMIT" flag:"rate-limit" help:"messages per second a connection may sustain"`
+	RateBurst            int           `yaml:"rateBurst" env:"RATE_BURST" flag:"rate-burst" help:"messages a connection may send in a burst"`
+	RateLimitStrikes     int           `yaml:"rateLimitStrikes" env:"RATE_LIMIT_STRIKES" flag:"rate-limit-strikes" help:"dropped messages tolerated before disconnecting"`
+	MessageRateLimits    string        `yaml:"messageRateLimits" env:"MESSAGE_RATE_LIMITS" flag:"message-rate-limits" help:"per-type limits as type=rate/burst, comma separated"`
+	AllowedOrigins       string        `yaml:"allowedOrigins" env:"ALLOWED_ORIGINS" flag:"allowed-origins" help:"origins allowed to open a WebSocket, like https://app.example.com or *.example.com, comma separated, empty allows all"`
+	ElectronOrigins      string        `yaml:"electronOrigins" env:"ELECTRON_ORIGINS" flag:"electron-origins" help:"policy for upgrades with no Origin or a file:// one: allow, anonymous or deny"`
+	SessionMode          string        `yaml:"sessionMode" env:"SESSION_MODE" flag:"session-mode" help:"default media path for new sessions: mesh or sfu"`
+	WaitingRoom          bool          `yaml:"waitingRoom" env:"WAITING_ROOM" flag:"waiting-room" help:"hold clients until a viewer admits them"`
+	CodeLength           int           `yaml:"codeLength" env:"CODE_LENGTH" flag:"code-length" help:"characters in a join code"`
+	CodeAlphanumeric     bool          `yaml:"codeAlphanumeric" env:"CODE_ALPHANUMERIC" flag:"code-alphanumeric" help:"use letters as well as digits in join codes"`
+	AuthUsersFile        string        `yaml:"authUsersFile" env:"AUTH_USERS_FILE" flag:"auth-users-file" help:"JSON file of interviewer accounts"`
+	AuthTokenTTL         time.Duration `yaml:"authTokenTtl" env:"AUTH_TOKEN_TTL" flag:"auth-token-ttl" help:"how long an interviewer token stays valid"`
+	AuthSecret           string        `yaml:"authSecret" env:"AUTH_SECRET" flag:"auth-secret" secret:"true" help:"token signing key, random on every start when empty"`
+	TrustProxyHeaders    bool          `yaml:"trustProxyHeaders" env:"TRUST_PROXY_HEADERS" flag:"trust-proxy-headers" help:"take client IPs from the entry our proxy appends to X-Forwarded-For"`
+	SessionStore         string        `yaml:"sessionStore" env:"SESSION_STORE" flag:"session-store" help:"where sessions survive restarts: memory or file"`
+	SessionStorePath     string        `yaml:"sessionStorePath" env:"SESSION_STORE_PATH" flag:"session-store-path" help:"journal file of the file session store"`
+	Backplane            string        `yaml:"backplane" env:"BACKPLANE" flag:"backplane" help:"pub/sub joining cluster nodes: inprocess or redis"`
+	RedisAddr            string        `yaml:"redisAddr" env:"REDIS_ADDR" flag:"redis-addr" help:"Redis host:port of the redis backplane"`
+	RedisPassword        string        `yaml:"redisPassword" env:"REDIS_PASSWORD" flag:"redis-password" secret:"true" help:"Redis AUTH password"`
+	NodeID               string        `yaml:"nodeId" env:"NODE_ID" flag:"node-id" help:"name of this node in the cluster, generated when empty"`
+	RecordingEnabled     bool          `yaml:"recordingEnabled" env:"RECORDING_ENABLED" flag:"recording-enabled" help:"allow panels to record sessions"`
+	RecordingDir         string        `yaml:"recordingDir" env:"RECORDING_DIR" flag:"recording-dir" help:"directory recordings are written to"`
+	AuditDir             string        `yaml:"auditDir" env:"AUDIT_DIR" flag:"audit-dir" help:"directory of the per-session audit logs"`
+	ProcessRulesFile     string        `yaml:"processRulesFile" env:"PROCESS_RULES_FILE" flag:"process-rules-file" help:"JSON process detection rules, built-in rules when empty"`
+	ConsentFile          string        `yaml:"consentFile" env:"CONSENT_FILE" flag:"consent-file" help:"text candidates must consent to before monitoring starts, not required when empty"`
+	ConsentVersion       string        `yaml:"consentVersion" env:"CONSENT_VERSION" flag:"consent-version" help:"version recorded with each consent, a hash of the text when empty"`
+	TLSCertFile          string        `yaml:"tlsCertFile" env:"TLS_CERT_FILE" flag:"tls-cert-file" help:"PEM certificate, enables TLS"`
+	TLSKeyFile           string        `yaml:"tlsKeyFile" env:"TLS_KEY_FILE" flag:"tls-key-file" help:"PEM private key of the certificate"`
+	TLSClientCAFile      string        `yaml:"tlsClientCaFile" env:"TLS_CLIENT_CA_FILE" flag:"tls-client-ca-file" help:"PEM CAs that issue interviewer client certificates"`
+	TLSClientAuth        string        `yaml:"tlsClientAuth" env:"TLS_CLIENT_AUTH" flag:"tls-client-auth" help:"client certificate policy: none, optional or require, optional when a CA is set"`
+	TLSRedirectPort      string        `yaml:"tlsRedirectPort" env:"TLS_REDIRECT_PORT" flag:"tls-redirect-port" help:"plain HTTP port redirecting to TLS, off when empty"`
+	TLSReloadInterval    time.Duration `yaml:"tlsReloadInterval" env:"TLS_RELOAD_INTERVAL" flag:"tls-reload-interval" help:"how often certificate files are checked for changes"`
+	TURNPublicIP         string        `yaml:"turnPublicIp" env:"TURN_PUBLIC_IP" flag:"turn-public-ip" help:"public IP relays are reached on, enables the embedded TURN server"`
+	TURNHost             string        `yaml:"turnHost" env:"TURN_HOST" flag:"turn-host" help:"host advertised in TURN URLs, the public IP when empty"`
+	TURNPort             int           `yaml:"turnPort" env:"TURN_PORT" flag:"turn-port" help:"TURN and STUN listen port, UDP and TCP"`
+	TURNRealm            string        `yaml:"turnRealm" env:"TURN_REALM" flag:"turn-realm" help:"TURN authentication realm"`
+	TURNSecret           string        `yaml:"turnSecret" env:"TURN_SECRET" flag:"turn-secret" secret:"true" help:"TURN credential signing key, random on every start when empty"`
+	TURNCredentialTTL    time.Duration `yaml:"turnCredentialTtl" env:"TURN_CREDENTIAL_TTL" flag:"turn-credential-ttl" help:"how long issued TURN credentials stay valid"`
+	TURNRelayPorts       string        `yaml:"turnRelayPorts" env:"TURN_RELAY_PORTS" flag:"turn-relay-ports" help:"relay port range as min-max, any free port when empty"`
+	TURNAllowedPeers     string        `yaml:"turnAllowedPeers" env:"TURN_ALLOWED_PEERS" flag:"turn-allowed-peers" help:"loopback, private or link-local networks relays may reach anyway, as CIDRs, comma separated"`
+}
+
+// Built-in configuration
+func DefaultConfig() *Config {
+	return &Config{
+		Port:                 DEFAULT_PORT,
+		MaxBuffer:            DEFAULT_MAX_BUFFER,
+		DetectionInterval:    DEFAULT_DETECTION_INTERVAL,
+		CleanupInterval:      DEFAULT_CLEANUP_INTERVAL,
+		PendingCodeTTL:       DEFAULT_PENDING_CODE_TTL,
+		ClientConnectedDelay: DEFAULT_CLIENT_CONNECTED_DELAY,
+		ConnectDelay:         DEFAULT_CONNECT_DELAY,
+		ErrorCloseDelay:      DEFAULT_ERROR_CLOSE_DELAY,
+		HeartbeatInterval:    DEFAULT_HEARTBEAT_INTERVAL,
+		HeartbeatGrace:       DEFAULT_HEARTBEAT_GRACE,
+		ShutdownDrain:        DEFAULT_SHUTDOWN_DRAIN,
+		RateLimit:            DEFAULT_RATE_LIMIT,
+		RateBurst:            DEFAULT_RATE_BURST,
+		RateLimitStrikes:     DEFAULT_RATE_LIMIT_STRIKES,
+		ElectronOrigins:      ElectronOriginsAllow,
+		SessionMode:          string(MeshMode),
+		CodeLength:           DEFAULT_CODE_LENGTH,
+		AuthUsersFile:        DEFAULT_USERS_FILE,
+		AuthTokenTTL:         DEFAULT_TOKEN_TTL,
+		SessionStore:         StoreMemory,
+		SessionStorePath:     DEFAULT_STORE_PATH,
+		Backplane:            BackplaneInProcess,
+		RedisAddr:            DEFAULT_REDIS_ADDR,
+		RecordingDir:         DEFAULT_RECORDING_DIR,
+		AuditDir:             DEFAULT_AUDIT_DIR,
+		TLSReloadInterval:    DEFAULT_TLS_RELOAD_INTERVAL,
+		TURNPort:             DEFAULT_TURN_PORT,
+		TURNRealm:            DEFAULT_TURN_REALM,
+		TURNCredentialTTL:    DEFAULT_TURN_CREDENTIAL_TTL,
+	}
+}
+
+// Load configuration from args, CONFIG_FILE or --config, and the environment.
+// printOnly is set when --print-config was given.
+func LoadConfig(args []string) (config *Config, printOnly bool, err error) {
+	config = DefaultConfig()
+
+	flags := flag.NewFlagSet("interview-server", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML config file (env CONFIG_FILE)")
+	printConfig := flags.Bool("print-config", false, "print the effective configuration and exit")
+
+	// Flags are registered as strings so unset ones can be told apart from defaults
+	t := reflect.TypeOf(*config)
+	flagValues := make(map[string]*string)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		help := fmt.Sprintf("%s (env %s, default %v)", field.Tag.Get("help"), field.Tag.Get("env"), reflect.ValueOf(*config).Field(i))
+		flagValues[field.Name] = flags.String(field.Tag.Get("flag"), "", help)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, false, err
+	}
+	if flags.NArg() > 0 {
+		return nil, false, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	if *configFile != "" {
+		if err := config.loadFile(*configFile); err != nil {
+			return nil, false, err
+		}
+	}
+
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if raw := os.Getenv(field.Tag.Get("env")); raw != "" {
+			if err := setConfigField(v.Field(i), raw); err != nil {
+				return nil, false, fmt.Errorf("invalid %s %q: %w", field.Tag.Get("env"), raw, err)
+			}
+		}
+		if name := field.Tag.Get("flag"); set[name] {
+			if err := setConfigField(v.Field(i), *flagValues[field.Name]); err != nil {
+				return nil, false, fmt.Errorf("invalid --%s %q: %w", name, *flagValues[field.Name], err)
+			}
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, false, err
+	}
+	return config, *printConfig, nil
+}
+
+// Overlay settings from a YAML file, rejecting unknown keys
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Parse a string into a config field
+func setConfigField(field reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		switch strings.ToLower(raw) {
+		case "yes":
+			raw = "true"
+		case "no":
+			raw = "false"
+		}
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported config type %s", field.Type())
+	}
+	return nil
+}
+
+// Check settings for values the server cannot run with
+func (c *Config) Validate() error {
+	port, err := strconv.Atoi(c.Port)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %q", c.Port)
+	}
+	if c.MaxBuffer < 64*1024 {
+		return fmt.Errorf("maxBuffer must be at least 65536 bytes, got %d", c.MaxBuffer)
+	}
+
+	positive := map[string]time.Duration{
+		"detectionInterval": c.DetectionInterval,
+		"cleanupInterval":   c.CleanupInterval,
+		"pendingCodeTtl":    c.PendingCodeTTL,
+		"heartbeatInterval": c.HeartbeatInterval,
+		"heartbeatGrace":    c.HeartbeatGrace,
+		"authTokenTtl":      c.AuthTokenTTL,
+		"tlsReloadInterval": c.TLSReloadInterval,
+	}
+	for name, d := range positive {
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", name, d)
+		}
+	}
+
+	nonNegative := map[string]time.Duration{
+		"clientConnectedDelay": c.ClientConnectedDelay,
+		"connectDelay":         c.ConnectDelay,
+		"errorCloseDelay":      c.ErrorCloseDelay,
+		"shutdownDrain":        c.ShutdownDrain,
+	}
+	for name, d := range nonNegative {
+		if d < 0 {
+			return fmt.Errorf("%s must not be negative, got %s", name, d)
+		}
+	}
+
+	if c.HeartbeatGrace <= c.HeartbeatInterval {
+		return fmt.Errorf("heartbeatGrace (%s) must be longer than heartbeatInterval (%s)", c.HeartbeatGrace, c.HeartbeatInterval)
+	}
+	if c.CleanupInterval > c.PendingCodeTTL {
+		return fmt.Errorf("cleanupInterval (%s) must not exceed pendingCodeTtl (%s)", c.CleanupInterval, c.PendingCodeTTL)
+	}
+
+	if c.RateLimit <= 0 || c.RateBurst < 1 || c.RateLimitStrikes < 1 {
+		return fmt.Errorf("rateLimit, rateBurst and rateLimitStrikes must be positive")
+	}
+	if _, err := parseMessageRates(c.MessageRateLimits); err != nil {
+		return fmt.Errorf("messageRateLimits: %w", err)
+	}
+	if _, err := NewOriginPolicy(c.AllowedOrigins, c.ElectronOrigins); err != nil {
+		return fmt.Errorf("allowedOrigins: %w", err)
+	}
+	if !SessionMode(c.SessionMode).IsValid() {
+		return fmt.Errorf("invalid sessionMode %q (want mesh or sfu)", c.SessionMode)
+	}
+
+	if _, err := NewCodeFormat(c); err != nil {
+		return err
+	}
+	switch c.SessionStore {
+	case StoreMemory, StoreFile:
+	default:
+		return fmt.Errorf("invalid sessionStore %q (want memory or file)", c.SessionStore)
+	}
+	if c.SessionStore == StoreFile && c.SessionStorePath == "" {
+		return fmt.Errorf("sessionStorePath is required by the file session store")
+	}
+	switch c.Backplane {
+	case BackplaneInProcess, BackplaneRedis:
+	default:
+		return fmt.Errorf("invalid backplane %q (want inprocess or redis)", c.Backplane)
+	}
+	if c.Backplane == BackplaneRedis && c.RedisAddr == "" {
+		return fmt.Errorf("redisAddr is required by the redis backplane")
+	}
+	if c.AuthUsersFile == "" || c.AuditDir == "" || c.RecordingDir == "" {
+		return fmt.Errorf("authUsersFile, auditDir and recordingDir must not be empty")
+	}
+	if _, err := NewTLSConfig(c); err != nil {
+		return err
+	}
+	if _, err := NewTURNConfig(c); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Effective configuration as YAML, usable as a config file once secrets
+// are filled back in
+func (c *Config) YAML() ([]byte, error) {
+	printed := *c
+	v := reflect.ValueOf(&printed).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("secret") == "true" && v.Field(i).String() != "" {
+			v.Field(i).SetString(REDACTED_SECRET)
+		}
+	}
+	return yaml.Marshal(&printed)
+}
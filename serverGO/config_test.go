@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "server.yaml")
+	yaml := "port: \"4000\"\ncodeLength: 8\nauthTokenTtl: 1h\nsessionStore: file\nrecordingEnabled: true\n"
+	if err := os.WriteFile(file, []byte(yaml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", file)
+	t.Setenv("CODE_LENGTH", "10")
+	t.Setenv("RECORDING_ENABLED", "no")
+
+	config, _, err := LoadConfig([]string{"--code-length", "12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Port != "4000" || config.AuthTokenTTL != time.Hour || config.SessionStore != StoreFile {
+		t.Errorf("file settings not applied: %+v", config)
+	}
+	if config.RecordingEnabled {
+		t.Error("env did not override the file")
+	}
+	if config.CodeLength != 12 {
+		t.Errorf("codeLength %d, want the flag's 12", config.CodeLength)
+	}
+	if config.SessionStorePath != DEFAULT_STORE_PATH || config.Backplane != BackplaneInProcess {
+		t.Errorf("defaults lost: %+v", config)
+	}
+}
+
+func TestLoadConfigRejectsInvalid(t *testing.T) {
+	for _, args := range [][]string{
+		{"--code-length", "4"},
+		{"--session-store", "disk"},
+		{"--backplane", "nats"},
+		{"--tls-cert-file", "cert.pem"},
+		{"--tls-client-auth", "require", "--tls-cert-file", "cert.pem", "--tls-key-file", "key.pem"},
+		{"--auth-token-ttl", "0s"},
+		{"--trust-proxy-headers", "maybe"},
+	} {
+		if _, _, err := LoadConfig(args); err == nil {
+			t.Errorf("%v loaded without error", args)
+		}
+	}
+}
+
+func TestConfigYAMLRedactsSecrets(t *testing.T) {
+	config := DefaultConfig()
+	config.AuthSecret = "signing-key"
+	data, err := config.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	if strings.Contains(out, "signing-key") || !strings.Contains(out, "authSecret: "+REDACTED_SECRET) {
+		t.Fatalf("secret not redacted:\n%s", out)
+	}
+	if !strings.Contains(out, `redisPassword: ""`) {
+		t.Fatalf("empty secret was redacted:\n%s", out)
+	}
+	if config.AuthSecret != "signing-key" {
+		t.Fatal("printing changed the config")
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Longest consent text the server will send
+const MAX_CONSENT_TEXT = 64 * 1024
+
+// Consent text candidates must acknowledge before monitoring starts
+type ConsentPolicy struct {
+	Version string
+	Text    string
+	Hash    string // hex SHA-256 of Text
+}
+
+// Load the consent text from a file, versioned by version or its hash.
+// Consent is not required when path is empty.
+func LoadConsentPolicy(path, version string) (*ConsentPolicy, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading consent text: %w", err)
+	}
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return nil, fmt.Errorf("consent text %s is empty", path)
+	}
+	if len(text) > MAX_CONSENT_TEXT {
+		return nil, fmt.Errorf("consent text %s is longer than %d bytes", path, MAX_CONSENT_TEXT)
+	}
+
+	sum := sha256.Sum256([]byte(text))
+	policy := &ConsentPolicy{
+		Version: version,
+		Text:    text,
+		Hash:    hex.EncodeToString(sum[:]),
+	}
+	if policy.Version == "" {
+		policy.Version = policy.Hash[:12]
+	}
+	return policy, nil
+}
+
+// Acknowledgement of the consent text, stored with the session
+type ConsentRecord struct {
+	Version    string      `json:"version"`
+	TextHash   string      `json:"textHash"`
+	SignedName string      `json:"signedName"`
+	AcceptedAt time.Time   `json:"acceptedAt"`
+	IP         string      `json:"ip"`
+	ClientInfo interface{} `json:"clientInfo,omitempty"`
+}
+
+// Check if the client still has to consent, caller must hold session.mu
+func (s *Server) consentNeeded(session *Session) bool {
+	return s.consent != nil && (session.Info == nil || session.Info.Consent == nil)
+}
+
+// Send the consent text to a client
+func (s *Server) requestConsent(conn *Connection) {
+	conn.Send(createSimpleResponseMessage(ConsentRequest, ConsentRequestPayload{
+		Timestamp: getCurrentTimestamp(),
+		Version:   s.consent.Version,
+		Text:      s.consent.Text,
+		TextHash:  s.consent.Hash,
+	}))
+}
+
+// Client reports replayed to viewers once the client consents, in this order
+var consentHeldReports = []MessageType{MonitorInfo, ProcessInfo, DisplayConfigChanged}
+
+// Tell the client to start WebRTC, publishing to the SFU in sfu mode
+func (s *Server) sendConnect(conn *Connection, session *Session, message string) {
+	if !conn.IsOpen() {
+		return
+	}
+
+	session.mu.RLock()
+	sfu := session.sfu
+	session.mu.RUnlock()
+
+	response := createSimpleResponseMessage(Connect, ConnectPayload{
+		Timestamp: getCurrentTimestamp(),
+		Message:   message,
+	})
+	if sfu != nil {
+		response = sfu.connectMessage(message)
+	}
+	log.Printf("🔄 Sending connect signal to client for code: %s", conn.SessionCode)
+	s.connectClient(conn.SessionCode, session, response)
+}
+
+// Pass a connect request to the session's client, holding it while a local
+// client has yet to consent. A client on another node is gated by that node.
+// Caller must not hold session.mu.
+func (s *Server) connectClient(code string, session *Session, response ResponseMessage) bool {
+	session.mu.Lock()
+	if session.Client != nil && s.consentNeeded(session) {
+		session.heldConnects = append(session.heldConnects, response)
+		session.mu.Unlock()
+		log.Printf("✍️ Holding connect for %s until the client consents", code)
+		return true
+	}
+	session.mu.Unlock()
+	return s.sendToClient(code, session, response)
+}
+
+// Hold a client report back from viewers until the client consents, keeping
+// only the latest of each kind. Caller must not hold session.mu.
+func (s *Server) holdForConsent(session *Session, kind MessageType, replay func()) bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if !s.consentNeeded(session) {
+		return false
+	}
+	if session.heldReports == nil {
+		session.heldReports = make(map[MessageType]func())
+	}
+	session.heldReports[kind] = replay
+	return true
+}
+
+// Drop whatever a previous client left waiting on consent, caller must hold session.mu
+func (sess *Session) clearHeld() {
+	sess.heldConnects = nil
+	sess.heldReports = nil
+}
+
+// Handle the client's answer to the consent request
+func (s *Server) handleConsentAck(conn *Connection, msg *Message, payload *ConsentAckPayload) {
+	code := msg.Code
+	if conn.Role != ClientRole || conn.SessionCode != code {
+		conn.Send(createErrorMessage(ErrForbidden, "Only the session's client may consent"))
+		return
+	}
+	if s.consent == nil {
+		conn.Send(createErrorMessage(ErrInvalidMessage, "Consent is not required on this server"))
+		return
+	}
+
+	s.mu.RLock()
+	session := s.sessions[code]
+	s.mu.RUnlock()
+	if session == nil {
+		conn.Send(createErrorMessage(ErrNotConnected, "Session not found"))
+		return
+	}
+
+	// Acknowledging an older text does not count, send the current one again
+	if payload.Version != s.consent.Version || payload.TextHash != s.consent.Hash {
+		conn.Send(createProtocolErrorMessage(invalidField("textHash", "Consent text has changed, review it again")))
+		s.requestConsent(conn)
+		return
+	}
+
+	if !payload.Accepted {
+		log.Printf("✍️ Client declined consent for session %s", code)
+		if s.endLocalSession(code, "The candidate declined consent") {
+			s.publishSessionEvent(code, clusterEvent{Kind: clusterSessionEnded, Reason: "The candidate declined consent"})
+		}
+		return
+	}
+
+	session.mu.Lock()
+	if session.Client != conn {
+		session.mu.Unlock()
+		return
+	}
+	record := &ConsentRecord{
+		Version:    s.consent.Version,
+		TextHash:   s.consent.Hash,
+		SignedName: payload.SignedName,
+		AcceptedAt: time.Now(),
+		IP:         conn.RemoteIP,
+	}
+	if session.Info == nil {
+		now := time.Now()
+		session.Info = &SessionInfo{ID: newSessionID(code, now), CreatedAt: now}
+	}
+	record.ClientInfo = session.Info.ClientInfo
+	session.Info.Consent = record
+	s.persistSession(code, session)
+	connects, reports := session.heldConnects, session.heldReports
+	session.clearHeld()
+	session.mu.Unlock()
+
+	log.Printf("✍️ Client consented to version %s for session %s", record.Version, code)
+	for _, response := range connects {
+		s.sendToClient(code, session, response)
+	}
+	for _, kind := range consentHeldReports {
+		if replay := reports[kind]; replay != nil {
+			replay()
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Server requiring consent, listening for WebSocket peers
+func newConsentServer(t *testing.T, signer *TokenSigner) (*Server, string) {
+	t.Helper()
+	s := newTestServer(t)
+	s.auth = &Authenticator{tokens: signer}
+	s.consent = &ConsentPolicy{Version: "v1", Text: "You will be watched.", Hash: "0123456789abcdef"}
+	s.config.ClientConnectedDelay = 0
+	s.config.ConnectDelay = 0
+	origins, err := NewOriginPolicy("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.origins = origins
+
+	listener := httptest.NewServer(http.HandlerFunc(s.handleConnection))
+	t.Cleanup(listener.Close)
+	return s, "ws" + strings.TrimPrefix(listener.URL, "http")
+}
+
+// WebSocket peer collecting what the server sends it
+type testPeer struct {
+	t        *testing.T
+	ws       *websocket.Conn
+	messages chan ResponseMessage
+}
+
+func dialPeer(t *testing.T, url string) *testPeer {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := &testPeer{t: t, ws: ws, messages: make(chan ResponseMessage, 64)}
+	go func() {
+		for {
+			var message ResponseMessage
+			if err := ws.ReadJSON(&message); err != nil {
+				close(peer.messages)
+				return
+			}
+			peer.messages <- message
+		}
+	}()
+	return peer
+}
+
+func (p *testPeer) send(msgType MessageType, code string, role Role, payload interface{}) {
+	p.t.Helper()
+	if err := p.ws.WriteJSON(map[string]interface{}{"type": msgType, "code": code, "role": role, "payload": payload}); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// Wait for the next message of a type, skipping others
+func (p *testPeer) expect(msgType MessageType) ResponseMessage {
+	p.t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case message, ok := <-p.messages:
+			if !ok {
+				p.t.Fatalf("connection closed waiting for %s", msgType)
+			}
+			if message.Type == msgType {
+				return message
+			}
+		case <-timeout:
+			p.t.Fatalf("no %s message", msgType)
+		}
+	}
+}
+
+// Fail if a message of a type arrives within wait
+func (p *testPeer) expectNone(msgType MessageType, wait time.Duration) {
+	p.t.Helper()
+	timeout := time.After(wait)
+	for {
+		select {
+		case message, ok := <-p.messages:
+			if ok && message.Type == msgType {
+				p.t.Fatalf("unexpected %s message: %+v", msgType, message.Payload)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// Error code of an error message
+func errorCode(message ResponseMessage) ErrorCode {
+	var payload ErrorPayload
+	data, _ := json.Marshal(message.Payload)
+	json.Unmarshal(data, &payload)
+	return payload.Code
+}
+
+// Open a code as a viewer and join it as the client, returning both
+func openSession(t *testing.T, url, token string) (string, *testPeer, *testPeer) {
+	t.Helper()
+	viewer := dialPeer(t, url+"/?token="+token)
+	viewer.send(RequestCode, "", ViewerRole, map[string]interface{}{})
+	var assigned CodeAssignmentPayload
+	data, _ := json.Marshal(viewer.expect(CodeAssigned).Payload)
+	json.Unmarshal(data, &assigned)
+	code := assigned.Code
+	viewer.send(Register, code, ViewerRole, map[string]interface{}{"name": "Lead"})
+
+	client := dialPeer(t, url+"/")
+	client.send(Register, code, ClientRole, map[string]interface{}{"clientInfo": map[string]string{"os": "win"}})
+	client.expect(ConsentRequest)
+	return code, viewer, client
+}
+
+var testOffer = map[string]string{"type": "offer", "sdp": "v=0"}
+
+func TestSignalsWaitForConsent(t *testing.T) {
+	signer := NewTokenSigner([]byte("secret"), time.Hour)
+	s, url := newConsentServer(t, signer)
+	token, _, _ := signer.Issue("alice", false)
+	code, viewer, client := openSession(t, url, token)
+
+	// An offer before consentAck is refused and never reaches the client
+	viewer.send(Signal, code, ViewerRole, testOffer)
+	if got := errorCode(viewer.expect(Error)); got != ErrConsentRequired {
+		t.Fatalf("viewer offer refused with %s", got)
+	}
+	client.send(Signal, code, ClientRole, map[string]string{"type": "answer", "sdp": "v=0"})
+	if got := errorCode(client.expect(Error)); got != ErrConsentRequired {
+		t.Fatalf("client answer refused with %s", got)
+	}
+	client.expectNone(Signal, 200*time.Millisecond)
+	viewer.expectNone(Signal, 0)
+
+	client.send(ConsentAck, code, ClientRole, map[string]interface{}{
+		"version": s.consent.Version, "textHash": s.consent.Hash, "signedName": "Candidate", "accepted": true,
+	})
+	client.expect(Connect)
+
+	viewer.send(Signal, code, ViewerRole, testOffer)
+	if offer := client.expect(Signal); offer.From == "" {
+		t.Fatal("relayed offer does not name the viewer")
+	}
+	client.send(Signal, code, ClientRole, map[string]string{"type": "answer", "sdp": "v=0"})
+	viewer.expect(Signal)
+}
+
+func TestRelayedSignalsWaitForConsent(t *testing.T) {
+	signer := NewTokenSigner([]byte("secret"), time.Hour)
+	hub := NewInProcessHub()
+	var nodes []*Server
+	var urls []string
+	for i := 0; i < 2; i++ {
+		s, url := newConsentServer(t, signer)
+		s.backplane = NewInProcessBackplane(hub)
+		if err := s.startCluster(); err != nil {
+			t.Fatal(err)
+		}
+		defer s.outbox.Close(time.Second)
+		nodes = append(nodes, s)
+		urls = append(urls, url)
+	}
+
+	// The viewer is on node 1, the client on node 0
+	token, _, _ := signer.Issue("alice", false)
+	viewer := dialPeer(t, urls[1]+"/?token="+token)
+	viewer.send(RequestCode, "", ViewerRole, map[string]interface{}{})
+	var assigned CodeAssignmentPayload
+	data, _ := json.Marshal(viewer.expect(CodeAssigned).Payload)
+	json.Unmarshal(data, &assigned)
+	code := assigned.Code
+	viewer.send(Register, code, ViewerRole, map[string]interface{}{"name": "Lead"})
+	eventually(t, "code announcement", func() bool { return nodes[0].isRemoteCode(code) })
+
+	client := dialPeer(t, urls[0]+"/")
+	client.send(Register, code, ClientRole, map[string]interface{}{"clientInfo": map[string]string{"os": "win"}})
+	client.expect(ConsentRequest)
+	viewer.expect(ClientConnected)
+
+	viewer.send(Signal, code, ViewerRole, testOffer)
+	if got := errorCode(viewer.expect(Error)); got != ErrConsentRequired {
+		t.Fatalf("relayed offer refused with %s", got)
+	}
+	client.expectNone(Signal, 200*time.Millisecond)
+
+	client.send(ConsentAck, code, ClientRole, map[string]interface{}{
+		"version": nodes[0].consent.Version, "textHash": nodes[0].consent.Hash, "signedName": "Candidate", "accepted": true,
+	})
+	client.expect(Connect)
+
+	viewer.send(Signal, code, ViewerRole, testOffer)
+	client.expect(Signal)
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule categories
+const (
+	CategoryCheatingTool  = "cheating-tool"
+	CategoryRemoteDesktop = "remote-desktop"
+	CategoryAIAssistant   = "ai-assistant"
+)
+
+// A pattern for processes viewers should be warned about
+type ProcessRule struct {
+	ID          string   `json:"id"`
+	Category    string   `json:"category"`
+	Description string   `json:"description,omitempty"`
+	Severity    string   `json:"severity,omitempty"`
+	Names       []string `json:"names,omitempty"`   // executable names, case-insensitive, extension ignored
+	Paths       []string `json:"paths,omitempty"`   // substrings of the reported path, case-insensitive
+	Pattern     string   `json:"pattern,omitempty"` // regex against name and window title
+
+	names   map[string]bool
+	pattern *regexp.Regexp
+}
+
+// Built-in rules used when PROCESS_RULES_FILE is not set
+var defaultProcessRules = []ProcessRule{
+	{ID: "cluely", Category: CategoryCheatingTool, Severity: "high", Names: []string{"cluely"}},
+	{ID: "interview-coder", Category: CategoryCheatingTool, Severity: "high", Names: []string{"interviewcoder", "interview-coder", "interview coder"}},
+	{ID: "final-round-ai", Category: CategoryCheatingTool, Severity: "high", Pattern: `(?i)final\s*round`},
+	{ID: "lockedin-ai", Category: CategoryCheatingTool, Severity: "high", Pattern: `(?i)locked\s*in\s*ai`},
+	{ID: "teamviewer", Category: CategoryRemoteDesktop, Severity: "high", Names: []string{"teamviewer", "teamviewer_service", "tv_w32", "tv_x64"}},
+	{ID: "anydesk", Category: CategoryRemoteDesktop, Severity: "high", Names: []string{"anydesk"}},
+	{ID: "rustdesk", Category: CategoryRemoteDesktop, Severity: "high", Names: []string{"rustdesk"}},
+	{ID: "parsec", Category: CategoryRemoteDesktop, Severity: "high", Names: []string{"parsecd", "parsec"}},
+	{ID: "chrome-remote-desktop", Category: CategoryRemoteDesktop, Severity: "high", Names: []string{"remoting_host", "remoting_desktop"}},
+	{ID: "windows-remote-desktop", Category: CategoryRemoteDesktop, Severity: "medium", Names: []string{"mstsc", "rdpclip"}},
+	{ID: "vnc", Category: CategoryRemoteDesktop, Severity: "high", Pattern: `(?i)^(tight|ultra|real|tiger)?vnc(server|viewer)?`},
+	{ID: "chatgpt", Category: CategoryAIAssistant, Severity: "medium", Names: []string{"chatgpt"}, Pattern: `(?i)\bchatgpt\b`},
+	{ID: "claude", Category: CategoryAIAssistant, Severity: "medium", Names: []string{"claude"}},
+	{ID: "copilot", Category: CategoryAIAssistant, Severity: "medium", Names: []string{"copilot", "microsoft.copilot"}},
+	{ID: "gemini", Category: CategoryAIAssistant, Severity: "medium", Pattern: `(?i)\bgemini\b`},
+	{ID: "perplexity", Category: CategoryAIAssistant, Severity: "medium", Names: []string{"perplexity"}},
+}
+
+// Compile a rule's names and pattern
+func (r *ProcessRule) compile() error {
+	if r.ID == "" {
+		return fmt.Errorf("rule without id")
+	}
+	if len(r.Names) == 0 && len(r.Paths) == 0 && r.Pattern == "" {
+		return fmt.Errorf("rule %s has no names, paths or pattern", r.ID)
+	}
+
+	r.names = make(map[string]bool, len(r.Names))
+	for _, name := range r.Names {
+		r.names[normalizeProcessName(name)] = true
+	}
+
+	if r.Pattern != "" {
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", r.ID, err)
+		}
+		r.pattern = pattern
+	}
+
+	return nil
+}
+
+// Check a process against the rule
+func (r *ProcessRule) matches(process ReportedProcess) bool {
+	name := normalizeProcessName(process.ProcessName)
+	if r.names[name] {
+		return true
+	}
+
+	location := strings.ToLower(process.Path)
+	if location == "" {
+		// ps reports the full executable path as the process name on macOS
+		location = strings.ToLower(process.ProcessName)
+	}
+	for _, fragment := range r.Paths {
+		if fragment != "" && strings.Contains(location, strings.ToLower(fragment)) {
+			return true
+		}
+	}
+
+	if r.pattern != nil {
+		return r.pattern.MatchString(name) || r.pattern.MatchString(process.WindowTitle)
+	}
+	return false
+}
+
+// Base name of a process in lower case without an .exe suffix
+func normalizeProcessName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	return strings.TrimSuffix(name, ".exe")
+}
+
+// Ordered, compiled set of process rules
+type ProcessRuleSet struct {
+	rules []ProcessRule
+}
+
+// Compile a rule set
+func NewProcessRuleSet(rules []ProcessRule) (*ProcessRuleSet, error) {
+	set := &ProcessRuleSet{rules: make([]ProcessRule, len(rules))}
+	seen := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return nil, err
+		}
+		if seen[rule.ID] {
+			return nil, fmt.Errorf("duplicate rule id %s", rule.ID)
+		}
+		seen[rule.ID] = true
+		set.rules[i] = rule
+	}
+	return set, nil
+}
+
+// Load rules from a JSON file, falling back to the built-in set when file is empty
+func LoadProcessRuleSet(file string) (*ProcessRuleSet, error) {
+	if file == "" {
+		return NewProcessRuleSet(defaultProcessRules)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading process rules: %w", err)
+	}
+
+	var rules []ProcessRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parsing process rules: %w", err)
+	}
+
+	return NewProcessRuleSet(rules)
+}
+
+// Number of rules in the set
+func (rs *ProcessRuleSet) Len() int {
+	return len(rs.rules)
+}
+
+// Find every rule hit in a process list, keyed so repeats of one app collapse
+func (rs *ProcessRuleSet) Match(processes []ReportedProcess) map[string]ProcessMatch {
+	matches := make(map[string]ProcessMatch)
+	for _, process := range processes {
+		for i := range rs.rules {
+			rule := &rs.rules[i]
+			if !rule.matches(process) {
+				continue
+			}
+			key := rule.ID + "|" + normalizeProcessName(process.ProcessName)
+			if _, exists := matches[key]; !exists {
+				matches[key] = ProcessMatch{
+					RuleID:      rule.ID,
+					Category:    rule.Category,
+					Severity:    rule.Severity,
+					Description: rule.Description,
+					ProcessName: process.ProcessName,
+					WindowTitle: process.WindowTitle,
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// A process that matched a rule
+type ProcessMatch struct {
+	RuleID      string `json:"ruleId"`
+	Category    string `json:"category"`
+	Severity    string `json:"severity,omitempty"`
+	Description string `json:"description,omitempty"`
+	ProcessName string `json:"processName"`
+	WindowTitle string `json:"windowTitle,omitempty"`
+}
+
+// Per-session detection state
+type ProcessDetector struct {
+	latest   []ReportedProcess
+	active   map[string]ProcessMatch
+	lastScan time.Time
+	timer    *time.Timer
+	stopped  bool
+	mu       sync.Mutex
+}
+
+// Scan the latest list against the rules, caller must hold d.mu
+func (d *ProcessDetector) scan(rules *ProcessRuleSet) (appeared, disappeared, active []ProcessMatch, changed bool) {
+	d.lastScan = time.Now()
+	current := rules.Match(d.latest)
+
+	added := map[string]ProcessMatch{}
+	for key, match := range current {
+		if _, known := d.active[key]; !known {
+			added[key] = match
+		}
+	}
+	removed := map[string]ProcessMatch{}
+	for key, match := range d.active {
+		if _, still := current[key]; !still {
+			removed[key] = match
+		}
+	}
+	d.active = current
+
+	changed = len(added) > 0 || len(removed) > 0
+	return sortedMatches(added), sortedMatches(removed), sortedMatches(current), changed
+}
+
+// Cancel any scheduled scan
+func (d *ProcessDetector) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.stopped = true
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+}
+
+// Sorted list of matches
+func sortedMatches(matches map[string]ProcessMatch) []ProcessMatch {
+	list := make([]ProcessMatch, 0, len(matches))
+	for _, match := range matches {
+		list = append(list, match)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].RuleID != list[j].RuleID {
+			return list[i].RuleID < list[j].RuleID
+		}
+		return list[i].ProcessName < list[j].ProcessName
+	})
+	return list
+}
+
+// Queue a process list for scanning, at most once per detection interval
+func (s *Server) detectProcesses(code string, session *Session, payload *ProcessInfoPayload) {
+	if s.processRules == nil || s.processRules.Len() == 0 {
+		return
+	}
+
+	session.mu.Lock()
+	if session.detector == nil {
+		session.detector = &ProcessDetector{active: make(map[string]ProcessMatch)}
+	}
+	detector := session.detector
+	session.mu.Unlock()
+
+	detector.mu.Lock()
+	detector.latest = payload.Processes
+	if detector.timer != nil || detector.stopped {
+		// A scheduled scan will pick up this list
+		detector.mu.Unlock()
+		return
+	}
+
+	wait := s.config.DetectionInterval - time.Since(detector.lastScan)
+	if wait > 0 {
+		detector.timer = time.AfterFunc(wait, func() {
+			detector.mu.Lock()
+			detector.timer = nil
+			if detector.stopped {
+				detector.mu.Unlock()
+				return
+			}
+			appeared, disappeared, active, changed := detector.scan(s.processRules)
+			detector.mu.Unlock()
+
+			if changed {
+				s.sendProcessAlert(code, session, appeared, disappeared, active)
+			}
+		})
+		detector.mu.Unlock()
+		return
+	}
+
+	appeared, disappeared, active, changed := detector.scan(s.processRules)
+	detector.mu.Unlock()
+
+	if changed {
+		s.sendProcessAlert(code, session, appeared, disappeared, active)
+	}
+}
+
+// Push a processAlert to every viewer and record it in the audit log
+func (s *Server) sendProcessAlert(code string, session *Session, appeared, disappeared, active []ProcessMatch) {
+	log.Printf("🚨 Process alert for %s: %d new, %d cleared, %d active", code, len(appeared), len(disappeared), len(active))
+
+	alertPayload := ProcessAlertPayload{
+		Timestamp:   getCurrentTimestamp(),
+		Code:        code,
+		Appeared:    appeared,
+		Disappeared: disappeared,
+		Active:      active,
+	}
+	s.sendToViewers(code, session, createSimpleResponseMessage(ProcessAlert, alertPayload))
+
+	session.mu.RLock()
+	id := session.id()
+	session.mu.RUnlock()
+	s.auditServerEvent(id, ProcessAlert, alertPayload)
+}
+
+// Current alerts for a viewer joining mid-session, caller must hold session.mu
+func (sess *Session) activeProcessAlert(code string) *ResponseMessage {
+	detector := sess.detector
+	if detector == nil {
+		return nil
+	}
+
+	detector.mu.Lock()
+	defer detector.mu.Unlock()
+	if len(detector.active) == 0 {
+		return nil
+	}
+
+	response := createSimpleResponseMessage(ProcessAlert, ProcessAlertPayload{
+		Timestamp:   getCurrentTimestamp(),
+		Code:        code,
+		Appeared:    []ProcessMatch{},
+		Disappeared: []ProcessMatch{},
+		Active:      sortedMatches(detector.active),
+	})
+	return &response
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+)
+
+// Kind of display topology transition
+type DisplayChangeKind string
+
+const (
+	DisplayAdded      DisplayChangeKind = "display_added"      // a new display became active
+	DisplayRemoved    DisplayChangeKind = "display_removed"    // an active display went away
+	DisplayConnected  DisplayChangeKind = "display_connected"  // a monitor was plugged in, active or not
+	DisplayInactive   DisplayChangeKind = "display_inactive"   // a connected display stopped showing anything
+	ResolutionChanged DisplayChangeKind = "resolution_changed" // a display's size or scale changed
+	PrimaryChanged    DisplayChangeKind = "primary_changed"    // another display became primary
+	MirroringStarted  DisplayChangeKind = "mirroring_started"  // two displays now show the same area
+	MirroringStopped  DisplayChangeKind = "mirroring_stopped"  // displays are no longer mirrored
+)
+
+// All display change kinds, in the order they are documented in the schema
+var displayChangeKinds = []DisplayChangeKind{
+	DisplayAdded, DisplayRemoved, DisplayConnected, DisplayInactive,
+	ResolutionChanged, PrimaryChanged, MirroringStarted, MirroringStopped,
+}
+
+// Severity of each kind, using the process rule scale
+var displayChangeSeverity = map[DisplayChangeKind]string{
+	DisplayAdded:      "high",
+	DisplayRemoved:    "low",
+	DisplayConnected:  "high",
+	DisplayInactive:   "medium",
+	ResolutionChanged: "low",
+	PrimaryChanged:    "medium",
+	MirroringStarted:  "high",
+	MirroringStopped:  "low",
+}
+
+// One active display as the server understands it
+type TopologyDisplay struct {
+	ID          int64   `json:"id"`
+	Width       int     `json:"width"`  // physical pixels
+	Height      int     `json:"height"` // physical pixels
+	ScaleFactor float64 `json:"scaleFactor"`
+	Internal    bool    `json:"internal"`
+	Primary     bool    `json:"primary"`
+}
+
+// One transition in a displayAlert
+type DisplayChange struct {
+	Kind      DisplayChangeKind `json:"kind"`
+	Severity  string            `json:"severity"`
+	DisplayID *int64            `json:"displayId,omitempty"`
+	Message   string            `json:"message"`
+}
+
+// Display change kinds as strings, for the schema
+func displayChangeKindNames() []string {
+	names := make([]string, len(displayChangeKinds))
+	for i, kind := range displayChangeKinds {
+		names[i] = string(kind)
+	}
+	return names
+}
+
+// Resolution as WIDTHxHEIGHT
+func (d TopologyDisplay) Resolution() string {
+	return fmt.Sprintf("%dx%d", d.Width, d.Height)
+}
+
+// Monitor setup parsed from a monitorInfo report
+type DisplayTopology struct {
+	Count     int               `json:"count"`     // active displays
+	Connected int               `json:"connected"` // monitors attached, including inactive ones
+	Inactive  int               `json:"inactive"`
+	Primary   *int64            `json:"primary"`
+	Mirrored  bool              `json:"mirrored"` // two or more displays cover the same area
+	Displays  []TopologyDisplay `json:"displays"`
+}
+
+// Parse a monitorInfo report
+func NewDisplayTopology(info *MonitorInfoPayload) *DisplayTopology {
+	topology := &DisplayTopology{
+		Count:    len(info.Displays),
+		Inactive: info.Inactive,
+		Primary:  info.Primary,
+	}
+
+	covered := make(map[Rect]bool, len(info.Displays))
+	for _, display := range info.Displays {
+		scale := display.ScaleFactor
+		if scale <= 0 {
+			scale = 1
+		}
+		topology.Displays = append(topology.Displays, TopologyDisplay{
+			ID:          display.ID,
+			Width:       int(math.Round(float64(display.Bounds.Width) * scale)),
+			Height:      int(math.Round(float64(display.Bounds.Height) * scale)),
+			ScaleFactor: scale,
+			Internal:    display.Internal,
+			Primary:     display.IsPrimary || (info.Primary != nil && *info.Primary == display.ID),
+		})
+		if covered[display.Bounds] {
+			topology.Mirrored = true
+		}
+		covered[display.Bounds] = true
+	}
+	sort.Slice(topology.Displays, func(i, j int) bool { return topology.Displays[i].ID < topology.Displays[j].ID })
+
+	// Windows also reports monitors that are attached but not in use. The
+	// client's total already counts them, and pnpInfo.totalWithInactive adds
+	// them a second time and comes with only some reports, so it is ignored.
+	topology.Connected = max(topology.Count+topology.Inactive, info.Total)
+	if topology.Primary == nil {
+		for _, display := range topology.Displays {
+			if display.Primary {
+				id := display.ID
+				topology.Primary = &id
+				break
+			}
+		}
+	}
+	return topology
+}
+
+func (t *DisplayTopology) display(id int64) (TopologyDisplay, bool) {
+	for _, display := range t.Displays {
+		if display.ID == id {
+			return display, true
+		}
+	}
+	return TopologyDisplay{}, false
+}
+
+// Transitions from an earlier topology to this one
+func (t *DisplayTopology) ChangesSince(before *DisplayTopology) []DisplayChange {
+	var changes []DisplayChange
+	add := func(kind DisplayChangeKind, id *int64, format string, args ...interface{}) {
+		changes = append(changes, DisplayChange{
+			Kind:      kind,
+			Severity:  displayChangeSeverity[kind],
+			DisplayID: id,
+			Message:   fmt.Sprintf(format, args...),
+		})
+	}
+
+	for _, display := range t.Displays {
+		id := display.ID
+		previous, existed := before.display(id)
+		switch {
+		case !existed:
+			add(DisplayAdded, &id, "Display %d (%s) became active, %d active now", id, display.Resolution(), t.Count)
+		case previous.Width != display.Width || previous.Height != display.Height || previous.ScaleFactor != display.ScaleFactor:
+			add(ResolutionChanged, &id, "Display %d changed from %s at %gx to %s at %gx", id,
+				previous.Resolution(), previous.ScaleFactor, display.Resolution(), display.ScaleFactor)
+		}
+	}
+	for _, display := range before.Displays {
+		if _, exists := t.display(display.ID); !exists {
+			id := display.ID
+			add(DisplayRemoved, &id, "Display %d (%s) is no longer active, %d active now", id, display.Resolution(), t.Count)
+		}
+	}
+
+	if t.Connected > before.Connected {
+		add(DisplayConnected, nil, "%d monitor(s) connected, %d before", t.Connected, before.Connected)
+	}
+	// A monitor plugged in inactive is already reported as connected
+	if t.Inactive-before.Inactive > max(0, t.Connected-before.Connected) {
+		add(DisplayInactive, nil, "%d connected display(s) inactive, %d before", t.Inactive, before.Inactive)
+	}
+	if t.Primary != nil && before.Primary != nil && *t.Primary != *before.Primary {
+		id := *t.Primary
+		add(PrimaryChanged, &id, "Display %d is now primary instead of display %d", id, *before.Primary)
+	}
+	if t.Mirrored && !before.Mirrored {
+		add(MirroringStarted, nil, "Displays are mirrored")
+	} else if !t.Mirrored && before.Mirrored {
+		add(MirroringStopped, nil, "Displays are no longer mirrored")
+	}
+	return changes
+}
+
+// Track the client's monitor setup and alert viewers to transitions
+func (s *Server) detectDisplayChanges(code string, session *Session, info *MonitorInfoPayload) {
+	topology := NewDisplayTopology(info)
+
+	session.mu.Lock()
+	before := session.displays
+	session.displays = topology
+	session.mu.Unlock()
+
+	// The first report is the baseline
+	if before == nil {
+		return
+	}
+	changes := topology.ChangesSince(before)
+	if len(changes) == 0 {
+		return
+	}
+
+	log.Printf("🖥️ Display alert for %s: %d change(s), %d active of %d connected", code, len(changes), topology.Count, topology.Connected)
+
+	alertPayload := DisplayAlertPayload{
+		Timestamp: getCurrentTimestamp(),
+		Code:      code,
+		Changes:   changes,
+		Topology:  topology,
+	}
+	s.sendToViewers(code, session, createSimpleResponseMessage(DisplayAlert, alertPayload))
+
+	session.mu.RLock()
+	id := session.id()
+	session.mu.RUnlock()
+	s.auditServerEvent(id, DisplayAlert, alertPayload)
+}
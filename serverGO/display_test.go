@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Active display at x on a 1920x1080 grid
+func screen(id int64, x int, scale float64) DisplayInfo {
+	return DisplayInfo{ID: id, Bounds: Rect{X: x, Width: 1920, Height: 1080}, ScaleFactor: scale}
+}
+
+func topology(primary int64, inactive int, displays ...DisplayInfo) *DisplayTopology {
+	return NewDisplayTopology(&MonitorInfoPayload{Primary: &primary, Inactive: inactive, Displays: displays})
+}
+
+func changeKinds(changes []DisplayChange) []DisplayChangeKind {
+	kinds := []DisplayChangeKind{}
+	for _, change := range changes {
+		kinds = append(kinds, change.Kind)
+	}
+	return kinds
+}
+
+func TestNewDisplayTopology(t *testing.T) {
+	info := &MonitorInfoPayload{
+		Total:    3,
+		Inactive: 1,
+		PnpInfo:  &PnpInfo{TotalWithInactive: 4},
+		Displays: []DisplayInfo{screen(2, 1920, 1.5), {ID: 1, Bounds: Rect{Width: 1920, Height: 1080}, IsPrimary: true}},
+	}
+	got := NewDisplayTopology(info)
+
+	if got.Count != 2 || got.Connected != 3 || got.Inactive != 1 || got.Mirrored {
+		t.Fatalf("topology %+v", got)
+	}
+	if got.Primary == nil || *got.Primary != 1 {
+		t.Fatalf("primary %v, want display 1", got.Primary)
+	}
+	want := []TopologyDisplay{
+		{ID: 1, Width: 1920, Height: 1080, ScaleFactor: 1, Primary: true},
+		{ID: 2, Width: 2880, Height: 1620, ScaleFactor: 1.5},
+	}
+	if !reflect.DeepEqual(got.Displays, want) {
+		t.Fatalf("displays %+v, want %+v", got.Displays, want)
+	}
+}
+
+func TestDisplayChangesSince(t *testing.T) {
+	single := topology(1, 0, screen(1, 0, 1))
+	cases := []struct {
+		name   string
+		before *DisplayTopology
+		after  *DisplayTopology
+		want   []DisplayChangeKind
+	}{
+		{"unchanged", single, topology(1, 0, screen(1, 0, 1)), []DisplayChangeKind{}},
+		{"second display", single, topology(1, 0, screen(1, 0, 1), screen(2, 1920, 1)),
+			[]DisplayChangeKind{DisplayAdded, DisplayConnected}},
+		{"display unplugged", topology(1, 0, screen(1, 0, 1), screen(2, 1920, 1)), single,
+			[]DisplayChangeKind{DisplayRemoved}},
+		{"scale changed", single, topology(1, 0, screen(1, 0, 2)),
+			[]DisplayChangeKind{ResolutionChanged}},
+		{"plugged in inactive", single, topology(1, 1, screen(1, 0, 1)),
+			[]DisplayChangeKind{DisplayConnected}},
+		{"went inactive", topology(1, 0, screen(1, 0, 1), screen(2, 1920, 1)), topology(1, 1, screen(1, 0, 1)),
+			[]DisplayChangeKind{DisplayRemoved, DisplayInactive}},
+		{"primary swapped", topology(1, 0, screen(1, 0, 1), screen(2, 1920, 1)), topology(2, 0, screen(1, 0, 1), screen(2, 1920, 1)),
+			[]DisplayChangeKind{PrimaryChanged}},
+		{"mirroring", topology(1, 0, screen(1, 0, 1), screen(2, 1920, 1)), topology(1, 0, screen(1, 0, 1), screen(2, 0, 1)),
+			[]DisplayChangeKind{MirroringStarted}},
+		{"mirroring ended", topology(1, 0, screen(1, 0, 1), screen(2, 0, 1)), topology(1, 0, screen(1, 0, 1), screen(2, 1920, 1)),
+			[]DisplayChangeKind{MirroringStopped}},
+	}
+	for _, c := range cases {
+		if got := changeKinds(c.after.ChangesSince(c.before)); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDisplayChangeDetails(t *testing.T) {
+	before := topology(1, 0, screen(1, 0, 1))
+	after := topology(2, 0, screen(1, 0, 1), screen(2, 1920, 1))
+
+	changes := after.ChangesSince(before)
+	if len(changes) != 3 {
+		t.Fatalf("changes %+v", changes)
+	}
+	added := changes[0]
+	if added.Kind != DisplayAdded || added.Severity != "high" || added.DisplayID == nil || *added.DisplayID != 2 {
+		t.Fatalf("added %+v", added)
+	}
+	if want := "Display 2 (1920x1080) became active, 2 active now"; added.Message != want {
+		t.Fatalf("message %q, want %q", added.Message, want)
+	}
+	if connected := changes[1]; connected.Kind != DisplayConnected || connected.DisplayID != nil {
+		t.Fatalf("connected %+v", connected)
+	}
+	if primary := changes[2]; primary.Kind != PrimaryChanged || primary.Severity != "medium" || *primary.DisplayID != 2 {
+		t.Fatalf("primary %+v", primary)
+	}
+}
+
+// monitorInfo payloads as the client sends them: a laptop with one external
+// monitor and one more attached but inactive. Reports sent on registration
+// and after a refresh carry no pnpInfo, periodic ones add it with the
+// inactive monitor counted twice.
+const (
+	clientDisplays = `"primary":1,"external":1,"internal":1,"active":2,"displays":[
+		{"id":1,"bounds":{"x":0,"y":0,"width":1536,"height":960},"scaleFactor":1.25,"internal":true,"isPrimary":true,"size":"1536x960"},
+		{"id":2,"bounds":{"x":1536,"y":0,"width":1920,"height":1080},"scaleFactor":1,"internal":false,"isPrimary":false,"size":"1920x1080"}]`
+	clientReport         = `{` + clientDisplays + `,"inactive":1,"total":3}`
+	clientReportWithPnp  = `{` + clientDisplays + `,"inactive":1,"total":3,"pnpInfo":{"totalWithInactive":4,"lastUpdated":"2026-10-17T09:00:00.000Z"}}`
+	clientReportNoTotals = `{` + clientDisplays + `,"inactive":0,"pnpInfo":{"totalWithInactive":null,"lastUpdated":"2026-10-17T09:00:05.000Z"}}`
+)
+
+func TestDisplayTopologyFromClientReports(t *testing.T) {
+	parse := func(raw string) *DisplayTopology {
+		var info MonitorInfoPayload
+		if err := json.Unmarshal([]byte(raw), &info); err != nil {
+			t.Fatal(err)
+		}
+		return NewDisplayTopology(&info)
+	}
+
+	plain, withPnp := parse(clientReport), parse(clientReportWithPnp)
+	if plain.Count != 2 || plain.Connected != 3 || withPnp.Connected != 3 {
+		t.Fatalf("connected %d without pnpInfo, %d with it, want 3", plain.Connected, withPnp.Connected)
+	}
+
+	// Alternating report shapes describe the same setup
+	reports := []string{clientReport, clientReportWithPnp, clientReport, clientReportWithPnp, clientReportWithPnp, clientReport}
+	before := parse(reports[0])
+	for i, raw := range reports[1:] {
+		after := parse(raw)
+		if changes := after.ChangesSince(before); len(changes) != 0 {
+			t.Errorf("report %d raised %v", i+1, changeKinds(changes))
+		}
+		before = after
+	}
+
+	// Without the inactive count only the active displays are known
+	if got := parse(clientReportNoTotals); got.Connected != 2 {
+		t.Fatalf("connected %d from a report without totals", got.Connected)
+	}
+
+	// A monitor really plugged in still raises display_connected
+	plugged := strings.Replace(clientReportWithPnp, `"inactive":1,"total":3`, `"inactive":2,"total":4`, 1)
+	if got := changeKinds(parse(plugged).ChangesSince(withPnp)); !reflect.DeepEqual(got, []DisplayChangeKind{DisplayConnected}) {
+		t.Fatalf("plugging in a monitor raised %v", got)
+	}
+}
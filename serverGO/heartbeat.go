@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Heartbeat defaults
+const (
+	DEFAULT_HEARTBEAT_INTERVAL = 10 * time.Second // time between server pings
+	DEFAULT_HEARTBEAT_GRACE    = 30 * time.Second // silence tolerated before a peer is dead
+	CONTROL_WRITE_WAIT         = 5 * time.Second  // deadline for writing a ping frame
+)
+
+// Arm read deadlines and pong handling on a fresh socket
+func (s *Server) armHeartbeat(ws *websocket.Conn) {
+	ws.SetReadDeadline(time.Now().Add(s.config.HeartbeatGrace))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(s.config.HeartbeatGrace))
+	})
+}
+
+// Ping the peer until done is closed, dropping the socket if a ping cannot be written
+func (s *Server) runHeartbeat(conn *Connection, done <-chan struct{}) {
+	ticker := time.NewTicker(s.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.Ping(); err != nil {
+				log.Printf("💔 Heartbeat failed for %s: %v", conn.ID, err)
+				conn.Close()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Admin commands deciding on a client in the waiting room
+const (
+	AdmitCommand  = "admit"
+	RejectCommand = "reject"
+)
+
+// Client held in the waiting room until a viewer admits it
+type WaitingClient struct {
+	Conn       *Connection
+	ClientInfo interface{}
+	Since      time.Time
+}
+
+// Arguments of the reject command
+type rejectArgs struct {
+	Reason string `json:"reason"`
+}
+
+// Hold a registering client until a viewer admits it, caller must hold s.mu
+func (s *Server) holdInWaitingRoom(conn *Connection, code string, clientInfo interface{}) {
+	if existing := s.waiting[code]; existing != nil && existing.Conn != conn && existing.Conn.IsOpen() {
+		s.recordFailedRegistration(conn, code)
+		s.metrics.RegistrationFailed(ErrSessionConflict)
+		conn.Send(createErrorMessage(ErrSessionConflict, "Another client is already waiting for this code"))
+		go func() {
+			time.Sleep(s.config.ErrorCloseDelay)
+			conn.Close()
+		}()
+		return
+	}
+
+	waiting := &WaitingClient{Conn: conn, ClientInfo: clientInfo, Since: time.Now()}
+	s.waiting[code] = waiting
+	conn.Role = ClientRole
+	conn.SessionCode = code
+	if pending := s.pendingCodes[code]; pending != nil {
+		conn.SessionID = pending.ID
+	}
+	s.attempts.Reset(ipAttemptKey(conn.RemoteIP))
+
+	log.Printf("🚪 Client %s waiting to be admitted to %s", conn.ID, code)
+	conn.Send(createSimpleResponseMessage(WaitingRoom, WaitingRoomPayload{
+		Timestamp: getCurrentTimestamp(),
+		Message:   "Waiting for the interviewer to let you in",
+	}))
+	s.sendToPending(code, waiting.notice(code))
+}
+
+// clientWaiting message announcing this client
+func (w *WaitingClient) notice(code string) ResponseMessage {
+	return createSimpleResponseMessage(ClientWaiting, ClientWaitingPayload{
+		Timestamp:  getCurrentTimestamp(),
+		Code:       code,
+		ClientInfo: w.ClientInfo,
+		Since:      w.Since.UnixMilli(),
+	})
+}
+
+// Send a message to viewers waiting on a pending code here and on other
+// nodes, caller must hold s.mu
+func (s *Server) sendToPending(code string, message ResponseMessage) {
+	if pending := s.pendingCodes[code]; pending != nil {
+		for _, viewer := range pending.Viewers {
+			if viewer.IsOpen() {
+				viewer.Send(message)
+			}
+		}
+	}
+	s.relayRemote(code, ViewerRole, "", message)
+}
+
+// Admit or reject the client waiting for code, false if none is waiting here.
+// Caller must hold s.mu.
+func (s *Server) decideWaiting(code string, admit bool, reason string) bool {
+	waiting := s.waiting[code]
+	if waiting == nil || !waiting.Conn.IsOpen() {
+		return false
+	}
+	delete(s.waiting, code)
+
+	if admit {
+		log.Printf("🚪 Client %s admitted to %s", waiting.Conn.ID, code)
+		s.registerClient(waiting.Conn, code, waiting.ClientInfo)
+		return true
+	}
+
+	if reason == "" {
+		reason = "The interviewer did not admit you"
+	}
+	log.Printf("🚪 Client %s turned away from %s: %s", waiting.Conn.ID, code, reason)
+	s.turnAway(code, waiting, ErrRejected, reason)
+	return true
+}
+
+// Refuse a waiting client and tell the viewers it is gone, caller must hold s.mu
+func (s *Server) turnAway(code string, waiting *WaitingClient, errorCode ErrorCode, message string) {
+	s.metrics.RegistrationFailed(errorCode)
+	waiting.Conn.Send(createErrorMessage(errorCode, message))
+	go func() {
+		time.Sleep(s.config.ErrorCloseDelay)
+		waiting.Conn.Close()
+	}()
+	s.sendToPending(code, createSimpleResponseMessage(ClientDisconnected, ClientDisconnectedPayload{
+		Timestamp: getCurrentTimestamp(),
+		Code:      code,
+	}))
+}
+
+// Send away the client waiting for a code that is going away, caller must hold s.mu
+func (s *Server) closeWaitingRoom(code, message string) {
+	if waiting := s.waiting[code]; waiting != nil {
+		delete(s.waiting, code)
+		s.turnAway(code, waiting, ErrInvalidCode, message)
+	}
+}
+
+// Forget a waiting client that disconnected, caller must hold s.mu
+func (s *Server) leaveWaitingRoom(code string, conn *Connection) {
+	if waiting := s.waiting[code]; waiting == nil || waiting.Conn != conn {
+		return
+	}
+	delete(s.waiting, code)
+	log.Printf("🚪 Client %s left the waiting room for %s", conn.ID, code)
+	s.sendToPending(code, createSimpleResponseMessage(ClientDisconnected, ClientDisconnectedPayload{
+		Timestamp: getCurrentTimestamp(),
+		Code:      code,
+	}))
+}
+
+// Handle the admit and reject admin commands
+func (s *Server) handleWaitingRoomCommand(conn *Connection, code string, payload *AdminCommandPayload) {
+	if !s.onPanel(conn, code) {
+		conn.Send(createErrorMessage(ErrForbidden, "Not on the panel for this session"))
+		return
+	}
+
+	var args rejectArgs
+	if payload.Command == RejectCommand && len(payload.Args) > 0 {
+		if err := json.Unmarshal(payload.Args, &args); err != nil {
+			conn.Send(createErrorMessage(ErrInvalidPayload, "Reject arguments must be an object with a reason"))
+			return
+		}
+	}
+
+	s.mu.Lock()
+	decided := s.decideWaiting(code, payload.Command == AdmitCommand, args.Reason)
+	s.mu.Unlock()
+
+	message := "Client admitted"
+	if payload.Command == RejectCommand {
+		message = "Client rejected"
+	}
+	if !decided {
+		if !s.isRemoteCode(code) {
+			conn.Send(createSimpleResponseMessage(AdminCommandResponse, AdminCommandResponsePayload{
+				Command: payload.Command,
+				Success: false,
+				Message: "No client is waiting for this code",
+			}))
+			return
+		}
+
+		// The client may be waiting on another node
+		command := createSimpleResponseMessage(AdminCommand, payload)
+		command.From = conn.ID
+		s.relayRemote(code, ClientRole, "", command)
+		message = "Decision sent to the node holding the client"
+	}
+
+	conn.Send(createSimpleResponseMessage(AdminCommandResponse, AdminCommandResponsePayload{
+		Command: payload.Command,
+		Success: true,
+		Message: message,
+	}))
+}
+
+// Apply an admit or reject relayed by another node, false for other messages
+func (s *Server) deliverWaitingRoomCommand(code string, message *ResponseMessage) bool {
+	if message.Type != AdminCommand {
+		return false
+	}
+	data, err := json.Marshal(message.Payload)
+	if err != nil {
+		return false
+	}
+	var command AdminCommandPayload
+	if err := json.Unmarshal(data, &command); err != nil {
+		return false
+	}
+	if command.Command != AdmitCommand && command.Command != RejectCommand {
+		return false
+	}
+
+	var args rejectArgs
+	json.Unmarshal(command.Args, &args)
+
+	s.mu.Lock()
+	s.decideWaiting(code, command.Command == AdmitCommand, args.Reason)
+	s.mu.Unlock()
+	return true
+}
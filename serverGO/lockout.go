@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failed registration lockout settings
+const (
+	LOCKOUT_THRESHOLD = 5                // failures before the first lockout
+	LOCKOUT_BASE      = 30 * time.Second // first lockout, doubled for each further failure
+	LOCKOUT_MAX       = 1 * time.Hour    // upper bound on a single lockout
+	LOCKOUT_WINDOW    = 15 * time.Minute // failures older than this are forgotten
+)
+
+// Failed attempt history for a single key
+type attemptRecord struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Tracks failed attempts per key with exponential lockout
+type AttemptLimiter struct {
+	records   map[string]*attemptRecord
+	threshold int
+	base      time.Duration
+	max       time.Duration
+	window    time.Duration
+	mu        sync.Mutex
+}
+
+// Create new attempt limiter
+func NewAttemptLimiter(threshold int, base, max, window time.Duration) *AttemptLimiter {
+	return &AttemptLimiter{
+		records:   make(map[string]*attemptRecord),
+		threshold: threshold,
+		base:      base,
+		max:       max,
+		window:    window,
+	}
+}
+
+// Remaining lockout for the key, if any
+func (a *AttemptLimiter) Locked(key string) (time.Duration, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	record := a.records[key]
+	if record == nil {
+		return 0, false
+	}
+
+	remaining := time.Until(record.lockedUntil)
+	if remaining <= 0 {
+		return 0, false
+	}
+	return remaining, true
+}
+
+// Record a failure and return the lockout it triggered, if any
+func (a *AttemptLimiter) Fail(key string) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	record := a.records[key]
+	if record == nil || now.Sub(record.lastFailure) > a.window {
+		record = &attemptRecord{}
+		a.records[key] = record
+	}
+
+	record.failures++
+	record.lastFailure = now
+
+	if record.failures < a.threshold {
+		return 0
+	}
+
+	lockout := a.base
+	for i := a.threshold; i < record.failures && lockout < a.max; i++ {
+		lockout *= 2
+	}
+	if lockout > a.max {
+		lockout = a.max
+	}
+
+	record.lockedUntil = now.Add(lockout)
+	return lockout
+}
+
+// Forget failures for the key after a success
+func (a *AttemptLimiter) Reset(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.records, key)
+}
+
+// Drop records that are neither locked nor inside the failure window
+func (a *AttemptLimiter) Cleanup() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	removed := 0
+	for key, record := range a.records {
+		if now.After(record.lockedUntil) && now.Sub(record.lastFailure) > a.window {
+			delete(a.records, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Limiter keys for registration attempts, by IP and by code
+func ipAttemptKey(ip string) string     { return "ip:" + ip }
+func codeAttemptKey(code string) string { return "code:" + code }
+
+// Login failures are tracked apart from registrations, by IP and by username
+func loginIPKey(ip string) string         { return "login-ip:" + ip }
+func loginUserKey(username string) string { return "login-user:" + username }
+
+// Client IP for a request, honouring X-Forwarded-For only when trustProxyHeaders is set.
+// Only the rightmost entry, appended by our proxy, is trusted; anything to its
+// left came from the client and can be forged.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.config.TrustProxyHeaders {
+		// A proxy may append its own header line rather than extend the first one
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			entries := strings.Split(values[len(values)-1], ",")
+			if ip := strings.TrimSpace(entries[len(entries)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
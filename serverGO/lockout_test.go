@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAttemptLimiterBackoff(t *testing.T) {
+	limiter := NewAttemptLimiter(3, time.Minute, 5*time.Minute, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		if lockout := limiter.Fail("ip:a"); lockout != 0 {
+			t.Fatalf("failure %d locked out for %s", i+1, lockout)
+		}
+	}
+	if _, locked := limiter.Locked("ip:a"); locked {
+		t.Fatal("locked before the threshold")
+	}
+
+	// Lockouts double from the threshold on and stop at the max
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		if lockout := limiter.Fail("ip:a"); lockout != want {
+			t.Fatalf("lockout %s, want %s", lockout, want)
+		}
+	}
+	if remaining, locked := limiter.Locked("ip:a"); !locked || remaining > 5*time.Minute || remaining < 4*time.Minute {
+		t.Fatalf("remaining %s, locked %t", remaining, locked)
+	}
+	if _, locked := limiter.Locked("ip:b"); locked {
+		t.Fatal("lockout leaked to another key")
+	}
+
+	limiter.Reset("ip:a")
+	if _, locked := limiter.Locked("ip:a"); locked {
+		t.Fatal("still locked after a reset")
+	}
+	if lockout := limiter.Fail("ip:a"); lockout != 0 {
+		t.Fatalf("reset kept old failures, locked out for %s", lockout)
+	}
+}
+
+func TestAttemptLimiterExpiry(t *testing.T) {
+	limiter := NewAttemptLimiter(1, 50*time.Millisecond, time.Second, 300*time.Millisecond)
+
+	if lockout := limiter.Fail("code:123456"); lockout != 50*time.Millisecond {
+		t.Fatalf("lockout %s", lockout)
+	}
+	if _, locked := limiter.Locked("code:123456"); !locked {
+		t.Fatal("not locked after the threshold")
+	}
+	if removed := limiter.Cleanup(); removed != 0 {
+		t.Fatalf("cleanup removed %d live records", removed)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, locked := limiter.Locked("code:123456"); locked {
+		t.Fatal("lockout did not expire")
+	}
+
+	// A failure inside the window keeps doubling the lockout
+	if lockout := limiter.Fail("code:123456"); lockout != 100*time.Millisecond {
+		t.Fatalf("second lockout %s, want 100ms", lockout)
+	}
+
+	// Outside the window the history is forgotten
+	time.Sleep(350 * time.Millisecond)
+	if removed := limiter.Cleanup(); removed != 1 {
+		t.Fatalf("cleanup removed %d records, want 1", removed)
+	}
+	if lockout := limiter.Fail("code:123456"); lockout != 50*time.Millisecond {
+		t.Fatalf("lockout after the window %s, want the base 50ms", lockout)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	s := newTestServer(t)
+	request := httptest.NewRequest("GET", "/", nil)
+	request.RemoteAddr = "10.0.0.5:51234"
+	request.Header.Add("X-Forwarded-For", "1.2.3.4, 198.51.100.7")
+
+	if ip := s.clientIP(request); ip != "10.0.0.5" {
+		t.Fatalf("untrusted proxy headers gave %s", ip)
+	}
+
+	s.config.TrustProxyHeaders = true
+	if ip := s.clientIP(request); ip != "198.51.100.7" {
+		t.Fatalf("got %s, want the entry our proxy appended", ip)
+	}
+	request.Header.Add("X-Forwarded-For", "203.0.113.9")
+	if ip := s.clientIP(request); ip != "203.0.113.9" {
+		t.Fatalf("got %s, want the last header line", ip)
+	}
+	request.Header.Del("X-Forwarded-For")
+	if ip := s.clientIP(request); ip != "10.0.0.5" {
+		t.Fatalf("no header gave %s", ip)
+	}
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"math/rand"
+	"math"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,13 +18,16 @@ import (
 	"github.com/joho/godotenv"
 )
 
-// Configuration constants
+// Configuration defaults, see Config
 const (
-	MAX_BUFFER         = 1024 * 1024 * 10 // 10MB buffer
-	PROCESS_TIMEOUT    = 10 * time.Second // 10 seconds timeout
-	DETECTION_INTERVAL = 30 * time.Second // 30 seconds between scans
-	CLEANUP_INTERVAL   = 60 * time.Second // 1 minute cleanup interval
-	PENDING_CODE_TTL   = 30 * time.Minute // 30 minutes TTL for pending codes
+	DEFAULT_PORT                   = "3004"
+	DEFAULT_MAX_BUFFER             = 1024 * 1024 * 10        // 10MB buffer
+	DEFAULT_DETECTION_INTERVAL     = 30 * time.Second        // 30 seconds between scans
+	DEFAULT_CLEANUP_INTERVAL       = 60 * time.Second        // 1 minute cleanup interval
+	DEFAULT_PENDING_CODE_TTL       = 30 * time.Minute        // 30 minutes TTL for pending codes
+	DEFAULT_CLIENT_CONNECTED_DELAY = 1500 * time.Millisecond // let the client settle before viewers hear of it
+	DEFAULT_CONNECT_DELAY          = 1000 * time.Millisecond // let viewers get ready before WebRTC starts
+	DEFAULT_ERROR_CLOSE_DELAY      = 500 * time.Millisecond  // let the error reach the peer before closing
 )
 
 // Message types
@@ -41,8 +48,18 @@ const (
 	DisplayConfigChanged   MessageType = "displayConfigChanged"
 	MonitorInfo            MessageType = "monitorInfo"
 	ProcessInfo            MessageType = "processInfo"
+	ProcessAlert           MessageType = "processAlert"
 	AdminCommand           MessageType = "adminCommand"
 	AdminCommandResponse   MessageType = "adminCommandResponse"
+	SessionEnded           MessageType = "sessionEnded"
+	ServerShutdown         MessageType = "serverShutdown"
+	ClientWaiting          MessageType = "clientWaiting"
+	WaitingRoom            MessageType = "waitingRoom"
+	ConsentRequest         MessageType = "consentRequest"
+	ConsentAck             MessageType = "consentAck"
+	ProcessDelta           MessageType = "processDelta"
+	ProcessSnapshot        MessageType = "processSnapshot"
+	DisplayAlert           MessageType = "displayAlert"
 	Error                  MessageType = "error"
 )
 
@@ -54,26 +71,68 @@ const (
 	ViewerRole Role = "viewer"
 )
 
+// Panel roles for viewers sharing a session
+type PanelRole string
+
+const (
+	LeadRole          PanelRole = "lead"
+	CoInterviewerRole PanelRole = "co-interviewer"
+	ObserverRole      PanelRole = "observer"
+	RecorderRole      PanelRole = "recorder" // server-side recorder, never assignable to viewers
+)
+
+// Check if the panel role is one the server knows about
+func (p PanelRole) IsValid() bool {
+	return p == LeadRole || p == CoInterviewerRole || p == ObserverRole
+}
+
+// Check if the panel role may issue admin commands
+func (p PanelRole) CanAdmin() bool {
+	return p == LeadRole || p == CoInterviewerRole
+}
+
 // WebSocket connection wrapper
 type Connection struct {
 	ID          string          `json:"id"`
 	WS          *websocket.Conn `json:"-"`
 	Role        Role            `json:"role"`
+	PanelRole   PanelRole       `json:"panelRole,omitempty"`
+	Name        string          `json:"name,omitempty"`
+	Subject     string          `json:"subject,omitempty"`
+	RemoteIP    string          `json:"remoteIp"`
 	SessionCode string          `json:"sessionCode"`
+	SessionID   string          `json:"sessionId,omitempty"` // session behind SessionCode, codes are reused
+	Protocol    int             `json:"protocolVersion,omitempty"` // negotiated at registration
 	Connected   time.Time       `json:"connected"`
+	queue       []interface{}   `json:"-"` // outbound messages awaiting the writer
+	wake        chan struct{}   `json:"-"` // signals the writer, closed on Close
+	stats       OutboundStats   `json:"-"`
 	mu          sync.Mutex      `json:"-"`
 }
 
-// Send message to connection with thread safety
-func (c *Connection) Send(message interface{}) error {
+// Create connection wrapper and start its writer goroutine
+func newConnection(id string, ws *websocket.Conn) *Connection {
+	c := &Connection{
+		ID:        id,
+		WS:        ws,
+		Connected: time.Now(),
+		wake:      make(chan struct{}, 1),
+	}
+	go c.writeLoop()
+	return c
+}
+
+// Send a ping control frame, safe to call alongside Send
+func (c *Connection) Ping() error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	
-	if c.WS == nil {
+	ws := c.WS
+	c.mu.Unlock()
+
+	if ws == nil {
 		return fmt.Errorf("connection is nil")
 	}
-	
-	return c.WS.WriteJSON(message)
+
+	return ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(CONTROL_WRITE_WAIT))
 }
 
 // Check if connection is open
@@ -92,28 +151,115 @@ func (c *Connection) Close() {
 		c.WS.Close()
 		c.WS = nil
 	}
+	if c.wake != nil {
+		close(c.wake)
+		c.wake = nil
+	}
+	c.queue = nil
 }
 
 // Session info
 type SessionInfo struct {
+	ID          string      `json:"id,omitempty"`
 	CreatedAt   time.Time   `json:"createdAt"`
 	MonitorInfo interface{} `json:"monitorInfo"`
 	ProcessInfo interface{} `json:"processInfo"`
 	ClientInfo  interface{} `json:"clientInfo"`
+	Subject     string         `json:"subject,omitempty"`
+	Mode        SessionMode    `json:"mode,omitempty"`
+	Consent     *ConsentRecord `json:"consent,omitempty"`
 }
 
-// Session represents a client-viewer pair
+// Session represents a client and the panel of viewers watching it
 type Session struct {
-	Client *Connection
-	Viewer *Connection
-	Info   *SessionInfo
-	mu     sync.RWMutex
+	Client        *Connection
+	Viewers       map[string]*Connection
+	Info          *SessionInfo
+	dirty         bool                      // Info changed since it was last persisted
+	restoredAt    time.Time                 // set when loaded from the store after a restart
+	remoteClient  bool                      // client is connected to another node
+	remoteViewers map[string]ViewerIdentity // viewers connected to other nodes
+	recorder      *Recorder                 // hidden recording peer, nil unless recording
+	detector      *ProcessDetector          // process rule matches, nil until processInfo arrives
+	sfu           *SFU                      // forwards the client's tracks, nil unless in sfu mode
+	heldConnects  []ResponseMessage         // connect requests waiting on the client's consent
+	heldReports   map[MessageType]func()    // latest client reports waiting on the client's consent
+	processes     *ProcessTracker           // process list as viewers know it, nil until processInfo arrives
+	displays      *DisplayTopology          // last reported monitor setup, nil until monitorInfo arrives
+	mu            sync.RWMutex
+}
+
+// Open viewers in the session, caller must hold session.mu
+func (sess *Session) openViewers() []*Connection {
+	viewers := make([]*Connection, 0, len(sess.Viewers))
+	for _, viewer := range sess.Viewers {
+		if viewer.IsOpen() {
+			viewers = append(viewers, viewer)
+		}
+	}
+	return viewers
+}
+
+// ID of the session, caller must hold session.mu
+func (sess *Session) id() string {
+	if sess.Info == nil {
+		return ""
+	}
+	return sess.Info.ID
+}
+
+// Check if the session already has an open lead, caller must hold session.mu
+func (sess *Session) hasLead() bool {
+	for _, viewer := range sess.openViewers() {
+		if viewer.PanelRole == LeadRole {
+			return true
+		}
+	}
+	for _, viewer := range sess.remoteViewers {
+		if viewer.PanelRole == LeadRole {
+			return true
+		}
+	}
+	return false
+}
+
+// Identities of all open viewers across nodes, caller must hold session.mu
+func (sess *Session) viewerIdentities() []ViewerIdentity {
+	identities := []ViewerIdentity{}
+	for _, viewer := range sess.openViewers() {
+		identities = append(identities, viewer.Identity())
+	}
+	for _, viewer := range sess.remoteViewers {
+		identities = append(identities, viewer)
+	}
+	if sess.recorder != nil {
+		identities = append(identities, sess.recorder.Identity())
+	}
+	return identities
+}
+
+// Check if the client is reachable locally or through another node, caller must hold session.mu
+func (sess *Session) hasClient() bool {
+	return sess.remoteClient || (sess.Client != nil && sess.Client.IsOpen())
 }
 
 // Pending code data
 type PendingCode struct {
+	ID        string // becomes the session's ID once the client joins
 	CreatedAt time.Time
-	ViewerWS  *Connection
+	Subject   string
+	Mode      SessionMode
+	Viewers   map[string]*Connection
+}
+
+// Check if the pending code already has an open lead
+func (p *PendingCode) hasLead() bool {
+	for _, viewer := range p.Viewers {
+		if viewer.IsOpen() && viewer.PanelRole == LeadRole {
+			return true
+		}
+	}
+	return false
 }
 
 // Message structure - matches Node.js server exactly
@@ -122,6 +268,7 @@ type Message struct {
 	Code    string          `json:"code,omitempty"`
 	Payload json.RawMessage `json:"payload,omitempty"`
 	Role    Role            `json:"role,omitempty"`
+	Target  string          `json:"target,omitempty"`
 }
 
 // Response message structure - simplified to match Node.js exactly
@@ -129,16 +276,39 @@ type ResponseMessage struct {
 	Type      MessageType `json:"type"`
 	Payload   interface{} `json:"payload,omitempty"`
 	Timestamp *int64      `json:"timestamp,omitempty"`
+	From      string      `json:"from,omitempty"`
+}
+
+// Viewer identity carried in viewer notifications
+type ViewerIdentity struct {
+	ID        string    `json:"id"`
+	PanelRole PanelRole `json:"panelRole"`
+	Name      string    `json:"name,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
 }
 
-// Error payload
-type ErrorPayload struct {
-	Message string `json:"message"`
+// Identity of a viewer connection
+func (c *Connection) Identity() ViewerIdentity {
+	return ViewerIdentity{
+		ID:        c.ID,
+		PanelRole: c.PanelRole,
+		Name:      c.Name,
+		Subject:   c.Subject,
+	}
 }
 
-// Code assignment payload
-type CodeAssignmentPayload struct {
-	Code string `json:"code"`
+// ID of one session behind a code. Codes are recycled once a session ends,
+// so audit logs and recordings are keyed by this instead.
+func newSessionID(code string, createdAt time.Time) string {
+	return fmt.Sprintf("%s-%d", code, createdAt.UnixMilli())
+}
+
+// Code part of a session ID
+func sessionIDCode(id string) string {
+	if i := strings.LastIndex(id, "-"); i > 0 {
+		return id[:i]
+	}
+	return ""
 }
 
 // Helper function to get current timestamp in milliseconds
@@ -164,73 +334,95 @@ func createSimpleResponseMessage(msgType MessageType, payload interface{}) Respo
 	}
 }
 
-// Client info payload
-type ClientInfoPayload struct {
-	Timestamp  int64       `json:"timestamp"`
-	Code       string      `json:"code,omitempty"`
-	ClientInfo interface{} `json:"clientInfo,omitempty"`
-}
-
-// Admin command response payload
-type AdminCommandResponsePayload struct {
-	Command string `json:"command"`
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-}
-
 // Server struct
 type Server struct {
-	sessions      map[string]*Session
-	activeCodes   map[string]bool
-	pendingCodes  map[string]*PendingCode
-	connections   map[string]*Connection
-	nextConnID    int64
-	upgrader      websocket.Upgrader
-	mu            sync.RWMutex
+	sessions       map[string]*Session
+	activeCodes    map[string]bool
+	pendingCodes   map[string]*PendingCode
+	waiting        map[string]*WaitingClient // clients held in the waiting room, by code
+	schedule       *InterviewSchedule
+	connections    map[string]*Connection
+	nextConnID     int64
+	upgrader       websocket.Upgrader
+	auth           *Authenticator
+	store          SessionStore
+	backplane      Backplane
+	outbox         *ClusterOutbox // cluster events awaiting the backplane
+	nodeID         string
+	heldCodes      map[string]bool            // codes this node routes
+	remoteCodes    map[string]map[string]bool // code -> nodes holding it
+	clusterMu      sync.Mutex
+	codeFormat     CodeFormat
+	attempts       *AttemptLimiter
+	recording      RecordingConfig
+	audit          *AuditLog
+	processRules   *ProcessRuleSet
+	metrics        *Metrics
+	config         *Config
+	messageRates   map[MessageType]RateSpec
+	origins        *OriginPolicy
+	httpServer     *http.Server
+	redirectServer *http.Server
+	tls            TLSConfig
+	relay          *TURNServer    // embedded TURN server, nil when disabled
+	consent        *ConsentPolicy // consent text clients must acknowledge, nil when not required
+	draining       bool          // set once shutdown starts, new upgrades are refused
+	stop           chan struct{} // closed to stop the periodic routines
+	mu             sync.RWMutex
 }
 
 // Create new server
-func NewServer() *Server {
-	return &Server{
+func NewServer(config *Config) *Server {
+	s := &Server{
 		sessions:     make(map[string]*Session),
 		activeCodes:  make(map[string]bool),
 		pendingCodes: make(map[string]*PendingCode),
+		waiting:      make(map[string]*WaitingClient),
+		schedule:     NewInterviewSchedule(),
 		connections:  make(map[string]*Connection),
 		nextConnID:   1,
-		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins for now
-			},
-		},
+		store:        NewMemorySessionStore(),
+		backplane:    NewInProcessBackplane(nil),
+		outbox:       NewClusterOutbox(),
+		nodeID:       newNodeID(config.NodeID),
+		heldCodes:    make(map[string]bool),
+		remoteCodes:  make(map[string]map[string]bool),
+		codeFormat:   DefaultCodeFormat(),
+		audit:        NewAuditLog(config.AuditDir),
+		metrics:      NewMetrics(),
+		config:       config,
+		stop:         make(chan struct{}),
+		attempts:     NewAttemptLimiter(LOCKOUT_THRESHOLD, LOCKOUT_BASE, LOCKOUT_MAX, LOCKOUT_WINDOW),
 	}
+	s.upgrader.CheckOrigin = s.checkOrigin
+	return s
 }
 
-// Generate unique 6-digit code
-func (s *Server) generateUniqueCode() string {
+// Generate unique join code
+func (s *Server) generateUniqueCode() (string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	var code string
 	attempts := 0
 	maxAttempts := 10
 
 	for {
-		code = fmt.Sprintf("%06d", rand.Intn(900000)+100000)
+		code, err := s.codeFormat.Generate()
+		if err != nil {
+			return "", err
+		}
 		attempts++
 
-		if attempts >= maxAttempts {
+		if attempts == maxAttempts {
 			log.Printf("Warning: Many code generation attempts. Active codes count: %d", len(s.activeCodes))
-			code = fmt.Sprintf("%06d", rand.Intn(700000)+200000)
-			break
 		}
 
-		if !s.activeCodes[code] {
-			break
+		// Codes held by other nodes are taken too, or two interviews would share one
+		if !s.activeCodes[code] && s.pendingCodes[code] == nil && !s.isRemoteCode(code) {
+			s.activeCodes[code] = true
+			return code, nil
 		}
 	}
-
-	s.activeCodes[code] = true
-	return code
 }
 
 // Clean up expired pending codes
@@ -240,25 +432,92 @@ func (s *Server) cleanupExpiredCodes() {
 
 	now := time.Now()
 	for code, data := range s.pendingCodes {
-		if now.Sub(data.CreatedAt) > PENDING_CODE_TTL {
+		// Scheduled codes stay open until their window closes
+		if s.schedule.Reserves(code) {
+			continue
+		}
+		if now.Sub(data.CreatedAt) > s.config.PendingCodeTTL {
 			log.Printf("🧹 Removing expired pending code: %s", code)
+			s.metrics.Evicted(EvictExpiredPending, 1)
+			s.closeWaitingRoom(code, "This code has expired")
 			delete(s.pendingCodes, code)
+			s.forgetPending(code)
+			s.retireCode(code)
+		}
+	}
+	s.expireScheduled(now)
+
+	// Evict sessions restored from the store that nobody came back to
+	for code, session := range s.sessions {
+		session.mu.RLock()
+		abandoned := !session.restoredAt.IsZero() && session.Client == nil &&
+			len(session.Viewers) == 0 && now.Sub(session.restoredAt) > s.config.PendingCodeTTL
+		session.mu.RUnlock()
+
+		if abandoned {
+			log.Printf("🧹 Removing abandoned restored session: %s", code)
+			s.metrics.Evicted(EvictAbandonedSession, 1)
+			delete(s.sessions, code)
+			s.forgetSession(code)
+			s.retireCode(code)
 		}
 	}
+
+	if removed := s.attempts.Cleanup(); removed > 0 {
+		log.Printf("🧹 Forgot %d stale failed-attempt records", removed)
+		s.metrics.Evicted(EvictAttemptRecord, removed)
+	}
 }
 
 // Start cleanup routine
 func (s *Server) startCleanupRoutine() {
-	ticker := time.NewTicker(CLEANUP_INTERVAL)
+	ticker := time.NewTicker(s.config.CleanupInterval)
 	go func() {
-		for range ticker.C {
-			s.cleanupExpiredCodes()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.cleanupExpiredCodes()
+			case <-s.stop:
+				return
+			}
 		}
 	}()
 }
 
 // Handle WebSocket connection
 func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) {
+	ip := s.clientIP(r)
+
+	// Peers reconnecting during a restart should try again shortly
+	if s.isDraining() {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(s.config.ShutdownDrain.Seconds())+1))
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Interviewers present a bearer token or client certificate, candidates connect anonymously
+	subject := ""
+	if hasCredentials(r) {
+		claims, err := s.auth.VerifyRequest(r)
+		if err != nil {
+			log.Printf("🔒 Rejected connection from %s: %v", ip, err)
+			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+			return
+		}
+		subject = claims.Subject
+	}
+
+	// Anonymous peers locked out for guessing codes cannot reconnect to retry
+	if subject == "" {
+		if wait, locked := s.attempts.Locked(ipAttemptKey(ip)); locked {
+			log.Printf("🔒 Rejected locked-out connection from %s (%s remaining)", ip, wait.Round(time.Second))
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(wait.Seconds())+1))
+			http.Error(w, "Too many failed attempts", http.StatusTooManyRequests)
+			return
+		}
+	}
+
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("Failed to upgrade connection: %v", err)
@@ -271,20 +530,28 @@ func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) {
 	s.nextConnID++
 	s.mu.Unlock()
 
-	connection := &Connection{
-		ID:        connID,
-		WS:        conn,
-		Connected: time.Now(),
-	}
+	connection := newConnection(connID, conn)
+	connection.Subject = subject
+	connection.RemoteIP = ip
 
 	s.mu.Lock()
 	s.connections[connID] = connection
 	s.mu.Unlock()
 
-	log.Printf("🔌 New WebSocket connection established: %s", connID)
+	if subject != "" {
+		log.Printf("🔌 New WebSocket connection established: %s (interviewer %s)", connID, subject)
+	} else {
+		log.Printf("🔌 New WebSocket connection established: %s", connID)
+	}
+
+	// Ping the peer and expect pongs within the grace period
+	s.armHeartbeat(conn)
+	heartbeatDone := make(chan struct{})
+	go s.runHeartbeat(connection, heartbeatDone)
 
 	// Set up cleanup - this will be called when the function exits
 	defer func() {
+		close(heartbeatDone)
 		log.Printf("🔌 Connection %s closing", connID)
 		s.handleConnectionClose(connection)
 		connection.Close()
@@ -294,22 +561,35 @@ func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	// Handle messages directly in this goroutine
-	s.handleMessages(connection)
+	s.handleMessages(connection, conn)
 }
 
 // Handle incoming messages
-func (s *Server) handleMessages(conn *Connection) {
+func (s *Server) handleMessages(conn *Connection, ws *websocket.Conn) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Recovered from panic in handleMessages: %v", r)
 		}
 	}()
 
+	// Frames far beyond the limit are refused by the socket itself
+	limit := int64(s.config.MaxBuffer)
+	ws.SetReadLimit(2 * limit)
+	limiter := s.newMessageLimiter()
+
 	for {
-		var msg Message
-		err := conn.WS.ReadJSON(&msg)
+		data, err := readLimitedMessage(ws, limit)
+		if errors.Is(err, errMessageTooLarge) {
+			log.Printf("📏 Message from %s exceeds %d bytes, disconnecting", conn.ID, limit)
+			s.metrics.MessageTooLarge()
+			conn.Send(createErrorMessage(ErrMessageTooLarge, fmt.Sprintf("Messages are limited to %d bytes", limit)))
+			s.closeAfterError(conn, websocket.CloseMessageTooBig, "Message too large")
+			break
+		}
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				log.Printf("💔 No heartbeat from %s within %s, treating as disconnected", conn.ID, s.config.HeartbeatGrace)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error for %s: %v", conn.ID, err)
 			} else {
 				log.Printf("WebSocket closed for %s: %v", conn.ID, err)
@@ -317,6 +597,44 @@ func (s *Server) handleMessages(conn *Connection) {
 			break
 		}
 
+		// Any traffic proves the peer is alive
+		ws.SetReadDeadline(time.Now().Add(s.config.HeartbeatGrace))
+
+		// Malformed messages still count against the connection-wide limit
+		var msg Message
+		parseErr := json.Unmarshal(data, &msg)
+		if parseErr != nil {
+			msg = Message{}
+		}
+
+		verdict, wait := limiter.Check(msg.Type, time.Now())
+		if verdict != rateAllow {
+			s.metrics.RateLimited(msg.Type)
+		}
+		if verdict == rateDisconnect {
+			log.Printf("🚦 %s kept exceeding rate limits, disconnecting", conn.ID)
+			conn.Send(createErrorMessage(ErrRateLimited, "Rate limit exceeded, disconnecting"))
+			s.closeAfterError(conn, websocket.ClosePolicyViolation, "Rate limit exceeded")
+			break
+		}
+		if verdict == rateDrop {
+			log.Printf("🚦 Dropping %s from %s, rate limit exceeded", msg.Type, conn.ID)
+			conn.Send(createSimpleResponseMessage(Error, ErrorPayload{
+				Code:       ErrRateLimited,
+				Message:    fmt.Sprintf("Too many %s messages, slow down", msg.Type),
+				RetryAfter: int(math.Ceil(wait.Seconds())),
+			}))
+		}
+		if verdict != rateAllow {
+			continue
+		}
+
+		if parseErr != nil {
+			log.Printf("⚠️ Malformed message from %s: %v", conn.ID, parseErr)
+			conn.Send(createErrorMessage(ErrInvalidMessage, "Message is not valid JSON"))
+			continue
+		}
+
 		log.Printf("Received message: %s from %s", msg.Type, conn.ID)
 		s.processMessage(conn, &msg)
 	}
@@ -327,149 +645,266 @@ func (s *Server) processMessage(conn *Connection, msg *Message) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Recovered from panic in processMessage: %v", r)
-			errorResponse := createSimpleResponseMessage(Error, map[string]string{
-				"message": "Internal server error",
-			})
-			conn.Send(errorResponse)
+			conn.Send(createErrorMessage(ErrInternal, "Internal server error"))
 		}
 	}()
 
+	msg.Code = s.codeFormat.Normalize(msg.Code)
+
+	payload, err := decodeMessage(msg)
+	if err != nil {
+		log.Printf("⚠️ Rejected %s from %s: %v", msg.Type, conn.ID, err)
+		conn.Send(createProtocolErrorMessage(err))
+		if msg.Type == Register || msg.Type == RequestCode {
+			var protocolErr *ProtocolError
+			if errors.As(err, &protocolErr) {
+				s.metrics.RegistrationFailed(protocolErr.Code)
+			}
+		}
+		return
+	}
+	s.metrics.MessageReceived(msg.Type)
+
 	switch msg.Type {
 	case RequestCode:
-		s.handleRequestCode(conn)
+		s.handleRequestCode(conn, payload.(*RequestCodePayload))
 	case Register:
-		s.handleRegister(conn, msg)
+		s.handleRegister(conn, msg, payload.(*RegisterPayload))
 	case Signal:
-		s.handleSignal(conn, msg)
+		s.handleSignal(conn, msg, payload.(*SignalPayload))
 	case Connect:
 		s.handleConnect(conn, msg)
 	case DisplayConfigChanged:
-		s.handleDisplayConfigChanged(conn, msg)
+		s.handleDisplayConfigChanged(conn, msg, payload.(*DisplayConfigChangedPayload))
 	case MonitorInfo:
-		s.handleMonitorInfo(conn, msg)
+		s.handleMonitorInfo(conn, msg, payload.(*MonitorInfoPayload))
 	case ProcessInfo:
-		s.handleProcessInfo(conn, msg)
+		s.handleProcessInfo(conn, msg, payload.(*ProcessInfoPayload))
 	case AdminCommand:
-		s.handleAdminCommand(conn, msg)
-	default:
-		log.Printf("Unknown message type: %s from %s", msg.Type, conn.ID)
-		errorResponse := createSimpleResponseMessage(Error, map[string]string{
-			"message": "Unknown message type",
-		})
-		conn.Send(errorResponse)
+		s.handleAdminCommand(conn, msg, payload.(*AdminCommandPayload))
+	case ConsentAck:
+		s.handleConsentAck(conn, msg, payload.(*ConsentAckPayload))
+	case ProcessSnapshot:
+		s.handleProcessSnapshot(conn, msg)
 	}
+
+	s.auditMessage(conn, msg)
 }
 
 // Handle request code message
-func (s *Server) handleRequestCode(conn *Connection) {
-	code := s.generateUniqueCode()
-	
-	s.mu.Lock()
-	s.pendingCodes[code] = &PendingCode{
-		CreatedAt: time.Now(),
-		ViewerWS:  conn,
+func (s *Server) handleRequestCode(conn *Connection, payload *RequestCodePayload) {
+	if !s.requireInterviewer(conn) || !s.negotiateProtocol(conn, payload.ProtocolVersion) {
+		return
+	}
+
+	code, err := s.generateUniqueCode()
+	if err != nil {
+		log.Printf("Error generating code: %v", err)
+		conn.Send(createErrorMessage(ErrInternal, "Could not generate code"))
+		return
 	}
-	s.mu.Unlock()
 
 	conn.SessionCode = code
 	conn.Role = ViewerRole
+	conn.PanelRole = LeadRole
 
-	response := createSimpleResponseMessage(CodeAssigned, map[string]string{"code": code})
-	err := conn.Send(response)
+	now := time.Now()
+	pending := &PendingCode{
+		ID:        newSessionID(code, now),
+		CreatedAt: now,
+		Subject:   conn.Subject,
+		Mode:      s.sessionMode(payload.Mode),
+		Viewers:   map[string]*Connection{conn.ID: conn},
+	}
+	conn.SessionID = pending.ID
+
+	s.mu.Lock()
+	s.pendingCodes[code] = pending
+	s.mu.Unlock()
+
+	s.persistPending(code, pending)
+	s.holdCode(code)
+
+	response := createSimpleResponseMessage(CodeAssigned, CodeAssignmentPayload{
+		Code:            code,
+		ProtocolVersion: conn.Protocol,
+		Mode:            pending.Mode,
+	})
+	err = conn.Send(response)
 	if err != nil {
 		log.Printf("Error sending code assignment: %v", err)
 		return
 	}
 
-	log.Printf("🎲 Generated new code for viewer: %s", code)
+	log.Printf("🎲 Generated new code for interviewer %s: %s", conn.Subject, code)
+}
+
+// Reject connections that did not authenticate as an interviewer
+func (s *Server) requireInterviewer(conn *Connection) bool {
+	if conn.Subject != "" {
+		return true
+	}
+
+	log.Printf("🔒 Unauthenticated connection %s attempted an interviewer action", conn.ID)
+	conn.Send(createErrorMessage(ErrUnauthenticated, "Authentication required"))
+	s.metrics.RegistrationFailed(ErrUnauthenticated)
+	return false
+}
+
+// Settle the protocol version for a connection, rejecting unsupported peers
+func (s *Server) negotiateProtocol(conn *Connection, requested int) bool {
+	version, err := negotiateProtocol(requested)
+	if err != nil {
+		log.Printf("⚠️ Connection %s asked for protocol %d: %v", conn.ID, requested, err)
+		conn.Send(createProtocolErrorMessage(err))
+		s.metrics.RegistrationFailed(ErrUnsupportedProtocol)
+		return false
+	}
+	conn.Protocol = version
+	return true
 }
 
 // Handle register message
-func (s *Server) handleRegister(conn *Connection, msg *Message) {
+func (s *Server) handleRegister(conn *Connection, msg *Message, payload *RegisterPayload) {
+	if !s.negotiateProtocol(conn, payload.ProtocolVersion) {
+		return
+	}
+
 	if msg.Role == ClientRole {
-		s.handleClientRegister(conn, msg)
+		s.handleClientRegister(conn, msg, payload)
 	} else if msg.Role == ViewerRole {
-		s.handleViewerRegister(conn, msg)
+		s.handleViewerRegister(conn, msg, payload)
 	}
 }
 
 // Handle client registration
-func (s *Server) handleClientRegister(conn *Connection, msg *Message) {
+func (s *Server) handleClientRegister(conn *Connection, msg *Message, payload *RegisterPayload) {
 	code := msg.Code
 	log.Printf("🔍 Client attempting to register with code: %s", code)
 
+	// Refuse locked-out IPs and codes before looking anything up
+	if wait, locked := s.registrationLockout(conn, code); locked {
+		log.Printf("🔒 Registration from %s locked out for %s", conn.RemoteIP, wait.Round(time.Second))
+		retryAfter := int(wait.Seconds()) + 1
+		s.metrics.RegistrationFailed(ErrLockedOut)
+		conn.Send(createSimpleResponseMessage(Error, ErrorPayload{
+			Code:       ErrLockedOut,
+			Message:    fmt.Sprintf("Too many failed attempts, try again in %d seconds", retryAfter),
+			RetryAfter: retryAfter,
+		}))
+		go func() {
+			time.Sleep(s.config.ErrorCloseDelay)
+			conn.Close()
+		}()
+		return
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Parse client info payload
+	// Scheduled interviews only take new clients inside their window
+	if interview := s.schedule.Lookup(code); interview != nil && !s.clientJoined(code) {
+		if now := time.Now(); interview.Status(now) != InterviewOpen {
+			s.rejectOutsideWindow(conn, interview, now)
+			return
+		}
+		s.openScheduled(code)
+	}
+
+	// Keep a nil interface rather than a typed nil when no info was sent
 	var clientInfo interface{}
-	if len(msg.Payload) > 0 {
-		json.Unmarshal(msg.Payload, &clientInfo)
+	if payload.ClientInfo != nil {
+		clientInfo = payload.ClientInfo
 	}
 
+	// Hold new clients in the waiting room until a viewer admits them
+	if s.config.WaitingRoom && s.sessions[code] == nil && (s.pendingCodes[code] != nil || s.isRemoteCode(code)) {
+		s.holdInWaitingRoom(conn, code, clientInfo)
+		return
+	}
+
+	s.registerClient(conn, code, clientInfo)
+}
+
+// Pair a client with the session for its code, caller must hold s.mu
+func (s *Server) registerClient(conn *Connection, code string, clientInfo interface{}) {
 	// Check if this is a code that a viewer is waiting for
 	if pendingData, exists := s.pendingCodes[code]; exists {
-		viewerWS := pendingData.ViewerWS
-		log.Printf("✅ Found pending code %s with waiting viewer", code)
+		log.Printf("✅ Found pending code %s with %d waiting viewer(s)", code, len(pendingData.Viewers))
 
 		// Create a new session for this code
 		session := &Session{
-			Client: conn,
-			Viewer: viewerWS,
+			Client:  conn,
+			Viewers: pendingData.Viewers,
 			Info: &SessionInfo{
+				ID:         pendingData.ID,
 				CreatedAt:  time.Now(),
 				ClientInfo: clientInfo,
+				Subject:    pendingData.Subject,
+				Mode:       pendingData.Mode,
 			},
 		}
+		if session.Info.Mode == SFUMode {
+			session.mu.Lock()
+			s.startSFU(code, session)
+			session.mu.Unlock()
+		}
 
 		s.sessions[code] = session
 		delete(s.pendingCodes, code)
 		s.activeCodes[code] = true
+		s.forgetPending(code)
+		s.persistSession(code, session)
+
+		// Viewers waiting on other nodes promote their pending codes too
+		s.publishSessionEvent(code, clusterEvent{Kind: clusterClientJoined, ClientInfo: clientInfo})
 
 		conn.Role = ClientRole
 		conn.SessionCode = code
-		viewerWS.Role = ViewerRole
-		viewerWS.SessionCode = code
+		conn.SessionID = session.Info.ID
+		viewers := session.openViewers()
+		s.attempts.Reset(ipAttemptKey(conn.RemoteIP))
 
 		log.Printf("✅ Client registered with code: %s", code)
 
 		// Send immediate confirmation to client
-		timestampPayload := map[string]interface{}{
-			"timestamp": getCurrentTimestamp(),
-		}
-		response := createSimpleResponseMessage(SessionEstablished, timestampPayload)
+		response := createSimpleResponseMessage(SessionEstablished, SessionEstablishedPayload{
+			Timestamp:       getCurrentTimestamp(),
+			Viewers:         session.viewerIdentities(),
+			ProtocolVersion: conn.Protocol,
+			ICEServers:      s.iceServers(code),
+			Mode:            session.Info.Mode,
+		})
 		err := conn.Send(response)
 		if err != nil {
 			log.Printf("Error sending session establishment: %v", err)
 		}
+		if s.consent != nil {
+			s.requestConsent(conn)
+		}
 
 		log.Printf("📤 Session establishment sent to client for code: %s", code)
 
-		// Notify viewer with delay
+		// Notify viewers with delay
 		go func() {
-			time.Sleep(1500 * time.Millisecond)
-			if viewerWS.IsOpen() {
-				log.Printf("🔔 Notifying viewer that client connected for code: %s", code)
-				clientConnectedPayload := map[string]interface{}{
-					"timestamp":  getCurrentTimestamp(),
-					"code":       code,
-					"clientInfo": clientInfo,
+			time.Sleep(s.config.ClientConnectedDelay)
+			log.Printf("🔔 Notifying %d viewer(s) that client connected for code: %s", len(viewers), code)
+			viewerResponse := createSimpleResponseMessage(ClientConnected, ClientInfoPayload{
+				Timestamp:  getCurrentTimestamp(),
+				Code:       code,
+				ClientInfo: clientInfo,
+				ICEServers: s.iceServers(code),
+				Mode:       session.Info.Mode,
+			})
+			for _, viewer := range viewers {
+				if viewer.IsOpen() {
+					viewer.Send(viewerResponse)
 				}
-				viewerResponse := createSimpleResponseMessage(ClientConnected, clientConnectedPayload)
-				viewerWS.Send(viewerResponse)
 			}
 
-			// Tell client to start WebRTC
-			time.Sleep(1000 * time.Millisecond)
-			if conn.IsOpen() {
-				log.Printf("🔄 Sending connect signal to client for code: %s", code)
-				connectPayload := map[string]interface{}{
-					"timestamp": getCurrentTimestamp(),
-					"message":   "Start WebRTC connection",
-				}
-				connectResponse := createSimpleResponseMessage(Connect, connectPayload)
-				conn.Send(connectResponse)
-			}
+			// Tell client to start WebRTC, publishing to the SFU in sfu mode
+			time.Sleep(s.config.ConnectDelay)
+			s.sendConnect(conn, session, "Start WebRTC connection")
 		}()
 
 	} else if s.activeCodes[code] && s.sessions[code] != nil {
@@ -482,102 +917,200 @@ func (s *Server) handleClientRegister(conn *Connection, msg *Message) {
 		if session.Client == nil || !session.Client.IsOpen() {
 			// Update the client connection
 			session.Client = conn
+			session.clearHeld()
 			conn.Role = ClientRole
 			conn.SessionCode = code
+			conn.SessionID = session.id()
+			s.attempts.Reset(ipAttemptKey(conn.RemoteIP))
+			s.publishSessionEvent(code, clusterEvent{Kind: clusterClientJoined, ClientInfo: clientInfo, Reconnect: true})
+
+			// Sessions restored after a restart get their SFU back
+			mode := MeshMode
+			if session.Info != nil && session.Info.Mode != "" {
+				mode = session.Info.Mode
+			}
+			if mode == SFUMode {
+				s.startSFU(code, session)
+			}
 
 			log.Printf("✅ Client reconnected with code: %s", code)
-			reconnectPayload := map[string]interface{}{
-				"timestamp": getCurrentTimestamp(),
-				"reconnect": true,
-			}
-			response := createSimpleResponseMessage(SessionEstablished, reconnectPayload)
+			response := createSimpleResponseMessage(SessionEstablished, SessionEstablishedPayload{
+				Timestamp:       getCurrentTimestamp(),
+				Viewers:         session.viewerIdentities(),
+				Reconnect:       true,
+				ProtocolVersion: conn.Protocol,
+				ICEServers:      s.iceServers(code),
+				Mode:            mode,
+			})
 			conn.Send(response)
+			if s.consentNeeded(session) {
+				s.requestConsent(conn)
+			}
 
 			// Notify reconnection
-			if session.Viewer != nil && session.Viewer.IsOpen() {
-				reconnectedPayload := map[string]interface{}{
-					"timestamp": getCurrentTimestamp(),
-				}
-				viewerResponse := createSimpleResponseMessage(ClientReconnected, reconnectedPayload)
-				session.Viewer.Send(viewerResponse)
+			viewerResponse := createSimpleResponseMessage(ClientReconnected, ClientReconnectedPayload{
+				Timestamp: getCurrentTimestamp(),
+			})
+			for _, viewer := range session.openViewers() {
+				viewer.Send(viewerResponse)
 			}
 
 			// Tell client to start WebRTC after delay
 			go func() {
-				time.Sleep(1000 * time.Millisecond)
-				if conn.IsOpen() {
-					connectPayload := map[string]interface{}{
-						"message":   "Restart WebRTC connection",
-						"timestamp": getCurrentTimestamp(),
-					}
-					connectResponse := createSimpleResponseMessage(Connect, connectPayload)
-					conn.Send(connectResponse)
-				}
+				time.Sleep(s.config.ConnectDelay)
+				s.sendConnect(conn, session, "Restart WebRTC connection")
 			}()
 
 		} else if session.Client == conn {
 			// Same client reconnecting
 			log.Printf("✅ Client session refreshed for code: %s", code)
-			refreshPayload := map[string]interface{}{
-				"timestamp": getCurrentTimestamp(),
-				"refresh":   true,
-			}
-			response := createSimpleResponseMessage(SessionEstablished, refreshPayload)
+			response := createSimpleResponseMessage(SessionEstablished, SessionEstablishedPayload{
+				Timestamp:       getCurrentTimestamp(),
+				Viewers:         session.viewerIdentities(),
+				Refresh:         true,
+				ProtocolVersion: conn.Protocol,
+				ICEServers:      s.iceServers(code),
+			})
 			conn.Send(response)
 		} else {
 			// Different client trying to use same code
-			errorResponse := createSimpleResponseMessage(Error, map[string]string{
-				"message": "Session already has an active client",
-			})
-			conn.Send(errorResponse)
+			s.recordFailedRegistration(conn, code)
+			s.metrics.RegistrationFailed(ErrSessionConflict)
+			conn.Send(createErrorMessage(ErrSessionConflict, "Session already has an active client"))
 			go func() {
-				time.Sleep(500 * time.Millisecond)
+				time.Sleep(s.config.ErrorCloseDelay)
 				conn.Close()
 			}()
 		}
+	} else if s.isRemoteCode(code) {
+		// Viewers for this code are connected to another node
+		now := time.Now()
+		session := &Session{
+			Client:  conn,
+			Viewers: make(map[string]*Connection),
+			Info: &SessionInfo{
+				ID:         newSessionID(code, now),
+				CreatedAt:  now,
+				ClientInfo: clientInfo,
+			},
+		}
+		s.sessions[code] = session
+		s.activeCodes[code] = true
+		s.persistSession(code, session)
+		s.holdCode(code)
+
+		conn.Role = ClientRole
+		conn.SessionCode = code
+		conn.SessionID = session.Info.ID
+		s.attempts.Reset(ipAttemptKey(conn.RemoteIP))
+
+		log.Printf("✅ Client registered with code %s held by another node", code)
+
+		response := createSimpleResponseMessage(SessionEstablished, SessionEstablishedPayload{
+			Timestamp:       getCurrentTimestamp(),
+			Viewers:         session.viewerIdentities(),
+			ProtocolVersion: conn.Protocol,
+			ICEServers:      s.iceServers(code),
+		})
+		conn.Send(response)
+		if s.consent != nil {
+			s.requestConsent(conn)
+		}
+
+		s.publishSessionEvent(code, clusterEvent{Kind: clusterClientJoined, ClientInfo: clientInfo})
+
+		// Tell client to start WebRTC once remote viewers have been notified,
+		// by then they have also told us the session mode
+		go func() {
+			time.Sleep(s.config.ClientConnectedDelay + s.config.ConnectDelay)
+			s.sendConnect(conn, session, "Start WebRTC connection")
+		}()
+
 	} else {
 		// Invalid code
-		errorResponse := createSimpleResponseMessage(Error, map[string]string{
-			"message": "Invalid code or no viewer waiting for this code",
-		})
-		conn.Send(errorResponse)
+		s.recordFailedRegistration(conn, code)
+		s.metrics.RegistrationFailed(ErrInvalidCode)
+		conn.Send(createErrorMessage(ErrInvalidCode, "Invalid code or no viewer waiting for this code"))
 		go func() {
-			time.Sleep(500 * time.Millisecond)
+			time.Sleep(s.config.ErrorCloseDelay)
 			conn.Close()
 		}()
 	}
 }
 
+// Remaining lockout for a client registration, by IP or by code
+func (s *Server) registrationLockout(conn *Connection, code string) (time.Duration, bool) {
+	if wait, locked := s.attempts.Locked(ipAttemptKey(conn.RemoteIP)); locked {
+		return wait, true
+	}
+	return s.attempts.Locked(codeAttemptKey(code))
+}
+
+// Record a failed client registration against both the IP and the code
+func (s *Server) recordFailedRegistration(conn *Connection, code string) {
+	if lockout := s.attempts.Fail(ipAttemptKey(conn.RemoteIP)); lockout > 0 {
+		log.Printf("🔒 Locking out %s for %s after repeated failed registrations", conn.RemoteIP, lockout)
+	}
+	if lockout := s.attempts.Fail(codeAttemptKey(code)); lockout > 0 {
+		log.Printf("🔒 Locking out code %s for %s after repeated failed registrations", code, lockout)
+	}
+}
+
 // Handle viewer registration
-func (s *Server) handleViewerRegister(conn *Connection, msg *Message) {
+func (s *Server) handleViewerRegister(conn *Connection, msg *Message, registration *RegisterPayload) {
+	if !s.requireInterviewer(conn) {
+		return
+	}
+
 	code := msg.Code
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, hasPending := s.pendingCodes[code]; !hasPending && s.sessions[code] == nil {
-		s.pendingCodes[code] = &PendingCode{
-			CreatedAt: time.Now(),
-			ViewerWS:  conn,
+	// Only the panel may watch a scheduled interview
+	if interview := s.schedule.Lookup(code); interview != nil {
+		if !interview.Allows(conn.Subject) {
+			log.Printf("🚫 %s is not on the panel for interview %s", conn.Subject, code)
+			s.metrics.RegistrationFailed(ErrForbidden)
+			conn.Send(createErrorMessage(ErrForbidden, "Not on the panel for this interview"))
+			return
 		}
-		conn.SessionCode = code
-		conn.Role = ViewerRole
+		s.openScheduled(code)
+	}
+
+	// Join a session held by another node through a local view of it
+	if _, hasPending := s.pendingCodes[code]; !hasPending && s.sessions[code] == nil && s.isRemoteCode(code) {
+		now := time.Now()
+		s.sessions[code] = &Session{
+			Viewers: make(map[string]*Connection),
+			Info: &SessionInfo{
+				ID:        newSessionID(code, now),
+				CreatedAt: now,
+			},
+		}
+		s.activeCodes[code] = true
+		s.holdCode(code)
+	}
+
+	// Codes come from requestCode or the API, never from the viewer
+	if _, hasPending := s.pendingCodes[code]; !hasPending && s.sessions[code] == nil {
+		log.Printf("🚫 Viewer %s tried to register on unknown code %s", conn.ID, code)
+		s.metrics.RegistrationFailed(ErrInvalidCode)
+		conn.Send(createErrorMessage(ErrInvalidCode, "Unknown code, request a new one"))
 	} else if session := s.sessions[code]; session != nil {
 		session.mu.Lock()
 		defer session.mu.Unlock()
 
-		// Only allow one viewer per session
-		if session.Viewer != nil && session.Viewer != conn {
-			errorResponse := createSimpleResponseMessage(Error, map[string]string{
-				"message": "Session already has a viewer connected",
-			})
-			conn.Send(errorResponse)
-			return
+		if _, exists := session.Viewers[conn.ID]; !exists {
+			conn.PanelRole = assignPanelRole(registration.PanelRole, session.hasLead())
 		}
-
-		session.Viewer = conn
+		conn.Name = registration.Name
 		conn.SessionCode = code
+		conn.SessionID = session.id()
 		conn.Role = ViewerRole
+		session.Viewers[conn.ID] = conn
+
+		log.Printf("👀 Viewer %s joined session %s as %s", conn.ID, code, conn.PanelRole)
 
 		// Send current monitor info if available
 		if session.Info != nil && session.Info.MonitorInfo != nil {
@@ -585,30 +1118,78 @@ func (s *Server) handleViewerRegister(conn *Connection, msg *Message) {
 			conn.Send(monitorResponse)
 		}
 
+		// Send the process list later deltas build on
+		if session.processes != nil {
+			if snapshot := session.processes.Snapshot(); snapshot != nil {
+				conn.Send(createSimpleResponseMessage(ProcessInfo, snapshot))
+			}
+		}
+
+		// Send processes already flagged in this session
+		if alert := session.activeProcessAlert(code); alert != nil {
+			conn.Send(*alert)
+		}
+
+		// The SFU offers the client's tracks to the new viewer
+		if session.sfu != nil {
+			s.subscribeViewer(code, session, conn)
+		}
+
 		// Notify client if connected
 		if session.Client != nil && session.Client.IsOpen() {
 			log.Printf("🔔 Notifying client that viewer connected for code: %s", code)
-			viewerConnectedPayload := map[string]interface{}{
-				"timestamp": getCurrentTimestamp(),
-			}
-			clientResponse := createSimpleResponseMessage(ViewerConnected, viewerConnectedPayload)
+			clientResponse := createSimpleResponseMessage(ViewerConnected, ViewerConnectedPayload{
+				Timestamp: getCurrentTimestamp(),
+				Viewer:    conn.Identity(),
+				Viewers:   session.viewerIdentities(),
+			})
 			session.Client.Send(clientResponse)
 		}
+
+		// Nodes holding the client introduce it back to this viewer
+		identity := conn.Identity()
+		var mode SessionMode
+		if session.Info != nil {
+			mode = session.Info.Mode
+		}
+		s.publishSessionEvent(code, clusterEvent{Kind: clusterViewerJoined, Viewer: &identity, Mode: mode})
 	} else if pendingData := s.pendingCodes[code]; pendingData != nil {
-		pendingData.ViewerWS = conn
+		if _, exists := pendingData.Viewers[conn.ID]; !exists {
+			conn.PanelRole = assignPanelRole(registration.PanelRole, pendingData.hasLead())
+		}
+		conn.Name = registration.Name
 		conn.SessionCode = code
+		conn.SessionID = pendingData.ID
 		conn.Role = ViewerRole
+		pendingData.Viewers[conn.ID] = conn
+
+		// Catch up on a client already in the waiting room
+		if waiting := s.waiting[code]; waiting != nil {
+			conn.Send(waiting.notice(code))
+		}
 	}
 }
 
+// Resolve the panel role for a joining viewer, only one lead per session
+func assignPanelRole(requested PanelRole, hasLead bool) PanelRole {
+	if requested == "" || requested == LeadRole {
+		if !hasLead {
+			return LeadRole
+		}
+		return CoInterviewerRole
+	}
+	return requested
+}
+
 // Handle WebRTC signaling
-func (s *Server) handleSignal(conn *Connection, msg *Message) {
+func (s *Server) handleSignal(conn *Connection, msg *Message, payload *SignalPayload) {
+	received := time.Now()
 	code := msg.Code
-	
+
 	s.mu.RLock()
 	session := s.sessions[code]
 	s.mu.RUnlock()
-	
+
 	log.Printf("📡 Received signal message from %s for code: %s", conn.Role, code)
 
 	if session == nil {
@@ -619,243 +1200,319 @@ func (s *Server) handleSignal(conn *Connection, msg *Message) {
 	session.mu.RLock()
 	defer session.mu.RUnlock()
 
-	// Parse the payload to determine signal type for debugging
-	var payload interface{}
-	if len(msg.Payload) > 0 {
-		json.Unmarshal(msg.Payload, &payload)
-	}
-
-	if conn.Role == ClientRole && session.Viewer != nil && session.Viewer.IsOpen() {
-		log.Println("Forwarding signal from client to viewer")
-		
-		// Determine signal type for debugging
-		signalType := "Unknown"
-		if payloadMap, ok := payload.(map[string]interface{}); ok {
-			if t, exists := payloadMap["type"]; exists {
-				signalType = fmt.Sprintf("%v", t)
-			} else if _, exists := payloadMap["candidate"]; exists {
-				signalType = "ICE candidate"
+	signalType := payload.Kind()
+
+	// Nothing is negotiated with a local client until it consents
+	if (session.Client == conn || session.Viewers[conn.ID] == conn) && session.Client != nil && s.consentNeeded(session) {
+		log.Printf("✍️ Refused %s signal from %s %s for %s before the client consented", signalType, conn.Role, conn.ID, code)
+		conn.Send(createErrorMessage(ErrConsentRequired, "The candidate has not consented yet"))
+		return
+	}
+
+	if conn.Role == ClientRole && session.Client == conn {
+		// In sfu mode the client only talks to the SFU, unless it addresses a peer
+		sfu := session.sfu
+		toSFU := sfu != nil && (msg.Target == "" || msg.Target == sfu.ID)
+
+		// Route to the addressed viewer, or every viewer if none is named
+		var targets []*Connection
+		if msg.Target != "" {
+			if viewer := session.Viewers[msg.Target]; viewer != nil && viewer.IsOpen() {
+				targets = append(targets, viewer)
 			}
+		} else if !toSFU {
+			targets = session.openViewers()
 		}
-		log.Printf("Signal type being forwarded: %s", signalType)
 
-		// Add small delay to prevent signal races
-		go func() {
-			time.Sleep(50 * time.Millisecond)
-			if session.Viewer.IsOpen() {
-				response := createResponseMessage(Signal, payload)
-				session.Viewer.Send(response)
-			}
-		}()
+		// Viewers on other nodes get the signal through the backplane
+		_, targetIsRemote := session.remoteViewers[msg.Target]
+		relayRemote := len(session.remoteViewers) > 0 && (msg.Target == "" && !toSFU || targetIsRemote)
 
-	} else if conn.Role == ViewerRole && session.Client != nil && session.Client.IsOpen() {
-		log.Println("Forwarding signal from viewer to client")
-		
-		// Determine signal type for debugging
-		signalType := "Unknown"
-		if payloadMap, ok := payload.(map[string]interface{}); ok {
-			if t, exists := payloadMap["type"]; exists {
-				signalType = fmt.Sprintf("%v", t)
-			} else if _, exists := payloadMap["candidate"]; exists {
-				signalType = "ICE candidate"
-			}
+		recorder := session.recorder
+		toRecorder := recorder != nil && (msg.Target == "" || msg.Target == recorder.ID)
+
+		if len(targets) == 0 && !relayRemote && !toRecorder && !toSFU {
+			log.Printf("⚠️ Cannot relay signal: no viewer available for %s (target %q)", code, msg.Target)
+			return
 		}
-		log.Printf("Signal type being forwarded: %s", signalType)
 
-		// Add small delay to prevent signal races
-		go func() {
-			time.Sleep(50 * time.Millisecond)
-			if session.Client.IsOpen() {
-				response := createResponseMessage(Signal, payload)
-				session.Client.Send(response)
-			}
-		}()
+		log.Printf("Forwarding signal from client to %d local viewer(s), type: %s", len(targets), signalType)
+
+		response := createResponseMessage(Signal, payload)
+		if relayRemote {
+			s.relayRemote(code, ViewerRole, msg.Target, response)
+		}
+		if toRecorder {
+			recorder.Signal(msg.Payload)
+		}
+		if toSFU {
+			sfu.ClientSignal(msg.Payload)
+		}
+
+		// Per-connection queues keep offers and candidates in order
+		for _, viewer := range targets {
+			viewer.Send(response)
+		}
+
+		relayed := len(targets)
+		if relayRemote {
+			relayed++
+		}
+		if toRecorder {
+			relayed++
+		}
+		if toSFU {
+			relayed++
+		}
+		s.metrics.MessageRelayed(Signal, relayed)
+		s.metrics.ObserveSignalRelay(time.Since(received))
+
+	} else if conn.Role == ViewerRole && session.Viewers[conn.ID] == conn && session.sfu != nil {
+		// Viewers negotiate with the SFU, not the client
+		session.sfu.ViewerSignal(conn.ID, msg.Payload)
+		s.metrics.MessageRelayed(Signal, 1)
+		s.metrics.ObserveSignalRelay(time.Since(received))
+
+	} else if conn.Role == ViewerRole && session.Viewers[conn.ID] == conn && session.hasClient() {
+		log.Printf("Forwarding signal from viewer %s to client, type: %s", conn.ID, signalType)
+
+		response := createResponseMessage(Signal, payload)
+		response.From = conn.ID
+
+		client := session.Client
+		if client == nil || !client.IsOpen() {
+			s.relayRemote(code, ClientRole, "", response)
+		} else {
+			client.Send(response)
+		}
+
+		s.metrics.MessageRelayed(Signal, 1)
+		s.metrics.ObserveSignalRelay(time.Since(received))
 
 	} else {
 		log.Printf("⚠️ Cannot relay signal: session state issue for %s", code)
-		log.Printf("Role: %s, Session exists: %t, Client connected: %t, Viewer connected: %t",
-			conn.Role, session != nil, session != nil && session.Client != nil && session.Client.IsOpen(),
-			session != nil && session.Viewer != nil && session.Viewer.IsOpen())
+		log.Printf("Role: %s, Client connected: %t, Viewers connected: %d",
+			conn.Role, session.hasClient(), len(session.openViewers())+len(session.remoteViewers))
 	}
 }
 
+// Send a message to the session's client, locally or through the backplane
+func (s *Server) sendToClient(code string, session *Session, response ResponseMessage) bool {
+	session.mu.RLock()
+	client := session.Client
+	remote := session.remoteClient
+	session.mu.RUnlock()
+
+	if client != nil && client.IsOpen() {
+		client.Send(response)
+		s.metrics.MessageRelayed(response.Type, 1)
+		return true
+	}
+	if remote {
+		s.relayRemote(code, ClientRole, "", response)
+		s.metrics.MessageRelayed(response.Type, 1)
+		return true
+	}
+	return false
+}
+
+// Send a message to every viewer of the session, locally and through the backplane
+func (s *Server) sendToViewers(code string, session *Session, response ResponseMessage) {
+	session.mu.RLock()
+	viewers := session.openViewers()
+	remote := len(session.remoteViewers) > 0
+	session.mu.RUnlock()
+
+	for _, viewer := range viewers {
+		viewer.Send(response)
+	}
+	relayed := len(viewers)
+	if remote {
+		s.relayRemote(code, ViewerRole, "", response)
+		relayed++
+	}
+	s.metrics.MessageRelayed(response.Type, relayed)
+}
+
 // Handle connect message
 func (s *Server) handleConnect(conn *Connection, msg *Message) {
 	code := msg.Code
-	
+
 	s.mu.RLock()
 	session := s.sessions[code]
 	s.mu.RUnlock()
 
 	if conn.Role == ViewerRole && session != nil {
 		session.mu.RLock()
-		client := session.Client
+		if session.Viewers[conn.ID] != conn {
+			session.mu.RUnlock()
+			log.Printf("🚫 Ignoring connect from %s, not a viewer of %s", conn.ID, code)
+			conn.Send(createErrorMessage(ErrForbidden, "Not a viewer of this session"))
+			return
+		}
+
+		// In sfu mode a viewer asking to connect gets a fresh offer from the SFU
+		if session.sfu != nil {
+			log.Printf("🔄 Restarting SFU stream for viewer %s in %s", conn.ID, code)
+			s.subscribeViewer(code, session, conn)
+			session.mu.RUnlock()
+			return
+		}
 		session.mu.RUnlock()
 
-		if client != nil {
-			log.Printf("🔄 Forwarding connect request to client for code: %s", code)
-			if client.IsOpen() {
-				response := createSimpleResponseMessage(Connect, nil)
-				client.Send(response)
-			} else {
-				log.Printf("⚠️ Client for code %s not connected or ready", code)
-			}
+		log.Printf("🔄 Forwarding connect request from viewer %s to client for code: %s", conn.ID, code)
+		response := createSimpleResponseMessage(Connect, nil)
+		response.From = conn.ID
+		if !s.connectClient(code, session, response) {
+			log.Printf("⚠️ Client for code %s not connected or ready", code)
 		}
 	}
 }
 
 // Handle display configuration change
-func (s *Server) handleDisplayConfigChanged(conn *Connection, msg *Message) {
+func (s *Server) handleDisplayConfigChanged(conn *Connection, msg *Message, payload *DisplayConfigChangedPayload) {
 	code := msg.Code
-	
+
 	s.mu.RLock()
 	session := s.sessions[code]
 	s.mu.RUnlock()
 
 	if conn.Role == ClientRole && session != nil {
-		session.mu.RLock()
-		viewer := session.Viewer
-		session.mu.RUnlock()
-
-		if viewer != nil && viewer.IsOpen() {
-			// Parse payload
-			var payload interface{}
-			if len(msg.Payload) > 0 {
-				json.Unmarshal(msg.Payload, &payload)
-			}
-			
-			response := createSimpleResponseMessage(DisplayConfigChanged, payload)
-			viewer.Send(response)
+		if s.holdForConsent(session, DisplayConfigChanged, func() { s.handleDisplayConfigChanged(conn, msg, payload) }) {
+			return
 		}
+		response := createSimpleResponseMessage(DisplayConfigChanged, payload)
+		s.sendToViewers(code, session, response)
 	}
 }
 
 // Handle monitor info update
-func (s *Server) handleMonitorInfo(conn *Connection, msg *Message) {
+func (s *Server) handleMonitorInfo(conn *Connection, msg *Message, payload *MonitorInfoPayload) {
 	code := msg.Code
-	
+
 	s.mu.RLock()
 	session := s.sessions[code]
 	s.mu.RUnlock()
 
 	if conn.Role == ClientRole && session != nil {
-		log.Printf("📊 Received monitor info from client for code: %s", code)
-		
-		// Parse payload
-		var payload interface{}
-		if len(msg.Payload) > 0 {
-			json.Unmarshal(msg.Payload, &payload)
+		if s.holdForConsent(session, MonitorInfo, func() { s.handleMonitorInfo(conn, msg, payload) }) {
+			return
 		}
-		
+		log.Printf("📊 Received monitor info from client for code: %s", code)
+
 		session.mu.Lock()
 		if session.Info != nil {
 			session.Info.MonitorInfo = payload
+			session.dirty = true
 		}
-		viewer := session.Viewer
 		session.mu.Unlock()
 
-		if viewer != nil && viewer.IsOpen() {
-			response := createSimpleResponseMessage(MonitorInfo, payload)
-			viewer.Send(response)
-		}
+		response := createSimpleResponseMessage(MonitorInfo, payload)
+		s.sendToViewers(code, session, response)
+
+		s.detectDisplayChanges(code, session, payload)
 	}
 }
 
 // Handle process info update
-func (s *Server) handleProcessInfo(conn *Connection, msg *Message) {
+func (s *Server) handleProcessInfo(conn *Connection, msg *Message, payload *ProcessInfoPayload) {
 	code := msg.Code
-	
+
 	s.mu.RLock()
 	session := s.sessions[code]
 	s.mu.RUnlock()
 
 	if conn.Role == ClientRole && session != nil {
-		// Parse payload
-		var payload interface{}
-		if len(msg.Payload) > 0 {
-			json.Unmarshal(msg.Payload, &payload)
-		}
-		
-		session.mu.Lock()
-		if session.Info != nil {
-			session.Info.ProcessInfo = payload
-		}
-		viewer := session.Viewer
-		session.mu.Unlock()
-
-		if viewer != nil && viewer.IsOpen() {
-			response := createSimpleResponseMessage(ProcessInfo, payload)
-			viewer.Send(response)
+		if s.holdForConsent(session, ProcessInfo, func() { s.handleProcessInfo(conn, msg, payload) }) {
+			return
 		}
+		s.sendProcessInfo(code, session, payload, true)
+		s.detectProcesses(code, session, payload)
 	}
 }
 
 // Handle admin command
-func (s *Server) handleAdminCommand(conn *Connection, msg *Message) {
-	if conn.Role != ViewerRole {
+func (s *Server) handleAdminCommand(conn *Connection, msg *Message, payload *AdminCommandPayload) {
+	if conn.Role != ViewerRole || !s.requireInterviewer(conn) {
+		return
+	}
+
+	if !conn.PanelRole.CanAdmin() {
+		conn.Send(createErrorMessage(ErrForbidden, "Observers cannot issue admin commands"))
 		return
 	}
 
 	code := msg.Code
-	
+	if !s.onPanel(conn, code) {
+		log.Printf("🚫 %s sent an admin command for %s without being on its panel", conn.ID, code)
+		conn.Send(createErrorMessage(ErrForbidden, "Not on the panel for this session"))
+		return
+	}
+
 	s.mu.RLock()
 	session := s.sessions[code]
 	s.mu.RUnlock()
 
-	// Parse payload
-	var payload map[string]interface{}
-	if len(msg.Payload) > 0 {
-		json.Unmarshal(msg.Payload, &payload)
-	}
+	command := payload.Command
+	log.Printf("Received admin command: %v from %s (%s) for code: %s", command, conn.ID, conn.PanelRole, code)
 
-	if command, exists := payload["command"]; exists {
-		log.Printf("Received admin command: %v for code: %s", command, code)
+	adminResponse := createSimpleResponseMessage(AdminCommand, payload)
+	adminResponse.From = conn.ID
 
-		if command == "disconnect" {
-			if session != nil {
-				session.mu.RLock()
-				client := session.Client
-				session.mu.RUnlock()
+	if command == "startRecording" || command == "stopRecording" {
+		s.handleRecordingCommand(conn, code, session, command)
 
-				if client != nil && client.IsOpen() {
-					log.Printf("🔌 Sending disconnect command to client for code: %s", code)
-					adminResponse := createSimpleResponseMessage(AdminCommand, payload)
-					client.Send(adminResponse)
-				}
-			}
+	} else if command == AdmitCommand || command == RejectCommand {
+		s.handleWaitingRoomCommand(conn, code, payload)
 
-			// Notify viewer that disconnect request was processed
-			responsePayload := map[string]interface{}{
-				"command": "disconnect",
-				"success": true,
-				"message": "Disconnect request sent to client",
-			}
-			response := createSimpleResponseMessage(AdminCommandResponse, responsePayload)
-			conn.Send(response)
+	} else if command == "disconnect" {
+		if session != nil {
+			log.Printf("🔌 Sending disconnect command to client for code: %s", code)
+			s.sendToClient(code, session, adminResponse)
+		}
 
-		} else if session != nil {
-			session.mu.RLock()
-			client := session.Client
-			session.mu.RUnlock()
+		// Notify viewer that disconnect request was processed
+		response := createSimpleResponseMessage(AdminCommandResponse, AdminCommandResponsePayload{
+			Command: "disconnect",
+			Success: true,
+			Message: "Disconnect request sent to client",
+		})
+		conn.Send(response)
 
-			if client != nil && client.IsOpen() {
-				// Forward other commands to client
-				adminResponse := createSimpleResponseMessage(AdminCommand, payload)
-				client.Send(adminResponse)
-			} else {
-				errorResponse := createSimpleResponseMessage(Error, map[string]string{
-					"message": "Client not connected",
-				})
-				conn.Send(errorResponse)
-			}
+	} else if session != nil {
+		// Forward other commands to client
+		if !s.sendToClient(code, session, adminResponse) {
+			conn.Send(createErrorMessage(ErrNotConnected, "Client not connected"))
 		}
 	}
 }
 
+// Check if a viewer joined the session or pending code on this node
+func (s *Server) onPanel(conn *Connection, code string) bool {
+	if conn.Role != ViewerRole || conn.SessionCode != code {
+		return false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if session := s.sessions[code]; session != nil {
+		session.mu.RLock()
+		defer session.mu.RUnlock()
+		return session.Viewers[conn.ID] == conn
+	}
+	pending := s.pendingCodes[code]
+	return pending != nil && pending.Viewers[conn.ID] == conn
+}
+
 // Handle connection close
 func (s *Server) handleConnectionClose(conn *Connection) {
 	log.Printf("🔌 WebSocket closed: %s", conn.ID)
 
+	// Keep sessions, stored state and held codes for peers resuming after a restart
+	if s.isDraining() {
+		return
+	}
+
 	// Clean up session if this connection was part of one
 	sessionCode := conn.SessionCode
 	if sessionCode != "" {
@@ -870,46 +1527,77 @@ func (s *Server) handleConnectionClose(conn *Connection) {
 			if conn.Role == ClientRole && session.Client == conn {
 				session.Client = nil
 				log.Printf("🔌 Client disconnected from session %s", sessionCode)
+				s.auditEvent(conn, ClientDisconnected, AuditEvent{})
 
-				// Notify viewer if present
-				if session.Viewer != nil && session.Viewer.IsOpen() {
-					disconnectedPayload := map[string]interface{}{
-						"timestamp": getCurrentTimestamp(),
-						"code":      sessionCode,
-					}
-					response := createSimpleResponseMessage(ClientDisconnected, disconnectedPayload)
-					session.Viewer.Send(response)
+				// Notify viewers if present
+				response := createSimpleResponseMessage(ClientDisconnected, ClientDisconnectedPayload{
+					Timestamp: getCurrentTimestamp(),
+					Code:      sessionCode,
+				})
+				for _, viewer := range session.openViewers() {
+					viewer.Send(response)
 				}
+				s.publishSessionEvent(sessionCode, clusterEvent{Kind: clusterClientLeft})
 
-			} else if conn.Role == ViewerRole && session.Viewer == conn {
-				session.Viewer = nil
-				log.Printf("🔌 Viewer disconnected from session %s", sessionCode)
+			} else if conn.Role == ViewerRole && session.Viewers[conn.ID] == conn {
+				delete(session.Viewers, conn.ID)
+				if session.sfu != nil {
+					session.sfu.Unsubscribe(conn.ID)
+				}
+				log.Printf("🔌 Viewer %s (%s) disconnected from session %s", conn.ID, conn.PanelRole, sessionCode)
+				s.auditEvent(conn, ViewerDisconnected, AuditEvent{})
 
 				// Notify client if present
 				if session.Client != nil && session.Client.IsOpen() {
-					disconnectedPayload := map[string]interface{}{
-						"timestamp": getCurrentTimestamp(),
-						"code":      sessionCode,
-					}
-					response := createSimpleResponseMessage(ViewerDisconnected, disconnectedPayload)
+					response := createSimpleResponseMessage(ViewerDisconnected, ViewerDisconnectedPayload{
+						Timestamp: getCurrentTimestamp(),
+						Code:      sessionCode,
+						Viewer:    conn.Identity(),
+						Viewers:   session.viewerIdentities(),
+					})
 					session.Client.Send(response)
 				}
+
+				identity := conn.Identity()
+				s.publishSessionEvent(sessionCode, clusterEvent{Kind: clusterViewerLeft, Viewer: &identity})
 			}
 
-			// Clean up session if both client and viewer are gone
-			if session.Client == nil && session.Viewer == nil {
+			// Clean up session if client and all viewers are gone
+			if session.Client == nil && len(session.Viewers) == 0 {
+				if session.recorder != nil {
+					session.recorder.Close()
+					session.recorder = nil
+				}
+				if session.detector != nil {
+					session.detector.stop()
+					session.detector = nil
+				}
+				if session.sfu != nil {
+					session.sfu.Close()
+					session.sfu = nil
+				}
 				s.mu.Lock()
 				delete(s.sessions, sessionCode)
-				delete(s.activeCodes, sessionCode)
+				s.retireCode(sessionCode)
 				s.mu.Unlock()
+				s.forgetSession(sessionCode)
 				log.Printf("🧹 Cleaned up empty session %s", sessionCode)
 			}
 		}
 
 		// Check if this was a pending code that never got claimed
 		s.mu.Lock()
-		if pendingData := s.pendingCodes[sessionCode]; pendingData != nil && pendingData.ViewerWS == conn {
-			delete(s.pendingCodes, sessionCode)
+		if pendingData := s.pendingCodes[sessionCode]; pendingData != nil && pendingData.Viewers[conn.ID] == conn {
+			delete(pendingData.Viewers, conn.ID)
+			if len(pendingData.Viewers) == 0 {
+				s.closeWaitingRoom(sessionCode, "The interviewer left")
+				delete(s.pendingCodes, sessionCode)
+				s.forgetPending(sessionCode)
+				s.retireCode(sessionCode)
+			}
+		}
+		if conn.Role == ClientRole {
+			s.leaveWaitingRoom(sessionCode, conn)
 		}
 		s.mu.Unlock()
 	}
@@ -917,38 +1605,180 @@ func (s *Server) handleConnectionClose(conn *Connection) {
 
 // Start server
 func (s *Server) Start() {
-	// Load .env file
-	err := godotenv.Load()
+	// Configure per-message rate limits
+	var err error
+	s.messageRates, err = parseMessageRates(s.config.MessageRateLimits)
 	if err != nil {
-		log.Printf("Warning: Error loading .env file: %v", err)
-		log.Println("Using environment variables or defaults")
+		log.Fatal("Invalid rate limits:", err)
 	}
 
-	// Initialize random seed
-	rand.Seed(time.Now().UnixNano())
+	// Configure which origins may open a WebSocket
+	s.origins, err = NewOriginPolicy(s.config.AllowedOrigins, s.config.ElectronOrigins)
+	if err != nil {
+		log.Fatal("Invalid origin configuration:", err)
+	}
+	if s.origins.AllowsAnyOrigin() {
+		log.Printf("⚠️  Accepting WebSocket upgrades from any origin, set ALLOWED_ORIGINS to restrict")
+	}
 
-	// Start cleanup routine
-	s.startCleanupRoutine()
+	// Configure join code format
+	s.codeFormat, err = NewCodeFormat(s.config)
+	if err != nil {
+		log.Fatal("Invalid code configuration:", err)
+	}
+	log.Printf("🎲 Join codes: %d characters, alphanumeric: %t", s.codeFormat.Length, s.codeFormat.IsAlphanumeric())
+
+	// Set up interviewer authentication
+	s.auth, err = NewAuthenticator(s.config)
+	if err != nil {
+		log.Fatal("Failed to set up authentication:", err)
+	}
+
+	// Join the cluster backplane before restoring codes so they are announced
+	s.backplane, err = NewBackplane(s.config)
+	if err != nil {
+		log.Fatal("Failed to connect backplane:", err)
+	}
+	if err := s.startCluster(); err != nil {
+		log.Fatal("Failed to join cluster:", err)
+	}
+
+	// Open session store and restore state from before a restart
+	s.store, err = NewSessionStore(s.config)
+	if err != nil {
+		log.Fatal("Failed to open session store:", err)
+	}
+	if err := s.restoreFromStore(); err != nil {
+		log.Fatal("Failed to restore sessions:", err)
+	}
 
-	// Get port from environment or use default
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3004"
+	// Configure server-side recording
+	s.recording, err = NewRecordingConfig(s.config)
+	if err != nil {
+		log.Fatal("Invalid recording configuration:", err)
+	}
+	if s.recording.Enabled {
+		log.Printf("🎥 Session recording enabled, writing to %s", s.recording.Dir)
 	}
 
-	// Setup HTTP handler
+	// Load suspicious process rules
+	s.processRules, err = LoadProcessRuleSet(s.config.ProcessRulesFile)
+	if err != nil {
+		log.Fatal("Invalid process rules:", err)
+	}
+	log.Printf("🔍 Loaded %d process detection rules", s.processRules.Len())
+
+	// Load the consent text candidates acknowledge before monitoring starts
+	s.consent, err = LoadConsentPolicy(s.config.ConsentFile, s.config.ConsentVersion)
+	if err != nil {
+		log.Fatal("Invalid consent configuration:", err)
+	}
+	if s.consent != nil {
+		log.Printf("✍️ Requiring consent to version %s before sessions start", s.consent.Version)
+	}
+
+	// Open the per-session audit log
+	log.Printf("📝 Audit log writing to %s", s.audit.dir)
+
+	// Configure native TLS
+	s.tls, err = NewTLSConfig(s.config)
+	if err != nil {
+		log.Fatal("Invalid TLS configuration:", err)
+	}
+
+	// Start the embedded TURN server for peers behind restrictive networks
+	turnConfig, err := NewTURNConfig(s.config)
+	if err != nil {
+		log.Fatal("Invalid TURN configuration:", err)
+	}
+	if turnConfig.Enabled() {
+		s.relay, err = StartTURNServer(turnConfig)
+		if err != nil {
+			log.Fatal("Failed to start TURN server:", err)
+		}
+		log.Printf("🧭 TURN server relaying via %s on port %d (UDP and TCP)", turnConfig.PublicIP, turnConfig.Port)
+	}
+
+	// Start cleanup and persistence routines
+	s.startCleanupRoutine()
+	s.startStoreFlushRoutine()
+
+	port := s.config.Port
+
+	// Setup HTTP handlers
+	http.HandleFunc("/auth/login", s.handleLogin)
+	http.HandleFunc("/recordings/", s.handleRecordings)
+	http.HandleFunc("/audit/", s.handleAuditTimeline)
+	http.HandleFunc("/protocol/schema.json", s.handleSchema)
+	http.HandleFunc("/api/sessions", s.handleAPISessions)
+	http.HandleFunc("/api/sessions/", s.handleAPISession)
+	http.HandleFunc("/api/codes", s.handleAPICodes)
+	http.HandleFunc("/api/interviews", s.handleAPIInterviews)
+	http.HandleFunc("/api/interviews/", s.handleAPIInterview)
+	http.HandleFunc("/metrics", s.handleMetrics)
 	http.HandleFunc("/", s.handleConnection)
 
-	log.Printf("🚀 Signaling server running at ws://localhost:%s", port)
+	// Drain connections on SIGTERM instead of dropping them
+	done := make(chan struct{})
+	s.handleShutdownSignals(done)
 
 	// Start server
-	err = http.ListenAndServe(":"+port, nil)
-	if err != nil {
+	s.httpServer = &http.Server{Addr: ":" + port}
+	if s.tls.Enabled() {
+		s.httpServer.TLSConfig, err = s.buildTLSConfig()
+		if err != nil {
+			log.Fatal("Failed to set up TLS:", err)
+		}
+		if s.tls.RedirectPort != "" {
+			s.startRedirectServer(port)
+		}
+		log.Printf("🔐 TLS enabled, client certificates: %s", s.tls.ClientAuth)
+		log.Printf("🚀 Signaling server running at wss://localhost:%s", port)
+		err = s.httpServer.ListenAndServeTLS("", "")
+	} else {
+		log.Printf("🚀 Signaling server running at ws://localhost:%s", port)
+		err = s.httpServer.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatal("Server failed to start:", err)
 	}
+	<-done
 }
 
 func main() {
-	server := NewServer()
+	if len(os.Args) > 1 && os.Args[1] == "adduser" {
+		godotenv.Load()
+		runAddUser(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		runSchema(os.Args[2:])
+		return
+	}
+
+	// Load .env file
+	err := godotenv.Load()
+	if err != nil {
+		log.Printf("Warning: Error loading .env file: %v", err)
+		log.Println("Using environment variables or defaults")
+	}
+
+	config, printOnly, err := LoadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	if printOnly {
+		data, err := config.YAML()
+		if err != nil {
+			log.Fatal("Failed to print configuration:", err)
+		}
+		os.Stdout.Write(data)
+		return
+	}
+
+	server := NewServer(config)
 	server.Start()
 }
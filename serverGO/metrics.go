@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix for every exported metric
+const METRICS_NAMESPACE = "interview"
+
+// Cleanup eviction kinds
+const (
+	EvictExpiredPending   = "expired_pending"
+	EvictAbandonedSession = "abandoned_session"
+	EvictAttemptRecord    = "attempt_record"
+	EvictEndedInterview   = "ended_interview"
+)
+
+// Upper bounds of the signal relay latency buckets, in seconds
+var signalRelayBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1}
+
+// Cumulative histogram in the Prometheus layout
+type Histogram struct {
+	bounds []float64
+	counts []uint64 // per bucket, the last slot is +Inf
+	sum    float64
+	count  uint64
+}
+
+// Create a histogram with the given bucket upper bounds
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+// Record one value
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.bounds, value)
+	h.counts[i]++
+	h.sum += value
+	h.count++
+}
+
+// Process-wide counters, gauges are read from the server at scrape time
+type Metrics struct {
+	received      map[MessageType]uint64
+	relayed       map[MessageType]uint64
+	registrations map[ErrorCode]uint64 // failures by reason
+	evictions     map[string]uint64
+	rateLimited   map[MessageType]uint64
+	oversized     uint64
+	origins       map[string]uint64 // rejected upgrades by reason
+	signalRelay   *Histogram
+	mu            sync.Mutex
+}
+
+// Create an empty metrics registry
+func NewMetrics() *Metrics {
+	return &Metrics{
+		received:      make(map[MessageType]uint64),
+		relayed:       make(map[MessageType]uint64),
+		registrations: make(map[ErrorCode]uint64),
+		evictions:     make(map[string]uint64),
+		rateLimited:   make(map[MessageType]uint64),
+		origins:       make(map[string]uint64),
+		signalRelay:   NewHistogram(signalRelayBuckets),
+	}
+}
+
+// Count a valid message read from a peer
+func (m *Metrics) MessageReceived(msgType MessageType) {
+	m.mu.Lock()
+	m.received[msgType]++
+	m.mu.Unlock()
+}
+
+// Count a message forwarded to n local peers or backplane relays
+func (m *Metrics) MessageRelayed(msgType MessageType, n int) {
+	if n <= 0 {
+		return
+	}
+	m.mu.Lock()
+	m.relayed[msgType] += uint64(n)
+	m.mu.Unlock()
+}
+
+// Count a rejected registration
+func (m *Metrics) RegistrationFailed(reason ErrorCode) {
+	m.mu.Lock()
+	m.registrations[reason]++
+	m.mu.Unlock()
+}
+
+// Count entries removed by the cleanup routine
+func (m *Metrics) Evicted(kind string, n int) {
+	if n <= 0 {
+		return
+	}
+	m.mu.Lock()
+	m.evictions[kind] += uint64(n)
+	m.mu.Unlock()
+}
+
+// Count a message dropped by a rate limit
+func (m *Metrics) RateLimited(msgType MessageType) {
+	if _, known := clientPayloads[msgType]; !known {
+		// Peers choose the type, keep label values bounded
+		msgType = "unknown"
+	}
+	m.mu.Lock()
+	m.rateLimited[msgType]++
+	m.mu.Unlock()
+}
+
+// Count a message refused for exceeding the size limit
+func (m *Metrics) MessageTooLarge() {
+	m.mu.Lock()
+	m.oversized++
+	m.mu.Unlock()
+}
+
+// Count a WebSocket upgrade refused by the origin policy
+func (m *Metrics) OriginRejected(reason string) {
+	m.mu.Lock()
+	m.origins[reason]++
+	m.mu.Unlock()
+}
+
+// Record how long a signal took to hand to its recipients
+func (m *Metrics) ObserveSignalRelay(d time.Duration) {
+	m.mu.Lock()
+	m.signalRelay.Observe(d.Seconds())
+	m.mu.Unlock()
+}
+
+// Writes the Prometheus text exposition format
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (mw *metricsWriter) header(name, kind, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s_%s %s\n", METRICS_NAMESPACE, name, help)
+	fmt.Fprintf(mw.w, "# TYPE %s_%s %s\n", METRICS_NAMESPACE, name, kind)
+}
+
+func (mw *metricsWriter) sample(name, label, value string, v float64) {
+	if label == "" {
+		fmt.Fprintf(mw.w, "%s_%s %s\n", METRICS_NAMESPACE, name, formatMetricValue(v))
+		return
+	}
+	fmt.Fprintf(mw.w, "%s_%s{%s=\"%s\"} %s\n", METRICS_NAMESPACE, name, label, labelEscaper.Replace(value), formatMetricValue(v))
+}
+
+// One sample per label value, in sorted order so scrapes diff cleanly
+func (mw *metricsWriter) labeled(name, label string, values map[string]float64) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		mw.sample(name, label, key, values[key])
+	}
+}
+
+func (mw *metricsWriter) histogram(name string, h *Histogram) {
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		mw.sample(name+"_bucket", "le", formatMetricValue(bound), float64(cumulative))
+	}
+	mw.sample(name+"_bucket", "le", "+Inf", float64(h.count))
+	mw.sample(name+"_sum", "", "", h.sum)
+	mw.sample(name+"_count", "", "", float64(h.count))
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func countsByType(counts map[MessageType]uint64) map[string]float64 {
+	values := make(map[string]float64, len(counts))
+	for key, n := range counts {
+		values[string(key)] = float64(n)
+	}
+	return values
+}
+
+// Handle GET /metrics
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Gauges come straight from the live maps
+	s.mu.RLock()
+	sessions := len(s.sessions)
+	pending := len(s.pendingCodes)
+	connections := map[string]float64{
+		string(ClientRole): 0,
+		string(ViewerRole): 0,
+		"unregistered":     0,
+	}
+	for _, conn := range s.connections {
+		role := string(conn.Role)
+		if role == "" {
+			role = "unregistered"
+		}
+		connections[role]++
+	}
+	s.mu.RUnlock()
+
+	m := s.metrics
+	m.mu.Lock()
+	received := countsByType(m.received)
+	relayed := countsByType(m.relayed)
+	rateLimited := countsByType(m.rateLimited)
+	oversized := m.oversized
+	registrations := make(map[string]float64, len(m.registrations))
+	for reason, n := range m.registrations {
+		registrations[string(reason)] = float64(n)
+	}
+	evictions := make(map[string]float64, len(m.evictions))
+	for kind, n := range m.evictions {
+		evictions[kind] = float64(n)
+	}
+	origins := make(map[string]float64, len(m.origins))
+	for reason, n := range m.origins {
+		origins[reason] = float64(n)
+	}
+	signalRelay := &Histogram{
+		bounds: m.signalRelay.bounds,
+		counts: append([]uint64(nil), m.signalRelay.counts...),
+		sum:    m.signalRelay.sum,
+		count:  m.signalRelay.count,
+	}
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	mw := &metricsWriter{w: bufio.NewWriter(w)}
+	defer mw.w.Flush()
+
+	mw.header("sessions_active", "gauge", "Sessions known to this node.")
+	mw.sample("sessions_active", "", "", float64(sessions))
+
+	mw.header("pending_codes", "gauge", "Codes handed out that no client has claimed yet.")
+	mw.sample("pending_codes", "", "", float64(pending))
+
+	if s.relay != nil {
+		mw.header("turn_allocations", "gauge", "Live relay allocations on the embedded TURN server.")
+		mw.sample("turn_allocations", "", "", float64(s.relay.Allocations()))
+	}
+
+	mw.header("connections", "gauge", "Open WebSocket connections by role.")
+	mw.labeled("connections", "role", connections)
+
+	mw.header("messages_received_total", "counter", "Valid messages received from peers by type.")
+	mw.labeled("messages_received_total", "type", received)
+
+	mw.header("messages_relayed_total", "counter", "Messages forwarded to local peers or the backplane by type.")
+	mw.labeled("messages_relayed_total", "type", relayed)
+
+	mw.header("messages_rate_limited_total", "counter", "Messages dropped by per-connection rate limits by type.")
+	mw.labeled("messages_rate_limited_total", "type", rateLimited)
+
+	mw.header("messages_oversized_total", "counter", "Messages refused for exceeding the size limit.")
+	mw.sample("messages_oversized_total", "", "", float64(oversized))
+
+	mw.header("registration_failures_total", "counter", "Rejected register and requestCode attempts by reason.")
+	mw.labeled("registration_failures_total", "reason", registrations)
+
+	mw.header("origin_rejections_total", "counter", "WebSocket upgrades refused by the origin policy by reason.")
+	mw.labeled("origin_rejections_total", "reason", origins)
+
+	mw.header("cleanup_evictions_total", "counter", "Entries removed by the periodic cleanup by kind.")
+	mw.labeled("cleanup_evictions_total", "kind", evictions)
+
+	mw.header("signal_relay_duration_seconds", "histogram", "Time from receiving a signal to handing it to every recipient.")
+	mw.histogram("signal_relay_duration_seconds", signalRelay)
+}
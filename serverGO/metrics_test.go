@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Server with an in-memory store and no audit log on disk
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	s := NewServer(DefaultConfig())
+	s.audit = nil
+	return s
+}
+
+// Scrape /metrics and return the sample lines
+func scrapeMetrics(t *testing.T, s *Server) []string {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	s.handleMetrics(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != 200 {
+		t.Fatalf("metrics returned %d", recorder.Code)
+	}
+	if got := recorder.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Fatalf("content type %q", got)
+	}
+	return strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+}
+
+// Check every wanted line appears in the scrape
+func expectSamples(t *testing.T, lines []string, want ...string) {
+	t.Helper()
+	present := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		present[line] = true
+	}
+	for _, line := range want {
+		if !present[line] {
+			t.Errorf("missing %q in scrape:\n%s", line, strings.Join(lines, "\n"))
+		}
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	h := NewHistogram([]float64{1, 2, 5})
+	for _, value := range []float64{0.5, 1, 1.5, 5, 7} {
+		h.Observe(value)
+	}
+
+	// A value equal to a bound falls in that bucket, larger ones in +Inf
+	if want := []uint64{2, 1, 1, 1}; !reflect.DeepEqual(h.counts, want) {
+		t.Fatalf("counts %v, want %v", h.counts, want)
+	}
+	if h.count != 5 || h.sum != 15 {
+		t.Fatalf("count %d sum %g, want 5 and 15", h.count, h.sum)
+	}
+}
+
+func TestMetricsExposition(t *testing.T) {
+	s := newTestServer(t)
+	s.metrics.MessageReceived(Signal)
+	s.metrics.MessageReceived(Signal)
+	s.metrics.MessageReceived(MonitorInfo)
+	s.metrics.MessageRelayed(Signal, 3)
+	s.metrics.MessageRelayed(Signal, 0)
+	s.metrics.RateLimited("madeUp")
+	s.metrics.MessageTooLarge()
+	s.metrics.OriginRejected(`bad "origin"`)
+	s.metrics.ObserveSignalRelay(200 * time.Microsecond)
+	s.metrics.ObserveSignalRelay(2 * time.Millisecond)
+	s.metrics.ObserveSignalRelay(2 * time.Second)
+
+	lines := scrapeMetrics(t, s)
+	expectSamples(t, lines,
+		"# HELP interview_messages_received_total Valid messages received from peers by type.",
+		"# TYPE interview_messages_received_total counter",
+		`interview_messages_received_total{type="monitorInfo"} 1`,
+		`interview_messages_received_total{type="signal"} 2`,
+		`interview_messages_relayed_total{type="signal"} 3`,
+		`interview_messages_rate_limited_total{type="unknown"} 1`,
+		"interview_messages_oversized_total 1",
+		`interview_origin_rejections_total{reason="bad \"origin\""} 1`,
+		"interview_sessions_active 0",
+		`interview_connections{role="unregistered"} 0`,
+		"# TYPE interview_signal_relay_duration_seconds histogram",
+		`interview_signal_relay_duration_seconds_bucket{le="0.0001"} 0`,
+		`interview_signal_relay_duration_seconds_bucket{le="0.00025"} 1`,
+		`interview_signal_relay_duration_seconds_bucket{le="0.0025"} 2`,
+		`interview_signal_relay_duration_seconds_bucket{le="1"} 2`,
+		`interview_signal_relay_duration_seconds_bucket{le="+Inf"} 3`,
+		"interview_signal_relay_duration_seconds_count 3",
+	)
+
+	// Label values come out sorted so scrapes diff cleanly
+	var received []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "interview_messages_received_total{") {
+			received = append(received, line)
+		}
+	}
+	if len(received) != 2 || received[0] > received[1] {
+		t.Fatalf("received samples out of order: %v", received)
+	}
+}
+
+func TestProcessMessageCounts(t *testing.T) {
+	s := newTestServer(t)
+	conn := newConnection("conn-1", nil)
+
+	// Rejected registrations are counted by reason, not as received
+	s.processMessage(conn, &Message{Type: Register, Role: ClientRole})
+	s.processMessage(conn, &Message{Type: Register, Code: "123456", Role: "admin"})
+	s.processMessage(conn, &Message{Type: Connect, Code: "123456"})
+	s.processMessage(conn, &Message{Type: Signal, Code: "123456", Payload: json.RawMessage(`"not an object"`)})
+
+	s.metrics.mu.Lock()
+	defer s.metrics.mu.Unlock()
+	if got := s.metrics.registrations[ErrInvalidMessage]; got != 2 {
+		t.Errorf("registration failures %d, want 2", got)
+	}
+	if want := map[MessageType]uint64{Connect: 1}; !reflect.DeepEqual(s.metrics.received, want) {
+		t.Errorf("received %v, want %v", s.metrics.received, want)
+	}
+}
+
+func TestCleanupExpiredCodesCountsEvictions(t *testing.T) {
+	s := newTestServer(t)
+	old := time.Now().Add(-2 * s.config.PendingCodeTTL)
+
+	s.pendingCodes["111111"] = &PendingCode{CreatedAt: old, Viewers: make(map[string]*Connection)}
+	s.pendingCodes["222222"] = &PendingCode{CreatedAt: time.Now(), Viewers: make(map[string]*Connection)}
+	s.sessions["333333"] = &Session{Viewers: make(map[string]*Connection), restoredAt: old}
+	s.sessions["444444"] = &Session{Viewers: make(map[string]*Connection), restoredAt: time.Now()}
+	for _, code := range []string{"111111", "222222", "333333", "444444"} {
+		s.activeCodes[code] = true
+	}
+
+	s.cleanupExpiredCodes()
+
+	if s.pendingCodes["111111"] != nil || s.pendingCodes["222222"] == nil {
+		t.Errorf("pending codes after cleanup: %v", s.pendingCodes)
+	}
+	if s.sessions["333333"] != nil || s.sessions["444444"] == nil {
+		t.Errorf("sessions after cleanup: %v", s.sessions)
+	}
+	expectSamples(t, scrapeMetrics(t, s),
+		`interview_cleanup_evictions_total{kind="abandoned_session"} 1`,
+		`interview_cleanup_evictions_total{kind="expired_pending"} 1`,
+		"interview_pending_codes 1",
+		"interview_sessions_active 1",
+	)
+}
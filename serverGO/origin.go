@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// How upgrades from the Electron client are treated. It loads its UI from
+// disk, so it sends no Origin, "null" or a file:// origin.
+const (
+	ElectronOriginsAllow     = "allow"     // accept them from anyone
+	ElectronOriginsAnonymous = "anonymous" // accept them only without interviewer credentials
+	ElectronOriginsDeny      = "deny"      // refuse them
+)
+
+// Origin rejection reasons, used as metric labels
+const (
+	OriginNotAllowed     = "not_allowed"
+	OriginElectronPolicy = "electron_policy"
+	OriginMalformed      = "malformed"
+)
+
+// One allowlist entry, like https://app.example.com or *.example.com
+type originPattern struct {
+	scheme   string // empty matches http and https
+	host     string // without the wildcard label
+	port     string
+	wildcard bool // matches subdomains of host, not host itself
+}
+
+// Parse an allowlist entry
+func parseOriginPattern(raw string) (originPattern, error) {
+	var pattern originPattern
+	rest := strings.ToLower(strings.TrimSpace(raw))
+
+	if scheme, host, ok := strings.Cut(rest, "://"); ok {
+		if scheme != "http" && scheme != "https" {
+			return pattern, fmt.Errorf("origin %q must use http or https", raw)
+		}
+		pattern.scheme = scheme
+		rest = host
+	}
+	rest = strings.TrimSuffix(rest, "/")
+
+	if strings.HasPrefix(rest, "*.") {
+		pattern.wildcard = true
+		rest = strings.TrimPrefix(rest, "*.")
+	}
+	if host, port, ok := strings.Cut(rest, ":"); ok {
+		rest, pattern.port = host, port
+	}
+	if rest == "" || strings.ContainsAny(rest, "*/") {
+		return pattern, fmt.Errorf("invalid origin %q", raw)
+	}
+	pattern.host = rest
+	return pattern, nil
+}
+
+func (p originPattern) matches(origin *url.URL) bool {
+	if p.scheme != "" && origin.Scheme != p.scheme {
+		return false
+	}
+	if p.scheme == "" && origin.Scheme != "http" && origin.Scheme != "https" {
+		return false
+	}
+	if origin.Port() != p.port {
+		return false
+	}
+
+	host := strings.ToLower(origin.Hostname())
+	if p.wildcard {
+		return strings.HasSuffix(host, "."+p.host)
+	}
+	return host == p.host
+}
+
+// Which browser origins may open a WebSocket to the server
+type OriginPolicy struct {
+	patterns []originPattern
+	anyWeb   bool // no allowlist, or an explicit *
+	electron string
+}
+
+// Build a policy from a comma separated allowlist and an Electron policy
+func NewOriginPolicy(allowlist, electron string) (*OriginPolicy, error) {
+	policy := &OriginPolicy{electron: electron}
+	if policy.electron == "" {
+		policy.electron = ElectronOriginsAllow
+	}
+	switch policy.electron {
+	case ElectronOriginsAllow, ElectronOriginsAnonymous, ElectronOriginsDeny:
+	default:
+		return nil, fmt.Errorf("invalid electron origin policy %q (want allow, anonymous or deny)", electron)
+	}
+
+	for _, entry := range strings.Split(allowlist, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			policy.anyWeb = true
+			continue
+		}
+		pattern, err := parseOriginPattern(entry)
+		if err != nil {
+			return nil, err
+		}
+		policy.patterns = append(policy.patterns, pattern)
+	}
+	if len(policy.patterns) == 0 {
+		policy.anyWeb = true
+	}
+	return policy, nil
+}
+
+// Whether every web origin is accepted
+func (p *OriginPolicy) AllowsAnyOrigin() bool {
+	return p.anyWeb
+}
+
+// Origins an app loaded from disk sends
+func isLocalOrigin(origin string) bool {
+	return origin == "" || origin == "null" || strings.HasPrefix(strings.ToLower(origin), "file://")
+}
+
+// Decide on an upgrade, returning the rejection reason if refused
+func (p *OriginPolicy) Check(origin string, authenticated bool) (bool, string) {
+	if isLocalOrigin(origin) {
+		switch p.electron {
+		case ElectronOriginsAllow:
+			return true, ""
+		case ElectronOriginsAnonymous:
+			if !authenticated {
+				return true, ""
+			}
+		}
+		return false, OriginElectronPolicy
+	}
+
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host == "" {
+		return false, OriginMalformed
+	}
+	if p.anyWeb {
+		return true, ""
+	}
+	for _, pattern := range p.patterns {
+		if pattern.matches(parsed) {
+			return true, ""
+		}
+	}
+	return false, OriginNotAllowed
+}
+
+// Upgrader CheckOrigin hook, logging and counting rejections
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	allowed, reason := s.origins.Check(origin, hasCredentials(r))
+	if !allowed {
+		log.Printf("🚫 Rejected upgrade from %s with origin %q (%s)", s.clientIP(r), origin, reason)
+		s.metrics.OriginRejected(reason)
+	}
+	return allowed
+}
+
+// Let allowed browser origins call an HTTP endpoint, like the web viewer
+// logging in. Returns false once a preflight request has been answered.
+func (s *Server) allowCORS(w http.ResponseWriter, r *http.Request, methods string) bool {
+	if origin := r.Header.Get("Origin"); !isLocalOrigin(origin) {
+		w.Header().Add("Vary", "Origin")
+		if allowed, _ := s.origins.Check(origin, false); allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		}
+	}
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseOriginPattern(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want originPattern
+	}{
+		{"https://App.Example.com/", originPattern{scheme: "https", host: "app.example.com"}},
+		{"example.com", originPattern{host: "example.com"}},
+		{"http://localhost:3000", originPattern{scheme: "http", host: "localhost", port: "3000"}},
+		{"*.example.com", originPattern{host: "example.com", wildcard: true}},
+		{" https://*.example.com:8443 ", originPattern{scheme: "https", host: "example.com", port: "8443", wildcard: true}},
+	}
+	for _, c := range cases {
+		got, err := parseOriginPattern(c.raw)
+		if err != nil {
+			t.Errorf("%q: %v", c.raw, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%q parsed as %+v, want %+v", c.raw, got, c.want)
+		}
+	}
+
+	for _, raw := range []string{"", "ftp://example.com", "*", "*.", "a.*.example.com", "example.com/path", "https://"} {
+		if _, err := parseOriginPattern(raw); err == nil {
+			t.Errorf("%q parsed without error", raw)
+		}
+	}
+}
+
+func TestOriginPatternMatches(t *testing.T) {
+	cases := []struct {
+		pattern string
+		origin  string
+		want    bool
+	}{
+		{"*.example.com", "https://app.example.com", true},
+		{"*.example.com", "http://a.b.example.com", true},
+		{"*.example.com", "https://APP.Example.COM", true},
+		{"*.example.com", "https://example.com", false},
+		{"*.example.com", "https://evilexample.com", false},
+		{"*.example.com", "https://example.com.evil.io", false},
+		{"*.example.com", "https://app.example.com:8443", false},
+		{"*.example.com", "wss://app.example.com", false},
+		{"https://*.example.com", "http://app.example.com", false},
+		{"https://app.example.com", "https://app.example.com", true},
+		{"https://app.example.com", "https://other.example.com", false},
+		{"http://localhost:3000", "http://localhost:3000", true},
+		{"http://localhost:3000", "http://localhost:3001", false},
+		{"http://localhost:3000", "http://localhost", false},
+	}
+	for _, c := range cases {
+		pattern, err := parseOriginPattern(c.pattern)
+		if err != nil {
+			t.Fatalf("%q: %v", c.pattern, err)
+		}
+		origin, err := url.Parse(c.origin)
+		if err != nil {
+			t.Fatalf("%q: %v", c.origin, err)
+		}
+		if got := pattern.matches(origin); got != c.want {
+			t.Errorf("%q matching %q = %t, want %t", c.pattern, c.origin, got, c.want)
+		}
+	}
+}
+
+func TestOriginPolicyCheck(t *testing.T) {
+	policy, err := NewOriginPolicy("https://app.example.com, *.example.org", ElectronOriginsAnonymous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.AllowsAnyOrigin() {
+		t.Fatal("allowlist policy accepts any origin")
+	}
+
+	cases := []struct {
+		origin        string
+		authenticated bool
+		allowed       bool
+		reason        string
+	}{
+		{"https://app.example.com", true, true, ""},
+		{"https://viewer.example.org", false, true, ""},
+		{"https://evil.example.net", false, false, OriginNotAllowed},
+		{"not a url", false, false, OriginMalformed},
+		{"", false, true, ""},
+		{"null", true, false, OriginElectronPolicy},
+		{"file://", false, true, ""},
+	}
+	for _, c := range cases {
+		allowed, reason := policy.Check(c.origin, c.authenticated)
+		if allowed != c.allowed || reason != c.reason {
+			t.Errorf("%q (authenticated %t) = %t %q, want %t %q", c.origin, c.authenticated, allowed, reason, c.allowed, c.reason)
+		}
+	}
+
+	open, err := NewOriginPolicy("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !open.AllowsAnyOrigin() {
+		t.Fatal("empty allowlist does not accept any origin")
+	}
+	if allowed, _ := open.Check("https://anywhere.io", true); !allowed {
+		t.Fatal("open policy refused a web origin")
+	}
+
+	deny, _ := NewOriginPolicy("*", ElectronOriginsDeny)
+	if allowed, reason := deny.Check("", false); allowed || reason != OriginElectronPolicy {
+		t.Fatalf("deny policy accepted a local origin: %t %q", allowed, reason)
+	}
+	if _, err := NewOriginPolicy("", "sometimes"); err == nil {
+		t.Fatal("accepted an unknown electron policy")
+	}
+}
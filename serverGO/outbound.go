@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Outbound queue limits
+const (
+	OUTBOUND_QUEUE_SIZE       = 256              // depth at which monitor updates start being shed
+	OUTBOUND_QUEUE_HARD_LIMIT = 1024             // depth at which the consumer is disconnected
+	OUTBOUND_WRITE_WAIT       = 10 * time.Second // deadline for writing a single message
+)
+
+// Backpressure counters for a connection's outbound queue
+type OutboundStats struct {
+	Queued    int    `json:"queued"`
+	HighWater int    `json:"highWater"`
+	Sent      uint64 `json:"sent"`
+	Dropped   uint64 `json:"dropped"`
+	Coalesced uint64 `json:"coalesced"`
+}
+
+// Message types that only carry the latest state and may be shed for slow consumers
+func isSheddable(msgType MessageType) bool {
+	return msgType == MonitorInfo || msgType == ProcessInfo
+}
+
+// Message type of an outbound message, empty if it is not a ResponseMessage
+func outboundType(message interface{}) MessageType {
+	switch m := message.(type) {
+	case ResponseMessage:
+		return m.Type
+	case *ResponseMessage:
+		return m.Type
+	}
+	return ""
+}
+
+// Queue a message for the writer goroutine. Messages are written in FIFO
+// order; once the queue is backed up, monitor updates replace an older queued
+// update of the same type or are dropped, while signaling is always queued
+// until the hard limit, at which point the consumer is disconnected.
+func (c *Connection) Send(message interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.WS == nil {
+		return fmt.Errorf("connection is nil")
+	}
+
+	msgType := outboundType(message)
+	if len(c.queue) >= OUTBOUND_QUEUE_SIZE && isSheddable(msgType) {
+		for i := len(c.queue) - 1; i >= 0; i-- {
+			if outboundType(c.queue[i]) == msgType {
+				c.queue[i] = message
+				c.stats.Coalesced++
+				return nil
+			}
+		}
+		c.stats.Dropped++
+		if c.stats.Dropped == 1 || c.stats.Dropped%100 == 0 {
+			log.Printf("🐢 Slow consumer %s: dropped %d %s update(s), %d queued", c.ID, c.stats.Dropped, msgType, len(c.queue))
+		}
+		return nil
+	}
+
+	if len(c.queue) >= OUTBOUND_QUEUE_HARD_LIMIT {
+		log.Printf("🐢 Slow consumer %s exceeded %d queued messages, disconnecting", c.ID, OUTBOUND_QUEUE_HARD_LIMIT)
+		c.WS.Close()
+		c.WS = nil
+		c.queue = nil
+		return fmt.Errorf("outbound queue full")
+	}
+
+	c.queue = append(c.queue, message)
+	if len(c.queue) > c.stats.HighWater {
+		c.stats.HighWater = len(c.queue)
+	}
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Snapshot of the outbound queue counters
+func (c *Connection) OutboundStats() OutboundStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Queued = len(c.queue)
+	return stats
+}
+
+// Drain the outbound queue on a single goroutine until the connection closes
+func (c *Connection) writeLoop() {
+	for range c.wake {
+		for {
+			c.mu.Lock()
+			ws := c.WS
+			if ws == nil || len(c.queue) == 0 {
+				c.mu.Unlock()
+				break
+			}
+			message := c.queue[0]
+			c.queue[0] = nil
+			c.queue = c.queue[1:]
+			c.mu.Unlock()
+
+			ws.SetWriteDeadline(time.Now().Add(OUTBOUND_WRITE_WAIT))
+			if err := ws.WriteJSON(message); err != nil {
+				log.Printf("Error writing to %s: %v", c.ID, err)
+				c.Close()
+				break
+			}
+
+			c.mu.Lock()
+			c.stats.Sent++
+			c.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+)
+
+// Process diffing settings
+const (
+	PROCESS_DELTA_PROTOCOL = 3    // first protocol version that receives processDelta
+	PROCESS_CPU_DELTA      = 5.0  // CPU change, in percentage points, reported as a change
+	PROCESS_MEMORY_DELTA   = 0.10 // relative memory change reported as a change
+)
+
+// A process is the same process while both its ID and name match
+type processKey struct {
+	id   int64
+	name string
+}
+
+func keyOf(process ReportedProcess) processKey {
+	return processKey{id: process.ID, name: process.ProcessName}
+}
+
+// Process list as viewers know it, built from consecutive client snapshots.
+// Small CPU and memory moves are not reported, so the known entry of a
+// process can lag the client's last report until it moves far enough.
+type ProcessTracker struct {
+	known    map[processKey]ReportedProcess
+	sequence uint64 // bumped for every delta that changed something
+	seeded   bool
+}
+
+// Create an empty tracker
+func NewProcessTracker() *ProcessTracker {
+	return &ProcessTracker{known: make(map[processKey]ReportedProcess)}
+}
+
+// Diff a new snapshot against the known list and apply it, nil if nothing changed
+func (t *ProcessTracker) Update(processes []ReportedProcess) *ProcessDeltaPayload {
+	delta := &ProcessDeltaPayload{}
+	seen := make(map[processKey]bool, len(processes))
+
+	for _, process := range processes {
+		key := keyOf(process)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		previous, exists := t.known[key]
+		switch {
+		case !exists:
+			delta.Started = append(delta.Started, process)
+			t.known[key] = process
+		case processChanged(previous, process):
+			delta.Changed = append(delta.Changed, process)
+			t.known[key] = process
+		}
+	}
+	for key, process := range t.known {
+		if !seen[key] {
+			delta.Exited = append(delta.Exited, ProcessRef{ID: process.ID, ProcessName: process.ProcessName})
+			delete(t.known, key)
+		}
+	}
+
+	first := !t.seeded
+	t.seeded = true
+	if len(delta.Started) == 0 && len(delta.Exited) == 0 && len(delta.Changed) == 0 && !first {
+		return nil
+	}
+
+	t.sequence++
+	sortProcesses(delta.Started)
+	sortProcesses(delta.Changed)
+	sort.Slice(delta.Exited, func(i, j int) bool { return delta.Exited[i].ID < delta.Exited[j].ID })
+	delta.Sequence = t.sequence
+	delta.Count = len(t.known)
+	return delta
+}
+
+// Full known list, nil before the first snapshot
+func (t *ProcessTracker) Snapshot() *ProcessInfoPayload {
+	if !t.seeded {
+		return nil
+	}
+	processes := make([]ReportedProcess, 0, len(t.known))
+	for _, process := range t.known {
+		processes = append(processes, process)
+	}
+	sortProcesses(processes)
+	return &ProcessInfoPayload{
+		Processes: processes,
+		Timestamp: getCurrentTimestamp(),
+		Sequence:  t.sequence,
+	}
+}
+
+func sortProcesses(processes []ReportedProcess) {
+	sort.Slice(processes, func(i, j int) bool { return processes[i].ID < processes[j].ID })
+}
+
+// Check if a process moved enough to report
+func processChanged(before, after ReportedProcess) bool {
+	if before.WindowTitle != after.WindowTitle || before.Path != after.Path {
+		return true
+	}
+	if (before.CPU == nil) != (after.CPU == nil) || (before.Memory == nil) != (after.Memory == nil) {
+		return true
+	}
+	if before.CPU != nil && math.Abs(*after.CPU-*before.CPU) >= PROCESS_CPU_DELTA {
+		return true
+	}
+	if before.Memory != nil {
+		if *before.Memory == 0 {
+			return *after.Memory != 0
+		}
+		return math.Abs(*after.Memory-*before.Memory)/math.Abs(*before.Memory) >= PROCESS_MEMORY_DELTA
+	}
+	return false
+}
+
+// Update the session's process list and send viewers on this node a delta,
+// or the full list if they predate processDelta. relay also forwards the
+// full list to other nodes, which diff it for their own viewers.
+func (s *Server) sendProcessInfo(code string, session *Session, payload *ProcessInfoPayload, relay bool) {
+	session.mu.Lock()
+	if session.Info != nil {
+		session.Info.ProcessInfo = payload
+		session.dirty = true
+	}
+	if session.processes == nil {
+		session.processes = NewProcessTracker()
+	}
+	delta := session.processes.Update(payload.Processes)
+	viewers := session.openViewers()
+	remote := relay && len(session.remoteViewers) > 0
+	session.mu.Unlock()
+
+	if delta != nil {
+		delta.Timestamp = getCurrentTimestamp()
+		delta.Code = code
+	}
+	var full, diff int
+	for _, viewer := range viewers {
+		if viewer.Protocol < PROCESS_DELTA_PROTOCOL {
+			viewer.Send(createSimpleResponseMessage(ProcessInfo, payload))
+			full++
+		} else if delta != nil {
+			viewer.Send(createSimpleResponseMessage(ProcessDelta, delta))
+			diff++
+		}
+	}
+	if remote {
+		s.relayRemote(code, ViewerRole, "", createSimpleResponseMessage(ProcessInfo, payload))
+		full++
+	}
+	s.metrics.MessageRelayed(ProcessInfo, full)
+	s.metrics.MessageRelayed(ProcessDelta, diff)
+}
+
+// Handle a viewer asking for the full process list, to start over after
+// missing a delta
+func (s *Server) handleProcessSnapshot(conn *Connection, msg *Message) {
+	code := msg.Code
+
+	s.mu.RLock()
+	session := s.sessions[code]
+	s.mu.RUnlock()
+	if conn.Role != ViewerRole || session == nil {
+		conn.Send(createErrorMessage(ErrNotConnected, "Session not found"))
+		return
+	}
+
+	session.mu.RLock()
+	var snapshot *ProcessInfoPayload
+	if session.Viewers[conn.ID] == conn && session.processes != nil {
+		snapshot = session.processes.Snapshot()
+	}
+	session.mu.RUnlock()
+
+	if snapshot == nil {
+		conn.Send(createErrorMessage(ErrNotConnected, "No process list has been reported yet"))
+		return
+	}
+	log.Printf("📋 Sending process snapshot %d of %s to viewer %s", snapshot.Sequence, code, conn.ID)
+	conn.Send(createSimpleResponseMessage(ProcessInfo, snapshot))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func process(id int64, name string, cpu, memory float64) ReportedProcess {
+	return ReportedProcess{ID: id, ProcessName: name, CPU: floatPtr(cpu), Memory: floatPtr(memory)}
+}
+
+func TestProcessTrackerDeltas(t *testing.T) {
+	tracker := NewProcessTracker()
+	if tracker.Snapshot() != nil {
+		t.Fatal("snapshot before the first report")
+	}
+
+	// The first report is always sent, even when it is empty
+	delta := tracker.Update([]ReportedProcess{})
+	if delta == nil || delta.Sequence != 1 || delta.Count != 0 {
+		t.Fatalf("first delta %+v", delta)
+	}
+	if delta := tracker.Update(nil); delta != nil {
+		t.Fatalf("unchanged list produced %+v", delta)
+	}
+
+	delta = tracker.Update([]ReportedProcess{
+		process(20, "zoom.exe", 10, 100),
+		process(10, "code.exe", 1, 500),
+		process(20, "zoom.exe", 90, 900), // duplicate entries count once
+	})
+	if delta == nil || delta.Sequence != 2 || delta.Count != 2 {
+		t.Fatalf("start delta %+v", delta)
+	}
+	if got := []int64{delta.Started[0].ID, delta.Started[1].ID}; !reflect.DeepEqual(got, []int64{10, 20}) {
+		t.Fatalf("started %v, want sorted by ID", got)
+	}
+	if *delta.Started[1].CPU != 10 {
+		t.Fatalf("kept the duplicate instead of the first entry: %+v", delta.Started[1])
+	}
+
+	// Small moves are not reported and do not reset the baseline
+	if delta := tracker.Update([]ReportedProcess{process(20, "zoom.exe", 13, 105), process(10, "code.exe", 1, 500)}); delta != nil {
+		t.Fatalf("small move produced %+v", delta)
+	}
+	delta = tracker.Update([]ReportedProcess{process(20, "zoom.exe", 15, 105), process(10, "code.exe", 1, 500)})
+	if delta == nil || len(delta.Changed) != 1 || delta.Changed[0].ID != 20 || delta.Sequence != 3 {
+		t.Fatalf("accumulated move gave %+v", delta)
+	}
+
+	// A reused PID under another name is a different process
+	delta = tracker.Update([]ReportedProcess{process(20, "teams.exe", 15, 105)})
+	if delta == nil {
+		t.Fatal("no delta for exits")
+	}
+	wantExited := []ProcessRef{{ID: 10, ProcessName: "code.exe"}, {ID: 20, ProcessName: "zoom.exe"}}
+	if !reflect.DeepEqual(delta.Exited, wantExited) {
+		t.Fatalf("exited %+v, want %+v", delta.Exited, wantExited)
+	}
+	if len(delta.Started) != 1 || delta.Started[0].ProcessName != "teams.exe" || delta.Count != 1 {
+		t.Fatalf("restart delta %+v", delta)
+	}
+
+	snapshot := tracker.Snapshot()
+	if snapshot.Sequence != delta.Sequence || len(snapshot.Processes) != 1 || snapshot.Processes[0].ProcessName != "teams.exe" {
+		t.Fatalf("snapshot %+v", snapshot)
+	}
+}
+
+func TestProcessChanged(t *testing.T) {
+	base := process(1, "a.exe", 10, 1000)
+	cases := []struct {
+		name  string
+		after ReportedProcess
+		want  bool
+	}{
+		{"same", process(1, "a.exe", 10, 1000), false},
+		{"cpu under threshold", process(1, "a.exe", 14.9, 1000), false},
+		{"cpu at threshold", process(1, "a.exe", 5, 1000), true},
+		{"memory under threshold", process(1, "a.exe", 10, 1099), false},
+		{"memory at threshold", process(1, "a.exe", 10, 900), true},
+		{"title", ReportedProcess{ID: 1, ProcessName: "a.exe", WindowTitle: "Exam", CPU: floatPtr(10), Memory: floatPtr(1000)}, true},
+		{"path", ReportedProcess{ID: 1, ProcessName: "a.exe", Path: `C:\a.exe`, CPU: floatPtr(10), Memory: floatPtr(1000)}, true},
+		{"cpu dropped", ReportedProcess{ID: 1, ProcessName: "a.exe", Memory: floatPtr(1000)}, true},
+	}
+	for _, c := range cases {
+		if got := processChanged(base, c.after); got != c.want {
+			t.Errorf("%s: changed = %t, want %t", c.name, got, c.want)
+		}
+	}
+
+	idle := process(1, "a.exe", 0, 0)
+	if processChanged(idle, process(1, "a.exe", 0, 0)) {
+		t.Error("zero memory reported as a change")
+	}
+	if !processChanged(idle, process(1, "a.exe", 0, 1)) {
+		t.Error("memory growth from zero not reported")
+	}
+}
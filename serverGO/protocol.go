@@ -0,0 +1,619 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Protocol versions
+const (
+	PROTOCOL_VERSION       = 3    // version spoken by this server
+	MIN_PROTOCOL_VERSION   = 1    // oldest version still accepted, peers that send none speak 1
+	MAX_NAME_LENGTH        = 64   // longest viewer display name
+	MAX_REPORTED_PROCESSES = 1000 // longest process list accepted from a client
+	MAX_REPORTED_DISPLAYS  = 64   // longest display list accepted from a client
+	MAX_CLIENT_ERROR       = 1024 // longest error text a client may pass on to viewers
+)
+
+// Machine-readable error codes carried by Error messages
+type ErrorCode string
+
+const (
+	ErrInvalidMessage      ErrorCode = "invalid_message"
+	ErrUnknownMessageType  ErrorCode = "unknown_message_type"
+	ErrInvalidPayload      ErrorCode = "invalid_payload"
+	ErrUnsupportedProtocol ErrorCode = "unsupported_protocol"
+	ErrUnauthenticated     ErrorCode = "unauthenticated"
+	ErrForbidden           ErrorCode = "forbidden"
+	ErrInvalidCode         ErrorCode = "invalid_code"
+	ErrSessionConflict     ErrorCode = "session_conflict"
+	ErrLockedOut           ErrorCode = "locked_out"
+	ErrNotConnected        ErrorCode = "not_connected"
+	ErrRateLimited         ErrorCode = "rate_limited"
+	ErrMessageTooLarge     ErrorCode = "message_too_large"
+	ErrOutsideWindow       ErrorCode = "outside_window"
+	ErrRejected            ErrorCode = "rejected"
+	ErrConsentRequired     ErrorCode = "consent_required"
+	ErrInternal            ErrorCode = "internal"
+)
+
+// All error codes, in the order they are documented in the schema
+var errorCodes = []ErrorCode{
+	ErrInvalidMessage, ErrUnknownMessageType, ErrInvalidPayload, ErrUnsupportedProtocol,
+	ErrUnauthenticated, ErrForbidden, ErrInvalidCode, ErrSessionConflict, ErrLockedOut,
+	ErrNotConnected, ErrRateLimited, ErrMessageTooLarge, ErrOutsideWindow, ErrRejected,
+	ErrConsentRequired, ErrInternal,
+}
+
+// Error sent back to a peer, Field names the offending payload field if any
+type ProtocolError struct {
+	Code    ErrorCode
+	Message string
+	Field   string
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s: %s (%s)", e.Code, e.Message, e.Field)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Payload validation failure for a single field
+func invalidField(field, format string, args ...interface{}) *ProtocolError {
+	return &ProtocolError{
+		Code:    ErrInvalidPayload,
+		Message: fmt.Sprintf(format, args...),
+		Field:   field,
+	}
+}
+
+// Error message payload
+type ErrorPayload struct {
+	Code       ErrorCode `json:"code"`
+	Message    string    `json:"message"`
+	Field      string    `json:"field,omitempty"`
+	RetryAfter int       `json:"retryAfter,omitempty"` // seconds, set with locked_out, rate_limited and outside_window
+}
+
+// Create a structured error message
+func createErrorMessage(code ErrorCode, message string) ResponseMessage {
+	return createSimpleResponseMessage(Error, ErrorPayload{
+		Code:    code,
+		Message: message,
+	})
+}
+
+// Create an error message from a decode or validation failure
+func createProtocolErrorMessage(err error) ResponseMessage {
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		return createErrorMessage(ErrInternal, "Internal server error")
+	}
+	return createSimpleResponseMessage(Error, ErrorPayload{
+		Code:    protocolErr.Code,
+		Message: protocolErr.Message,
+		Field:   protocolErr.Field,
+	})
+}
+
+// Pick the version to speak with a peer that supports up to requested
+func negotiateProtocol(requested int) (int, error) {
+	if requested == 0 {
+		return MIN_PROTOCOL_VERSION, nil
+	}
+	if requested < MIN_PROTOCOL_VERSION {
+		return 0, &ProtocolError{
+			Code:    ErrUnsupportedProtocol,
+			Message: fmt.Sprintf("Protocol version %d is no longer supported, minimum is %d", requested, MIN_PROTOCOL_VERSION),
+			Field:   "protocolVersion",
+		}
+	}
+	if requested > PROTOCOL_VERSION {
+		return PROTOCOL_VERSION, nil
+	}
+	return requested, nil
+}
+
+// Payload received from a peer
+type ClientPayload interface {
+	Validate() error
+}
+
+// Request code payload sent by a lead interviewer
+type RequestCodePayload struct {
+	ProtocolVersion int         `json:"protocolVersion,omitempty"`
+	Mode            SessionMode `json:"mode,omitempty"` // defaults to the server's session mode
+}
+
+func (p *RequestCodePayload) Validate() error {
+	if p.Mode != "" && !p.Mode.IsValid() {
+		return invalidField("mode", "Mode must be mesh or sfu")
+	}
+	return nil
+}
+
+// Details a client sends about itself when registering
+type ClientInfo struct {
+	Timestamp   int64               `json:"timestamp,omitempty"`
+	DisplayInfo *MonitorInfoPayload `json:"displayInfo,omitempty"`
+	UserAgent   string              `json:"userAgent,omitempty"`
+	Platform    string              `json:"platform,omitempty"`
+}
+
+// Register payload, clients send clientInfo and viewers their panel details
+type RegisterPayload struct {
+	ProtocolVersion int         `json:"protocolVersion,omitempty"`
+	ClientInfo      *ClientInfo `json:"clientInfo,omitempty"`
+	PanelRole       PanelRole   `json:"panelRole,omitempty"`
+	Name            string      `json:"name,omitempty"`
+}
+
+func (p *RegisterPayload) Validate() error {
+	if p.PanelRole != "" && !p.PanelRole.IsValid() {
+		return invalidField("panelRole", "Unknown panel role")
+	}
+	if len(p.Name) > MAX_NAME_LENGTH {
+		return invalidField("name", "Name must be at most %d characters", MAX_NAME_LENGTH)
+	}
+	if p.ClientInfo != nil && p.ClientInfo.DisplayInfo != nil {
+		if err := p.ClientInfo.DisplayInfo.Validate(); err != nil {
+			var protocolErr *ProtocolError
+			if errors.As(err, &protocolErr) {
+				protocolErr.Field = "clientInfo.displayInfo." + protocolErr.Field
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Session description types carried by signal messages
+type SDPType string
+
+const (
+	SDPOffer    SDPType = "offer"
+	SDPAnswer   SDPType = "answer"
+	SDPPranswer SDPType = "pranswer"
+	SDPRollback SDPType = "rollback"
+)
+
+// WebRTC signal, either a session description or an ICE candidate
+type SignalPayload struct {
+	Type             SDPType `json:"type,omitempty"`
+	SDP              string  `json:"sdp,omitempty"`
+	Candidate        *string `json:"candidate,omitempty"`
+	SDPMid           *string `json:"sdpMid,omitempty"`
+	SDPMLineIndex    *uint16 `json:"sdpMLineIndex,omitempty"`
+	UsernameFragment *string `json:"usernameFragment,omitempty"`
+}
+
+func (p *SignalPayload) Validate() error {
+	switch p.Type {
+	case "":
+		if p.Candidate == nil {
+			return invalidField("type", "Signal must be a session description or an ICE candidate")
+		}
+	case SDPOffer, SDPAnswer, SDPPranswer:
+		if p.SDP == "" {
+			return invalidField("sdp", "Session description is missing sdp")
+		}
+	case SDPRollback:
+	default:
+		return invalidField("type", "Unknown session description type %q", p.Type)
+	}
+	return nil
+}
+
+// Short description of the signal for logs
+func (p *SignalPayload) Kind() string {
+	if p.Type != "" {
+		return string(p.Type)
+	}
+	return "ICE candidate"
+}
+
+// What started a screen capture refresh
+type RefreshType string
+
+const (
+	RefreshAuto   RefreshType = "auto"   // the client noticed a display change
+	RefreshManual RefreshType = "manual" // a viewer asked for it
+)
+
+// Display configuration change notice, also sent at the start and end of a
+// screen capture refresh
+type DisplayConfigChangedPayload struct {
+	Timestamp             int64       `json:"timestamp"`
+	DisplayChangeDetected bool        `json:"displayChangeDetected,omitempty"`
+	IsRefreshing          bool        `json:"isRefreshing,omitempty"`
+	RefreshType           RefreshType `json:"refreshType,omitempty"`
+	NewStreamCount        *int        `json:"newStreamCount,omitempty"` // streams captured once a refresh completes
+	Error                 string      `json:"error,omitempty"`          // why a refresh failed
+}
+
+func (p *DisplayConfigChangedPayload) Validate() error {
+	if p.RefreshType != "" && p.RefreshType != RefreshAuto && p.RefreshType != RefreshManual {
+		return invalidField("refreshType", "Refresh type must be auto or manual")
+	}
+	if p.NewStreamCount != nil && (*p.NewStreamCount < 0 || *p.NewStreamCount > MAX_REPORTED_DISPLAYS) {
+		return invalidField("newStreamCount", "Stream count must be between 0 and %d", MAX_REPORTED_DISPLAYS)
+	}
+	if len(p.Error) > MAX_CLIENT_ERROR {
+		return invalidField("error", "Error must be at most %d characters", MAX_CLIENT_ERROR)
+	}
+	return nil
+}
+
+// Screen rectangle in device independent pixels
+type Rect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// A single display attached to the client
+type DisplayInfo struct {
+	ID          int64   `json:"id"`
+	Bounds      Rect    `json:"bounds"`
+	WorkArea    *Rect   `json:"workArea,omitempty"`
+	ScaleFactor float64 `json:"scaleFactor"`
+	Rotation    int     `json:"rotation,omitempty"`
+	Internal    bool    `json:"internal"`
+	IsPrimary   bool    `json:"isPrimary"`
+	Size        string  `json:"size,omitempty"`
+	ColorDepth  int     `json:"colorDepth,omitempty"`
+	ColorSpace  string  `json:"colorSpace,omitempty"`
+}
+
+// Plug and play monitor counts on Windows. The client computes
+// totalWithInactive as inactive plus a total that already includes them, so
+// the server does not rely on it.
+type PnpInfo struct {
+	TotalWithInactive int    `json:"totalWithInactive"`
+	LastUpdated       string `json:"lastUpdated,omitempty"`
+}
+
+// Monitor summary sent by the client
+type MonitorInfoPayload struct {
+	Total     int           `json:"total,omitempty"`
+	Primary   *int64        `json:"primary"`
+	External  int           `json:"external"`
+	Internal  int           `json:"internal"`
+	Active    int           `json:"active"`
+	Inactive  int           `json:"inactive"`
+	Timestamp int64         `json:"timestamp,omitempty"`
+	PnpInfo   *PnpInfo      `json:"pnpInfo,omitempty"`
+	Displays  []DisplayInfo `json:"displays"`
+}
+
+func (p *MonitorInfoPayload) Validate() error {
+	counts := []struct {
+		field string
+		count int
+	}{
+		{"total", p.Total},
+		{"external", p.External},
+		{"internal", p.Internal},
+		{"active", p.Active},
+		{"inactive", p.Inactive},
+	}
+	for _, c := range counts {
+		if c.count < 0 {
+			return invalidField(c.field, "Display count cannot be negative")
+		}
+	}
+	if p.Displays == nil {
+		return invalidField("displays", "Display list is required")
+	}
+	if len(p.Displays) > MAX_REPORTED_DISPLAYS {
+		return invalidField("displays", "At most %d displays may be reported", MAX_REPORTED_DISPLAYS)
+	}
+	for i, display := range p.Displays {
+		if display.Bounds.Width <= 0 || display.Bounds.Height <= 0 {
+			return invalidField(fmt.Sprintf("displays[%d].bounds", i), "Display bounds must have a positive size")
+		}
+	}
+	return nil
+}
+
+// Process entry as reported by the client
+type ReportedProcess struct {
+	ID          int64    `json:"Id"`
+	ProcessName string   `json:"ProcessName"`
+	WindowTitle string   `json:"WindowTitle,omitempty"`
+	Path        string   `json:"Path,omitempty"`
+	CPU         *float64 `json:"CPU,omitempty"`
+	Memory      *float64 `json:"Memory,omitempty"`
+}
+
+// Process info payload sent by the client. The server sets Sequence on
+// snapshots it sends, the processDelta sequence the list is current to.
+type ProcessInfoPayload struct {
+	Processes []ReportedProcess `json:"processes"`
+	Timestamp int64             `json:"timestamp"`
+	Sequence  uint64            `json:"sequence,omitempty"`
+}
+
+func (p *ProcessInfoPayload) Validate() error {
+	if p.Processes == nil {
+		return invalidField("processes", "Process list is required")
+	}
+	if len(p.Processes) > MAX_REPORTED_PROCESSES {
+		return invalidField("processes", "At most %d processes may be reported", MAX_REPORTED_PROCESSES)
+	}
+	for i, process := range p.Processes {
+		if strings.TrimSpace(process.ProcessName) == "" {
+			return invalidField(fmt.Sprintf("processes[%d].ProcessName", i), "Process name is required")
+		}
+	}
+	return nil
+}
+
+// Admin command sent by an interviewer
+type AdminCommandPayload struct {
+	Command   string          `json:"command"`
+	Timestamp int64           `json:"timestamp,omitempty"`
+	Args      json.RawMessage `json:"args,omitempty"`
+}
+
+func (p *AdminCommandPayload) Validate() error {
+	if p.Command == "" {
+		return invalidField("command", "Command is required")
+	}
+	return nil
+}
+
+// Client's answer to the consent request, SignedName is the name the
+// candidate typed to sign it
+type ConsentAckPayload struct {
+	Version    string `json:"version"`
+	TextHash   string `json:"textHash"`
+	SignedName string `json:"signedName"`
+	Accepted   bool   `json:"accepted"`
+	Timestamp  int64  `json:"timestamp,omitempty"`
+}
+
+func (p *ConsentAckPayload) Validate() error {
+	if p.Version == "" {
+		return invalidField("version", "Consent version is required")
+	}
+	if p.TextHash == "" {
+		return invalidField("textHash", "Consent text hash is required")
+	}
+	if p.Accepted && strings.TrimSpace(p.SignedName) == "" {
+		return invalidField("signedName", "A signature is required to consent")
+	}
+	if len(p.SignedName) > MAX_NAME_LENGTH {
+		return invalidField("signedName", "Signature must be at most %d characters", MAX_NAME_LENGTH)
+	}
+	return nil
+}
+
+// Payload types for each message a peer may send, nil for messages without one
+var clientPayloads = map[MessageType]func() ClientPayload{
+	RequestCode:          func() ClientPayload { return &RequestCodePayload{} },
+	Register:             func() ClientPayload { return &RegisterPayload{} },
+	Signal:               func() ClientPayload { return &SignalPayload{} },
+	Connect:              nil,
+	DisplayConfigChanged: func() ClientPayload { return &DisplayConfigChangedPayload{} },
+	MonitorInfo:          func() ClientPayload { return &MonitorInfoPayload{} },
+	ProcessInfo:          func() ClientPayload { return &ProcessInfoPayload{} },
+	AdminCommand:         func() ClientPayload { return &AdminCommandPayload{} },
+	ConsentAck:           func() ClientPayload { return &ConsentAckPayload{} },
+	ProcessSnapshot:      nil,
+}
+
+// Check the envelope and decode the typed payload of a message
+func decodeMessage(msg *Message) (ClientPayload, error) {
+	factory, known := clientPayloads[msg.Type]
+	if !known {
+		return nil, &ProtocolError{Code: ErrUnknownMessageType, Message: "Unknown message type", Field: "type"}
+	}
+
+	if msg.Type != RequestCode && msg.Code == "" {
+		return nil, &ProtocolError{Code: ErrInvalidMessage, Message: "Session code is required", Field: "code"}
+	}
+	if msg.Type == Register && msg.Role != ClientRole && msg.Role != ViewerRole {
+		return nil, &ProtocolError{Code: ErrInvalidMessage, Message: "Role must be client or viewer", Field: "role"}
+	}
+
+	if factory == nil {
+		return nil, nil
+	}
+
+	payload := factory()
+	if len(msg.Payload) > 0 && string(msg.Payload) != "null" {
+		if err := json.Unmarshal(msg.Payload, payload); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				return nil, invalidField(typeErr.Field, "Expected %s, got %s", typeErr.Type, typeErr.Value)
+			}
+			return nil, &ProtocolError{Code: ErrInvalidPayload, Message: "Payload is not valid JSON"}
+		}
+	}
+	if err := payload.Validate(); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// Payloads of messages the server sends
+
+// Code assigned to a lead interviewer
+type CodeAssignmentPayload struct {
+	Code            string      `json:"code"`
+	ProtocolVersion int         `json:"protocolVersion"`
+	Mode            SessionMode `json:"mode"`
+}
+
+// STUN or TURN server for a peer connection, shaped like RTCIceServer
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// Registration confirmed for a client
+type SessionEstablishedPayload struct {
+	Timestamp       int64            `json:"timestamp"`
+	Viewers         []ViewerIdentity `json:"viewers"`
+	Reconnect       bool             `json:"reconnect,omitempty"`
+	Refresh         bool             `json:"refresh,omitempty"`
+	ProtocolVersion int              `json:"protocolVersion"`
+	ICEServers      []ICEServer      `json:"iceServers,omitempty"`
+	Mode            SessionMode      `json:"mode,omitempty"`
+}
+
+// The client joined the session
+type ClientInfoPayload struct {
+	Timestamp  int64       `json:"timestamp"`
+	Code       string      `json:"code"`
+	ClientInfo interface{} `json:"clientInfo"`
+	ICEServers []ICEServer `json:"iceServers,omitempty"`
+	Mode       SessionMode `json:"mode,omitempty"`
+}
+
+// A client is in the waiting room, admit or reject it with an admin command
+type ClientWaitingPayload struct {
+	Timestamp  int64       `json:"timestamp"`
+	Code       string      `json:"code"`
+	ClientInfo interface{} `json:"clientInfo"`
+	Since      int64       `json:"since"` // when the client started waiting
+}
+
+// Monitor setup changed mid-interview
+type DisplayAlertPayload struct {
+	Timestamp int64            `json:"timestamp"`
+	Code      string           `json:"code"`
+	Changes   []DisplayChange  `json:"changes"`
+	Topology  *DisplayTopology `json:"topology"`
+}
+
+// Process that exited since the last delta
+type ProcessRef struct {
+	ID          int64  `json:"Id"`
+	ProcessName string `json:"ProcessName"`
+}
+
+// Changes to the process list since the previous delta. Viewers apply
+// deltas in sequence order, ignore those at or below the snapshot they hold,
+// and send processSnapshot to start over after a gap.
+type ProcessDeltaPayload struct {
+	Timestamp int64             `json:"timestamp"`
+	Code      string            `json:"code"`
+	Sequence  uint64            `json:"sequence"`
+	Started   []ReportedProcess `json:"started,omitempty"`
+	Exited    []ProcessRef      `json:"exited,omitempty"`
+	Changed   []ReportedProcess `json:"changed,omitempty"`
+	Count     int               `json:"count"` // processes running after the delta
+}
+
+// Consent text the client must acknowledge before monitoring starts
+type ConsentRequestPayload struct {
+	Timestamp int64  `json:"timestamp"`
+	Version   string `json:"version"`
+	Text      string `json:"text"`
+	TextHash  string `json:"textHash"` // hex SHA-256 of text, echoed back in consentAck
+}
+
+// The client is held until a viewer admits it
+type WaitingRoomPayload struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// The client left the session
+type ClientDisconnectedPayload struct {
+	Timestamp int64  `json:"timestamp"`
+	Code      string `json:"code"`
+}
+
+// The client came back after a disconnect
+type ClientReconnectedPayload struct {
+	Timestamp int64 `json:"timestamp"`
+}
+
+// A viewer joined, sent to the client
+type ViewerConnectedPayload struct {
+	Timestamp int64            `json:"timestamp"`
+	Viewer    ViewerIdentity   `json:"viewer"`
+	Viewers   []ViewerIdentity `json:"viewers"`
+}
+
+// A viewer left, sent to the client
+type ViewerDisconnectedPayload struct {
+	Timestamp int64            `json:"timestamp"`
+	Code      string           `json:"code"`
+	Viewer    ViewerIdentity   `json:"viewer"`
+	Viewers   []ViewerIdentity `json:"viewers"`
+}
+
+// Request for the client to start WebRTC
+type ConnectPayload struct {
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Outcome of an admin command handled by the server
+type AdminCommandResponsePayload struct {
+	Command string `json:"command"`
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// Suspicious processes that appeared or disappeared
+type ProcessAlertPayload struct {
+	Timestamp   int64          `json:"timestamp"`
+	Code        string         `json:"code"`
+	Appeared    []ProcessMatch `json:"appeared"`
+	Disappeared []ProcessMatch `json:"disappeared"`
+	Active      []ProcessMatch `json:"active"`
+}
+
+// Session closed by the server, peers should disconnect
+type SessionEndedPayload struct {
+	Timestamp int64  `json:"timestamp"`
+	Code      string `json:"code"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Server going away, peers should reconnect once it is back
+type ServerShutdownPayload struct {
+	Timestamp      int64  `json:"timestamp"`
+	Reason         string `json:"reason"`
+	Reconnect      bool   `json:"reconnect"`
+	ReconnectAfter int    `json:"reconnectAfter"` // seconds to wait before reconnecting
+	ClosesIn       int    `json:"closesIn"`       // seconds until the server closes the socket
+}
+
+// Payload types for each message the server sends
+var serverPayloads = map[MessageType]interface{}{
+	CodeAssigned:         CodeAssignmentPayload{},
+	SessionEstablished:   SessionEstablishedPayload{},
+	ClientConnected:      ClientInfoPayload{},
+	ClientDisconnected:   ClientDisconnectedPayload{},
+	ClientReconnected:    ClientReconnectedPayload{},
+	ViewerConnected:      ViewerConnectedPayload{},
+	ViewerDisconnected:   ViewerDisconnectedPayload{},
+	Connect:              ConnectPayload{},
+	Signal:               SignalPayload{},
+	DisplayConfigChanged: DisplayConfigChangedPayload{},
+	MonitorInfo:          MonitorInfoPayload{},
+	ProcessInfo:          ProcessInfoPayload{},
+	ProcessAlert:         ProcessAlertPayload{},
+	AdminCommand:         AdminCommandPayload{},
+	AdminCommandResponse: AdminCommandResponsePayload{},
+	SessionEnded:         SessionEndedPayload{},
+	ServerShutdown:       ServerShutdownPayload{},
+	ClientWaiting:        ClientWaitingPayload{},
+	WaitingRoom:          WaitingRoomPayload{},
+	ConsentRequest:       ConsentRequestPayload{},
+	ProcessDelta:         ProcessDeltaPayload{},
+	DisplayAlert:         DisplayAlertPayload{},
+	Error:                ErrorPayload{},
+}
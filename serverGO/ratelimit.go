@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Rate limit defaults
+const (
+	DEFAULT_RATE_LIMIT         = 50.0 // messages per second per connection
+	DEFAULT_RATE_BURST         = 200  // messages a connection may send at once
+	DEFAULT_RATE_LIMIT_STRIKES = 20   // dropped messages tolerated before disconnecting, refills one per second
+	RATE_LIMIT_NOTICE_INTERVAL = time.Second
+)
+
+// Sustained rate and burst for a token bucket
+type RateSpec struct {
+	Rate  float64 // tokens per second
+	Burst int
+}
+
+// Per-type limits on top of the connection-wide one. Signals are left to the
+// connection limit because ICE candidates arrive in bursts.
+var defaultMessageRates = map[MessageType]RateSpec{
+	RequestCode:          {Rate: 0.2, Burst: 3},
+	Register:             {Rate: 0.5, Burst: 5},
+	Connect:              {Rate: 1, Burst: 5},
+	DisplayConfigChanged: {Rate: 2, Burst: 5},
+	MonitorInfo:          {Rate: 2, Burst: 5},
+	ProcessInfo:          {Rate: 1, Burst: 3},
+	AdminCommand:         {Rate: 2, Burst: 10},
+	ConsentAck:           {Rate: 0.5, Burst: 3},
+	ProcessSnapshot:      {Rate: 0.5, Burst: 3},
+}
+
+// Parse per-type overrides like "monitorInfo=2/5,processInfo=0.5/2"
+func parseMessageRates(raw string) (map[MessageType]RateSpec, error) {
+	rates := make(map[MessageType]RateSpec, len(defaultMessageRates))
+	for msgType, spec := range defaultMessageRates {
+		rates[msgType] = spec
+	}
+
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, limit, ok := strings.Cut(entry, "=")
+		rawRate, rawBurst, hasBurst := strings.Cut(limit, "/")
+		if !ok || !hasBurst {
+			return nil, fmt.Errorf("rate limit %q is not type=rate/burst", entry)
+		}
+		msgType := MessageType(strings.TrimSpace(name))
+		if _, known := clientPayloads[msgType]; !known {
+			return nil, fmt.Errorf("rate limit for unknown message type %q", msgType)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rawRate), 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid rate in %q", entry)
+		}
+		burst, err := strconv.Atoi(strings.TrimSpace(rawBurst))
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("invalid burst in %q", entry)
+		}
+		rates[msgType] = RateSpec{Rate: rate, Burst: burst}
+	}
+	return rates, nil
+}
+
+// Classic token bucket, not safe for concurrent use
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// Create a full bucket
+func NewTokenBucket(spec RateSpec) *TokenBucket {
+	return &TokenBucket{rate: spec.Rate, burst: float64(spec.Burst), tokens: float64(spec.Burst)}
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// Take a token if one is available
+func (b *TokenBucket) Allow(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Time until the next token is available
+func (b *TokenBucket) Wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Outcome of checking a message against a connection's limits
+type rateVerdict int
+
+const (
+	rateAllow      rateVerdict = iota
+	rateDrop                   // over the limit, drop and tell the peer
+	rateDropSilent             // over the limit, peer was told recently
+	rateDisconnect             // over the limit for too long
+)
+
+// Rate limits for one connection, only used from its read loop
+type MessageLimiter struct {
+	overall    *TokenBucket
+	byType     map[MessageType]*TokenBucket
+	rates      map[MessageType]RateSpec
+	strikes    *TokenBucket
+	lastNotice time.Time
+}
+
+// Limiter with the server's configured rates
+func (s *Server) newMessageLimiter() *MessageLimiter {
+	return &MessageLimiter{
+		overall: NewTokenBucket(RateSpec{Rate: s.config.RateLimit, Burst: s.config.RateBurst}),
+		byType:  make(map[MessageType]*TokenBucket),
+		rates:   s.messageRates,
+		strikes: NewTokenBucket(RateSpec{Rate: 1, Burst: s.config.RateLimitStrikes}),
+	}
+}
+
+// Check the connection-wide bucket, then the one for msgType
+func (l *MessageLimiter) Check(msgType MessageType, now time.Time) (rateVerdict, time.Duration) {
+	bucket := l.overall
+	if !bucket.Allow(now) {
+		return l.reject(bucket, now)
+	}
+
+	spec, limited := l.rates[msgType]
+	if !limited {
+		return rateAllow, 0
+	}
+	bucket = l.byType[msgType]
+	if bucket == nil {
+		bucket = NewTokenBucket(spec)
+		l.byType[msgType] = bucket
+	}
+	if !bucket.Allow(now) {
+		return l.reject(bucket, now)
+	}
+	return rateAllow, 0
+}
+
+func (l *MessageLimiter) reject(bucket *TokenBucket, now time.Time) (rateVerdict, time.Duration) {
+	wait := bucket.Wait(now)
+	if !l.strikes.Allow(now) {
+		return rateDisconnect, wait
+	}
+	if now.Sub(l.lastNotice) < RATE_LIMIT_NOTICE_INTERVAL {
+		return rateDropSilent, wait
+	}
+	l.lastNotice = now
+	return rateDrop, wait
+}
+
+var errMessageTooLarge = errors.New("message too large")
+
+// Read the next message, refusing to buffer more than limit bytes
+func readLimitedMessage(ws *websocket.Conn, limit int64) ([]byte, error) {
+	_, reader, err := ws.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errMessageTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketBurstAndRefill(t *testing.T) {
+	bucket := NewTokenBucket(RateSpec{Rate: 2, Burst: 3})
+	start := time.Unix(1000, 0)
+
+	for i := 0; i < 3; i++ {
+		if !bucket.Allow(start) {
+			t.Fatalf("burst token %d refused", i+1)
+		}
+	}
+	if bucket.Allow(start) {
+		t.Fatal("allowed a token past the burst")
+	}
+	if wait := bucket.Wait(start); wait != 500*time.Millisecond {
+		t.Fatalf("wait %s, want 500ms", wait)
+	}
+
+	// Half a second at two tokens per second earns exactly one
+	if !bucket.Allow(start.Add(500 * time.Millisecond)) {
+		t.Fatal("refilled token refused")
+	}
+	if bucket.Allow(start.Add(500 * time.Millisecond)) {
+		t.Fatal("allowed more than the refill")
+	}
+
+	// A long pause never fills past the burst
+	later := start.Add(time.Hour)
+	allowed := 0
+	for bucket.Allow(later) {
+		allowed++
+	}
+	if allowed != 3 {
+		t.Fatalf("allowed %d after a long pause, want 3", allowed)
+	}
+}
+
+func TestParseMessageRates(t *testing.T) {
+	rates, err := parseMessageRates(" monitorInfo = 4/8 , processInfo=0.5/2,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rates[MonitorInfo]; got != (RateSpec{Rate: 4, Burst: 8}) {
+		t.Errorf("monitorInfo %+v", got)
+	}
+	if got := rates[ProcessInfo]; got != (RateSpec{Rate: 0.5, Burst: 2}) {
+		t.Errorf("processInfo %+v", got)
+	}
+	if got := rates[Register]; got != defaultMessageRates[Register] {
+		t.Errorf("register %+v, want the default", got)
+	}
+	if defaultMessageRates[MonitorInfo] == rates[MonitorInfo] {
+		t.Error("override changed the defaults")
+	}
+
+	if rates, err := parseMessageRates(""); err != nil || len(rates) != len(defaultMessageRates) {
+		t.Errorf("empty overrides: %v, %v", rates, err)
+	}
+
+	for _, raw := range []string{
+		"monitorInfo",
+		"monitorInfo=2",
+		"bogus=1/1",
+		"monitorInfo=0/5",
+		"monitorInfo=-1/5",
+		"monitorInfo=x/5",
+		"monitorInfo=1/0",
+		"monitorInfo=1/1.5",
+	} {
+		if _, err := parseMessageRates(raw); err == nil {
+			t.Errorf("%q parsed without error", raw)
+		}
+	}
+}
+
+func TestMessageLimiterVerdicts(t *testing.T) {
+	limiter := &MessageLimiter{
+		overall: NewTokenBucket(RateSpec{Rate: 100, Burst: 100}),
+		byType:  make(map[MessageType]*TokenBucket),
+		rates:   map[MessageType]RateSpec{Connect: {Rate: 1, Burst: 1}},
+		strikes: NewTokenBucket(RateSpec{Rate: 1, Burst: 2}),
+	}
+	now := time.Unix(1000, 0)
+
+	if verdict, _ := limiter.Check(Signal, now); verdict != rateAllow {
+		t.Fatalf("unlimited type got %d", verdict)
+	}
+	if verdict, _ := limiter.Check(Connect, now); verdict != rateAllow {
+		t.Fatalf("first connect got %d", verdict)
+	}
+
+	// Over the limit the peer is told once, then dropped silently, then cut off
+	verdict, wait := limiter.Check(Connect, now)
+	if verdict != rateDrop || wait != time.Second {
+		t.Fatalf("second connect got %d after %s", verdict, wait)
+	}
+	if verdict, _ := limiter.Check(Connect, now); verdict != rateDropSilent {
+		t.Fatalf("third connect got %d", verdict)
+	}
+	if verdict, _ := limiter.Check(Connect, now); verdict != rateDisconnect {
+		t.Fatalf("fourth connect got %d", verdict)
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/h264writer"
+	"github.com/pion/webrtc/v4/pkg/media/ivfwriter"
+)
+
+// Recording defaults
+const (
+	DEFAULT_RECORDING_DIR  = "recordings"
+	RECORDER_SIGNAL_BUFFER = 64              // queued signals before the recorder drops them
+	RECORDER_PLI_INTERVAL  = 3 * time.Second // keyframe requests so files stay seekable
+)
+
+// Recording settings
+type RecordingConfig struct {
+	Enabled bool
+	Dir     string
+}
+
+// Recording settings from recordingEnabled and recordingDir, creating the
+// directory when recording is on
+func NewRecordingConfig(c *Config) (RecordingConfig, error) {
+	config := RecordingConfig{Enabled: c.RecordingEnabled, Dir: c.RecordingDir}
+
+	if config.Enabled {
+		if err := os.MkdirAll(config.Dir, 0750); err != nil {
+			return config, fmt.Errorf("creating recording directory: %w", err)
+		}
+	}
+
+	return config, nil
+}
+
+// Hidden WebRTC peer that joins a session and writes the client's tracks to disk
+type Recorder struct {
+	ID         string
+	code       string
+	dir        string
+	send       func(ResponseMessage)
+	signals    chan json.RawMessage
+	pc         *webrtc.PeerConnection
+	candidates []webrtc.ICECandidateInit // received before the offer
+	closed     bool
+	mu         sync.Mutex
+}
+
+// Create a recorder for a session writing under dir/{sessionID}, send
+// delivers signals to the client
+func NewRecorder(code, sessionID, dir string, send func(ResponseMessage)) (*Recorder, error) {
+	if !isSafePathElement(sessionID) {
+		return nil, fmt.Errorf("invalid session ID %q", sessionID)
+	}
+	sessionDir := filepath.Join(dir, sessionID)
+	if err := os.MkdirAll(sessionDir, 0750); err != nil {
+		return nil, fmt.Errorf("creating session recording directory: %w", err)
+	}
+
+	r := &Recorder{
+		ID:      "recorder-" + code,
+		code:    code,
+		dir:     sessionDir,
+		send:    send,
+		signals: make(chan json.RawMessage, RECORDER_SIGNAL_BUFFER),
+	}
+	go r.run()
+
+	return r, nil
+}
+
+// Identity the recorder is announced to the client with
+func (r *Recorder) Identity() ViewerIdentity {
+	return ViewerIdentity{
+		ID:        r.ID,
+		PanelRole: RecorderRole,
+		Name:      "Recorder",
+	}
+}
+
+// Queue a signal from the client, never blocks the caller
+func (r *Recorder) Signal(payload json.RawMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	select {
+	case r.signals <- payload:
+	default:
+		log.Printf("⚠️ Recorder for %s is falling behind, dropping signal", r.code)
+	}
+}
+
+// Stop recording and close any open files
+func (r *Recorder) Close() {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.signals)
+	pc := r.pc
+	r.pc = nil
+	r.mu.Unlock()
+
+	if pc != nil {
+		pc.Close()
+	}
+}
+
+// Apply signals in the order the client sent them
+func (r *Recorder) run() {
+	for payload := range r.signals {
+		if err := r.handleSignal(payload); err != nil {
+			log.Printf("⚠️ Recorder for %s: %v", r.code, err)
+		}
+	}
+}
+
+func (r *Recorder) handleSignal(payload json.RawMessage) error {
+	var probe struct {
+		Type      string          `json:"type"`
+		Candidate json.RawMessage `json:"candidate"`
+	}
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return fmt.Errorf("malformed signal: %w", err)
+	}
+
+	switch {
+	case probe.Type == "offer":
+		var offer webrtc.SessionDescription
+		if err := json.Unmarshal(payload, &offer); err != nil {
+			return fmt.Errorf("malformed offer: %w", err)
+		}
+		return r.answer(offer)
+
+	case probe.Candidate != nil:
+		var candidate webrtc.ICECandidateInit
+		if err := json.Unmarshal(payload, &candidate); err != nil {
+			return fmt.Errorf("malformed ICE candidate: %w", err)
+		}
+
+		r.mu.Lock()
+		pc := r.pc
+		if pc == nil || pc.RemoteDescription() == nil {
+			r.candidates = append(r.candidates, candidate)
+			r.mu.Unlock()
+			return nil
+		}
+		r.mu.Unlock()
+		return pc.AddICECandidate(candidate)
+	}
+
+	return nil
+}
+
+// Answer an offer on a fresh peer connection, replacing any earlier one
+func (r *Recorder) answer(offer webrtc.SessionDescription) error {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return fmt.Errorf("creating peer connection: %w", err)
+	}
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+		r.sendSignal(candidate.ToJSON())
+	})
+	pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		r.record(pc, track)
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Printf("🎥 Recorder for %s is %s", r.code, state)
+	})
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		pc.Close()
+		return nil
+	}
+	previous := r.pc
+	r.pc = pc
+	candidates := r.candidates
+	r.candidates = nil
+	r.mu.Unlock()
+
+	// A new offer means the client restarted its side
+	if previous != nil {
+		previous.Close()
+	}
+
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		return fmt.Errorf("applying offer: %w", err)
+	}
+	for _, candidate := range candidates {
+		if err := pc.AddICECandidate(candidate); err != nil {
+			log.Printf("⚠️ Recorder for %s rejected ICE candidate: %v", r.code, err)
+		}
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return fmt.Errorf("creating answer: %w", err)
+	}
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return fmt.Errorf("applying answer: %w", err)
+	}
+
+	r.sendSignal(answer)
+	return nil
+}
+
+func (r *Recorder) sendSignal(payload interface{}) {
+	response := createResponseMessage(Signal, payload)
+	response.From = r.ID
+	r.send(response)
+}
+
+// Media writer shared by the IVF and H264 formats
+type trackWriter interface {
+	WriteRTP(packet *rtp.Packet) error
+	Close() error
+}
+
+// Write a remote track to disk until it ends
+func (r *Recorder) record(pc *webrtc.PeerConnection, track *webrtc.TrackRemote) {
+	codec := track.Codec().MimeType
+	base := fmt.Sprintf("%s-%s", time.Now().Format("20060102-150405"), sanitizeFileName(track.ID()))
+
+	var writer trackWriter
+	var path string
+	var err error
+	switch {
+	case strings.EqualFold(codec, webrtc.MimeTypeVP8),
+		strings.EqualFold(codec, webrtc.MimeTypeVP9),
+		strings.EqualFold(codec, webrtc.MimeTypeAV1):
+		path = filepath.Join(r.dir, base+".ivf")
+		writer, err = ivfwriter.New(path, ivfwriter.WithCodec(codec))
+	case strings.EqualFold(codec, webrtc.MimeTypeH264):
+		path = filepath.Join(r.dir, base+".h264")
+		writer, err = h264writer.New(path)
+	default:
+		log.Printf("⚠️ Recorder for %s cannot store %s tracks, skipping", r.code, codec)
+		return
+	}
+	if err != nil {
+		log.Printf("Error opening recording file for %s: %v", r.code, err)
+		return
+	}
+
+	log.Printf("🎥 Recording %s track for %s to %s", codec, r.code, path)
+
+	// Ask for keyframes periodically so the file can be decoded from any point
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(RECORDER_PLI_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				pli := &rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())}
+				if err := pc.WriteRTCP([]rtcp.Packet{pli}); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			break
+		}
+		if err := writer.WriteRTP(packet); err != nil {
+			log.Printf("Error writing recording for %s: %v", r.code, err)
+			break
+		}
+	}
+
+	close(done)
+	if err := writer.Close(); err != nil {
+		log.Printf("Error closing recording for %s: %v", r.code, err)
+	}
+	log.Printf("🎥 Finished recording %s", path)
+}
+
+// Keep only characters that are safe in a file name
+func sanitizeFileName(name string) string {
+	cleaned := strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' {
+			return c
+		}
+		return '_'
+	}, name)
+	if cleaned == "" {
+		return "track"
+	}
+	return cleaned
+}
+
+// Start recording a session, caller must not hold session.mu
+func (s *Server) startRecording(code string, session *Session) error {
+	session.mu.Lock()
+	if session.recorder != nil {
+		session.mu.Unlock()
+		return nil
+	}
+	recorder, err := NewRecorder(code, session.id(), s.recording.Dir, func(response ResponseMessage) {
+		s.sendToClient(code, session, response)
+	})
+	if err != nil {
+		session.mu.Unlock()
+		return err
+	}
+	session.recorder = recorder
+	identities := session.viewerIdentities()
+	session.mu.Unlock()
+
+	log.Printf("🎥 Started recording session %s", code)
+
+	// Introduce the recorder to the client like any other viewer
+	identity := recorder.Identity()
+	s.sendToClient(code, session, createSimpleResponseMessage(ViewerConnected, ViewerConnectedPayload{
+		Timestamp: getCurrentTimestamp(),
+		Viewer:    identity,
+		Viewers:   identities,
+	}))
+	s.publishSessionEvent(code, clusterEvent{Kind: clusterViewerJoined, Viewer: &identity})
+
+	connect := createSimpleResponseMessage(Connect, nil)
+	connect.From = recorder.ID
+	s.connectClient(code, session, connect)
+
+	return nil
+}
+
+// Stop recording a session, caller must not hold session.mu
+func (s *Server) stopRecording(code string, session *Session) {
+	session.mu.Lock()
+	recorder := session.recorder
+	session.recorder = nil
+	identities := session.viewerIdentities()
+	session.mu.Unlock()
+
+	if recorder == nil {
+		return
+	}
+	recorder.Close()
+
+	log.Printf("🎥 Stopped recording session %s", code)
+
+	identity := recorder.Identity()
+	s.sendToClient(code, session, createSimpleResponseMessage(ViewerDisconnected, ViewerDisconnectedPayload{
+		Timestamp: getCurrentTimestamp(),
+		Code:      code,
+		Viewer:    identity,
+		Viewers:   identities,
+	}))
+	s.publishSessionEvent(code, clusterEvent{Kind: clusterViewerLeft, Viewer: &identity})
+}
+
+// Recording file listing entry
+type RecordingFile struct {
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	ModifiedAt int64  `json:"modifiedAt"`
+	URL        string `json:"url"`
+}
+
+// Handle GET /recordings/{id} and GET /recordings/{id}/{file}, where id is
+// the session ID rather than its reusable code
+func (s *Server) handleRecordings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims, err := s.auth.VerifyRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/recordings/"), "/"), "/")
+	id := parts[0]
+	if len(parts) > 2 || !isSafePathElement(id) {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.authorizeSessionID(claims, id) {
+		log.Printf("🚫 %s asked for recordings of %s without being on its panel", claims.Subject, id)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	dir := filepath.Join(s.recording.Dir, id)
+
+	if len(parts) == 2 {
+		name := parts[1]
+		if !isSafePathElement(name) {
+			http.NotFound(w, r)
+			return
+		}
+
+		file, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		http.ServeContent(w, r, name, info.ModTime(), file)
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Error listing recordings for %s: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	files := []RecordingFile{}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, RecordingFile{
+			Name:       entry.Name(),
+			Size:       info.Size(),
+			ModifiedAt: info.ModTime().UnixMilli(),
+			URL:        "/recordings/" + id + "/" + entry.Name(),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":         id,
+		"code":       sessionIDCode(id),
+		"recordings": files,
+	})
+}
+
+// Reject path elements that could escape the recording directory
+func isSafePathElement(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\`)
+}
+
+// Handle the startRecording and stopRecording admin commands
+func (s *Server) handleRecordingCommand(conn *Connection, code string, session *Session, command string) {
+	respond := func(success bool, message string) {
+		conn.Send(createSimpleResponseMessage(AdminCommandResponse, AdminCommandResponsePayload{
+			Command: command,
+			Success: success,
+			Message: message,
+		}))
+	}
+
+	if session == nil {
+		respond(false, "Session not found")
+		return
+	}
+	if !s.onPanel(conn, code) {
+		conn.Send(createErrorMessage(ErrForbidden, "Not on the panel for this session"))
+		return
+	}
+
+	if command == "stopRecording" {
+		s.stopRecording(code, session)
+		respond(true, "Recording stopped")
+		return
+	}
+
+	if !s.recording.Enabled {
+		respond(false, "Recording is disabled on this server")
+		return
+	}
+	if err := s.startRecording(code, session); err != nil {
+		log.Printf("Error starting recording for %s: %v", code, err)
+		respond(false, "Failed to start recording")
+		return
+	}
+	respond(true, "Recording started")
+}
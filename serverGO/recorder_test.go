@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordingsServedBySessionID(t *testing.T) {
+	s := newTestServer(t)
+	s.audit = NewAuditLog(t.TempDir())
+	s.recording.Dir = t.TempDir()
+	signer := NewTokenSigner([]byte("secret"), time.Hour)
+	s.auth = &Authenticator{tokens: signer}
+
+	// alice recorded the first interview on 111111, bob holds the code now
+	ended := newSessionID("111111", time.UnixMilli(1000))
+	s.audit.Append(ended, AuditEvent{Type: Register, ConnID: "a", Subject: "alice"})
+	os.MkdirAll(filepath.Join(s.recording.Dir, ended), 0750)
+	os.WriteFile(filepath.Join(s.recording.Dir, ended, "video-1.ivf"), []byte("frames"), 0640)
+	s.pendingCodes["111111"] = &PendingCode{
+		ID:        newSessionID("111111", time.UnixMilli(2000)),
+		CreatedAt: time.UnixMilli(2000),
+		Subject:   "bob",
+		Viewers:   make(map[string]*Connection),
+	}
+
+	get := func(subject, path string) *httptest.ResponseRecorder {
+		token, _, _ := signer.Issue(subject, false)
+		request := httptest.NewRequest("GET", path, nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		s.handleRecordings(recorder, request)
+		return recorder
+	}
+
+	response := get("alice", "/recordings/"+ended)
+	if response.Code != 200 {
+		t.Fatalf("listing returned %d", response.Code)
+	}
+	var listing struct {
+		ID         string          `json:"id"`
+		Recordings []RecordingFile `json:"recordings"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &listing)
+	if listing.ID != ended || len(listing.Recordings) != 1 || listing.Recordings[0].URL != "/recordings/"+ended+"/video-1.ivf" {
+		t.Fatalf("listing %s", response.Body)
+	}
+	if response := get("alice", listing.Recordings[0].URL); response.Code != 200 || response.Body.String() != "frames" {
+		t.Fatalf("download returned %d: %s", response.Code, response.Body)
+	}
+
+	// The code's new panel cannot reach the old recordings, nor the old panel the new ones
+	if response := get("bob", "/recordings/"+ended); response.Code != 403 {
+		t.Fatalf("bob listed the earlier session's recordings: %d", response.Code)
+	}
+	if response := get("bob", "/recordings/"+ended+"/video-1.ivf"); response.Code != 403 {
+		t.Fatalf("bob downloaded the earlier session's recording: %d", response.Code)
+	}
+	if response := get("alice", "/recordings/"+s.pendingCodes["111111"].ID); response.Code != 403 {
+		t.Fatalf("alice listed the current session's recordings: %d", response.Code)
+	}
+	if response := get("alice", "/recordings/111111"); response.Code != 403 {
+		t.Fatalf("alice listed recordings by code: %d", response.Code)
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheduling limits
+const (
+	MAX_INTERVIEW_LENGTH = 12 * time.Hour // longest window an interview may be booked for
+	MAX_PANEL_SIZE       = 20             // interviewers listed on one interview
+)
+
+// Where an interview is relative to its window
+const (
+	InterviewScheduled = "scheduled" // window has not opened yet
+	InterviewOpen      = "open"      // the candidate may register
+	InterviewEnded     = "ended"     // window closed, code no longer accepted
+)
+
+// Interview slot booked in advance with a reserved code
+type ScheduledInterview struct {
+	Code           string      `json:"code"`
+	StartsAt       time.Time   `json:"startsAt"`
+	EndsAt         time.Time   `json:"endsAt"`
+	CandidateEmail string      `json:"candidateEmail"`
+	Interviewers   []string    `json:"interviewers"` // subjects allowed to watch besides the creator
+	CreatedBy      string      `json:"createdBy"`
+	CreatedAt      time.Time   `json:"createdAt"`
+	Mode           SessionMode `json:"mode"`
+}
+
+// Where the interview is at the given time
+func (i *ScheduledInterview) Status(now time.Time) string {
+	switch {
+	case now.Before(i.StartsAt):
+		return InterviewScheduled
+	case now.Before(i.EndsAt):
+		return InterviewOpen
+	default:
+		return InterviewEnded
+	}
+}
+
+// Check if an interviewer is on the panel
+func (i *ScheduledInterview) Allows(subject string) bool {
+	if subject == i.CreatedBy {
+		return true
+	}
+	for _, interviewer := range i.Interviewers {
+		if interviewer == subject {
+			return true
+		}
+	}
+	return false
+}
+
+// Scheduled interviews booked on this node, plus those other nodes announced
+type InterviewSchedule struct {
+	local  map[string]*ScheduledInterview
+	remote map[string]*ScheduledInterview
+	mu     sync.Mutex
+}
+
+// Create an empty schedule
+func NewInterviewSchedule() *InterviewSchedule {
+	return &InterviewSchedule{
+		local:  make(map[string]*ScheduledInterview),
+		remote: make(map[string]*ScheduledInterview),
+	}
+}
+
+// Book an interview on this node
+func (sc *InterviewSchedule) Add(interview *ScheduledInterview) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.local[interview.Code] = interview
+}
+
+// Cancel an interview booked on this node
+func (sc *InterviewSchedule) Remove(code string) *ScheduledInterview {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	interview := sc.local[code]
+	delete(sc.local, code)
+	return interview
+}
+
+// Interview booked on this node for a code, or nil
+func (sc *InterviewSchedule) Local(code string) *ScheduledInterview {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.local[code]
+}
+
+// Interview booked anywhere in the cluster for a code, or nil
+func (sc *InterviewSchedule) Lookup(code string) *ScheduledInterview {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if interview := sc.local[code]; interview != nil {
+		return interview
+	}
+	return sc.remote[code]
+}
+
+// Check if a code is reserved by an interview booked on this node
+func (sc *InterviewSchedule) Reserves(code string) bool {
+	return sc.Local(code) != nil
+}
+
+// Remember an interview another node announced
+func (sc *InterviewSchedule) SetRemote(interview *ScheduledInterview) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.remote[interview.Code] = interview
+}
+
+// Forget an interview once no node holds its code
+func (sc *InterviewSchedule) ForgetRemote(code string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delete(sc.remote, code)
+}
+
+// Interviews booked on this node, soonest first
+func (sc *InterviewSchedule) List() []*ScheduledInterview {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	interviews := make([]*ScheduledInterview, 0, len(sc.local))
+	for _, interview := range sc.local {
+		interviews = append(interviews, interview)
+	}
+	sort.Slice(interviews, func(a, b int) bool { return interviews[a].StartsAt.Before(interviews[b].StartsAt) })
+	return interviews
+}
+
+// Remove and return local interviews whose window has closed
+func (sc *InterviewSchedule) Expire(now time.Time) []*ScheduledInterview {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	var ended []*ScheduledInterview
+	for code, interview := range sc.local {
+		if interview.Status(now) == InterviewEnded {
+			ended = append(ended, interview)
+			delete(sc.local, code)
+		}
+	}
+	for code, interview := range sc.remote {
+		if interview.Status(now) == InterviewEnded {
+			delete(sc.remote, code)
+		}
+	}
+	return ended
+}
+
+// Pending code for a local interview, created on first use, caller must hold s.mu
+func (s *Server) openScheduled(code string) *PendingCode {
+	if pending := s.pendingCodes[code]; pending != nil {
+		return pending
+	}
+	interview := s.schedule.Local(code)
+	if interview == nil || s.sessions[code] != nil {
+		return nil
+	}
+
+	now := time.Now()
+	pending := &PendingCode{
+		ID:        newSessionID(code, now),
+		CreatedAt: now,
+		Subject:   interview.CreatedBy,
+		Mode:      interview.Mode,
+		Viewers:   make(map[string]*Connection),
+	}
+	s.pendingCodes[code] = pending
+	s.persistPending(code, pending)
+	return pending
+}
+
+// Check if a client already joined the session for code, caller must hold s.mu.
+// Such clients may reconnect after the window closes.
+func (s *Server) clientJoined(code string) bool {
+	session := s.sessions[code]
+	if session == nil {
+		return false
+	}
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+	return session.Client != nil || !session.restoredAt.IsZero()
+}
+
+// Refuse a client registering outside an interview's window
+func (s *Server) rejectOutsideWindow(conn *Connection, interview *ScheduledInterview, now time.Time) {
+	payload := ErrorPayload{Code: ErrOutsideWindow, Message: "This interview has ended"}
+	if interview.Status(now) == InterviewScheduled {
+		wait := interview.StartsAt.Sub(now)
+		payload.Message = fmt.Sprintf("This interview opens at %s", interview.StartsAt.UTC().Format(time.RFC3339))
+		payload.RetryAfter = int(wait.Seconds()) + 1
+	}
+
+	log.Printf("⏰ Client %s tried interview %s outside its window", conn.RemoteIP, interview.Code)
+	s.metrics.RegistrationFailed(ErrOutsideWindow)
+	conn.Send(createSimpleResponseMessage(Error, payload))
+	go func() {
+		time.Sleep(s.config.ErrorCloseDelay)
+		conn.Close()
+	}()
+}
+
+// Drop a code from the active set and the cluster unless a scheduled
+// interview still reserves it, caller must hold s.mu
+func (s *Server) retireCode(code string) {
+	if s.schedule.Reserves(code) {
+		return
+	}
+	delete(s.activeCodes, code)
+	s.releaseCode(code)
+}
+
+// Free the codes of interviews whose window closed, caller must hold s.mu
+func (s *Server) expireScheduled(now time.Time) {
+	for _, interview := range s.schedule.Expire(now) {
+		code := interview.Code
+		log.Printf("🧹 Scheduled interview %s ended", code)
+		s.metrics.Evicted(EvictEndedInterview, 1)
+		s.forgetInterview(code)
+
+		// A running interview keeps its code until everyone leaves
+		if s.sessions[code] != nil {
+			continue
+		}
+		if s.pendingCodes[code] != nil {
+			delete(s.pendingCodes, code)
+			s.forgetPending(code)
+		}
+		s.retireCode(code)
+	}
+}
+
+// Body of POST /api/interviews
+type CreateInterviewRequest struct {
+	StartsAt       time.Time   `json:"startsAt"`
+	EndsAt         time.Time   `json:"endsAt"`
+	CandidateEmail string      `json:"candidateEmail"`
+	Interviewers   []string    `json:"interviewers,omitempty"`
+	Mode           SessionMode `json:"mode,omitempty"`
+}
+
+// Check a booking request for problems, returning a message for the caller
+func (req *CreateInterviewRequest) validate(now time.Time) string {
+	switch {
+	case req.StartsAt.IsZero() || req.EndsAt.IsZero():
+		return "startsAt and endsAt are required"
+	case !req.EndsAt.After(req.StartsAt):
+		return "endsAt must be after startsAt"
+	case !req.EndsAt.After(now):
+		return "endsAt must be in the future"
+	case req.EndsAt.Sub(req.StartsAt) > MAX_INTERVIEW_LENGTH:
+		return fmt.Sprintf("Interviews may last at most %s", MAX_INTERVIEW_LENGTH)
+	case len(req.Interviewers) > MAX_PANEL_SIZE:
+		return fmt.Sprintf("At most %d interviewers may be listed", MAX_PANEL_SIZE)
+	case req.Mode != "" && !req.Mode.IsValid():
+		return "Mode must be mesh or sfu"
+	}
+	if _, err := mail.ParseAddress(req.CandidateEmail); err != nil {
+		return "candidateEmail must be an email address"
+	}
+	for _, interviewer := range req.Interviewers {
+		if strings.TrimSpace(interviewer) == "" {
+			return "Interviewers must not be empty"
+		}
+	}
+	return ""
+}
+
+// Interview in the admin API
+type InterviewDetail struct {
+	*ScheduledInterview
+	Status string `json:"status"`
+	Node   string `json:"node"`
+}
+
+func (s *Server) interviewDetail(interview *ScheduledInterview) InterviewDetail {
+	return InterviewDetail{
+		ScheduledInterview: interview,
+		Status:             interview.Status(time.Now()),
+		Node:               s.nodeID,
+	}
+}
+
+// Handle GET and POST /api/interviews
+func (s *Server) handleAPIInterviews(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		interviews := s.schedule.List()
+		details := make([]InterviewDetail, 0, len(interviews))
+		for _, interview := range interviews {
+			if claims.Admin || interview.Allows(claims.Subject) {
+				details = append(details, s.interviewDetail(interview))
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"interviews": details})
+
+	case http.MethodPost:
+		var req CreateInterviewRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, ErrInvalidPayload, "Invalid request body")
+			return
+		}
+		if problem := req.validate(time.Now()); problem != "" {
+			writeAPIError(w, http.StatusBadRequest, ErrInvalidPayload, problem)
+			return
+		}
+
+		code, err := s.generateUniqueCode()
+		if err != nil {
+			log.Printf("Error generating code: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, ErrInternal, "Could not generate code")
+			return
+		}
+
+		interview := &ScheduledInterview{
+			Code:           code,
+			StartsAt:       req.StartsAt,
+			EndsAt:         req.EndsAt,
+			CandidateEmail: req.CandidateEmail,
+			Interviewers:   req.Interviewers,
+			CreatedBy:      claims.Subject,
+			CreatedAt:      time.Now(),
+			Mode:           s.sessionMode(req.Mode),
+		}
+		if interview.Interviewers == nil {
+			interview.Interviewers = []string{}
+		}
+		s.schedule.Add(interview)
+		s.persistInterview(interview)
+		s.holdCode(code)
+
+		log.Printf("📅 %s scheduled interview %s from %s to %s", claims.Subject, code,
+			interview.StartsAt.Format(time.RFC3339), interview.EndsAt.Format(time.RFC3339))
+		writeJSON(w, http.StatusCreated, s.interviewDetail(interview))
+
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, ErrInvalidMessage, "Method not allowed")
+	}
+}
+
+// Handle GET and DELETE /api/interviews/{code}
+func (s *Server) handleAPIInterview(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+
+	code := s.codeFormat.Normalize(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/interviews/"), "/"))
+	interview := s.schedule.Local(code)
+	if interview == nil {
+		writeAPIError(w, http.StatusNotFound, ErrInvalidCode, "No scheduled interview with this code on this node")
+		return
+	}
+
+	// The panel may look at an interview, only its creator may cancel it
+	allowed := interview.Allows(claims.Subject)
+	if r.Method == http.MethodDelete {
+		allowed = interview.CreatedBy == claims.Subject
+	}
+	if !allowed && !claims.Admin {
+		log.Printf("🚫 Refused %s of interview %s to %s", r.Method, code, claims.Subject)
+		writeAPIError(w, http.StatusForbidden, ErrForbidden, "Not on the panel for this interview")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.interviewDetail(interview))
+
+	case http.MethodDelete:
+		s.schedule.Remove(code)
+		s.forgetInterview(code)
+		log.Printf("📅 %s cancelled interview %s", claims.Subject, code)
+
+		// Disconnect anyone already in it, otherwise just free the code
+		if s.endLocalSession(code, "Interview cancelled") {
+			s.publishSessionEvent(code, clusterEvent{Kind: clusterSessionEnded, Reason: "Interview cancelled"})
+		} else {
+			s.mu.Lock()
+			s.retireCode(code)
+			s.mu.Unlock()
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, ErrInvalidMessage, "Method not allowed")
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:generate go run . schema protocol.schema.json
+
+// Allowed values for string types that appear in payloads
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(Role("")):              {string(ClientRole), string(ViewerRole)},
+	reflect.TypeOf(PanelRole("")):         {string(LeadRole), string(CoInterviewerRole), string(ObserverRole), string(RecorderRole)},
+	reflect.TypeOf(SDPType("")):           {string(SDPOffer), string(SDPAnswer), string(SDPPranswer), string(SDPRollback)},
+	reflect.TypeOf(ErrorCode("")):         errorCodeNames(),
+	reflect.TypeOf(SessionMode("")):       {string(MeshMode), string(SFUMode)},
+	reflect.TypeOf(DisplayChangeKind("")): displayChangeKindNames(),
+	reflect.TypeOf(RefreshType("")):       {string(RefreshAuto), string(RefreshManual)},
+}
+
+var (
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	timeType       = reflect.TypeOf(time.Time{})
+)
+
+func errorCodeNames() []string {
+	names := make([]string, len(errorCodes))
+	for i, code := range errorCodes {
+		names[i] = string(code)
+	}
+	return names
+}
+
+// Builds JSON Schema definitions from Go types
+type schemaBuilder struct {
+	defs map[string]interface{}
+}
+
+// Schema for a Go type, named structs become $defs references
+func (b *schemaBuilder) schemaFor(t reflect.Type) map[string]interface{} {
+	if t == rawMessageType || t.Kind() == reflect.Interface {
+		return map[string]interface{}{}
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	if values, ok := schemaEnums[t]; ok {
+		return map[string]interface{}{"type": "string", "enum": values}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.schemaFor(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schemaFor(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, done := b.defs[name]; !done {
+			b.defs[name] = nil // placeholder so recursive types terminate
+			b.defs[name] = b.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + name}
+	}
+
+	return map[string]interface{}{}
+}
+
+// Object schema for a struct, fields without omitempty are required
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+
+		property := b.schemaFor(field.Type)
+		if field.Type.Kind() == reflect.Ptr && !strings.Contains(options, "omitempty") {
+			// Required but may be null, like the primary display
+			property = map[string]interface{}{"anyOf": []interface{}{property, map[string]interface{}{"type": "null"}}}
+		}
+		properties[name] = property
+
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// Envelope schema for one message type in one direction
+func (b *schemaBuilder) envelope(messageType MessageType, payload interface{}, clientToServer bool) map[string]interface{} {
+	properties := map[string]interface{}{
+		"type": map[string]interface{}{"const": string(messageType)},
+	}
+	required := []string{"type"}
+
+	if payload != nil {
+		properties["payload"] = b.schemaFor(reflect.TypeOf(payload))
+	}
+
+	if clientToServer {
+		properties["code"] = map[string]interface{}{"type": "string"}
+		properties["role"] = b.schemaFor(reflect.TypeOf(Role("")))
+		properties["target"] = map[string]interface{}{"type": "string"}
+		if messageType != RequestCode {
+			required = append(required, "code")
+		}
+		if messageType == Register {
+			required = append(required, "role")
+		}
+	} else {
+		properties["timestamp"] = map[string]interface{}{"type": "integer"}
+		properties["from"] = map[string]interface{}{"type": "string"}
+	}
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// JSON Schema describing every message in both directions
+func protocolSchema() map[string]interface{} {
+	b := &schemaBuilder{defs: map[string]interface{}{}}
+
+	clientTypes := make([]string, 0, len(clientPayloads))
+	for messageType := range clientPayloads {
+		clientTypes = append(clientTypes, string(messageType))
+	}
+	sort.Strings(clientTypes)
+
+	clientMessages := []interface{}{}
+	for _, name := range clientTypes {
+		messageType := MessageType(name)
+		var payload interface{}
+		if factory := clientPayloads[messageType]; factory != nil {
+			payload = factory()
+		}
+		defName := "ClientMessage_" + name
+		b.defs[defName] = b.envelope(messageType, payload, true)
+		clientMessages = append(clientMessages, map[string]interface{}{"$ref": "#/$defs/" + defName})
+	}
+
+	serverTypes := make([]string, 0, len(serverPayloads))
+	for messageType := range serverPayloads {
+		serverTypes = append(serverTypes, string(messageType))
+	}
+	sort.Strings(serverTypes)
+
+	serverMessages := []interface{}{}
+	for _, name := range serverTypes {
+		messageType := MessageType(name)
+		defName := "ServerMessage_" + name
+		b.defs[defName] = b.envelope(messageType, serverPayloads[messageType], false)
+		serverMessages = append(serverMessages, map[string]interface{}{"$ref": "#/$defs/" + defName})
+	}
+
+	b.defs["ClientMessage"] = map[string]interface{}{"oneOf": clientMessages}
+	b.defs["ServerMessage"] = map[string]interface{}{"oneOf": serverMessages}
+
+	return map[string]interface{}{
+		"$schema":            "https://json-schema.org/draft/2020-12/schema",
+		"title":              "InterView signaling protocol",
+		"protocolVersion":    PROTOCOL_VERSION,
+		"minProtocolVersion": MIN_PROTOCOL_VERSION,
+		"oneOf": []interface{}{
+			map[string]interface{}{"$ref": "#/$defs/ClientMessage"},
+			map[string]interface{}{"$ref": "#/$defs/ServerMessage"},
+		},
+		"$defs": b.defs,
+	}
+}
+
+// Handle GET /protocol/schema.json
+func (s *Server) handleSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(protocolSchema())
+}
+
+// Handle the schema command line mode, writing to a file or stdout
+func runSchema(args []string) {
+	data, err := json.MarshalIndent(protocolSchema(), "", "  ")
+	if err != nil {
+		log.Fatal("Failed to build schema:", err)
+	}
+	data = append(data, '\n')
+
+	if len(args) == 0 {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(args[0], data, 0644); err != nil {
+		log.Fatal("Failed to write schema:", err)
+	}
+	log.Printf("✅ Wrote protocol schema to %s", args[0])
+}
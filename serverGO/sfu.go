@@ -0,0 +1,546 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// How the client's screen reaches the viewers
+type SessionMode string
+
+const (
+	MeshMode SessionMode = "mesh" // the client opens a peer connection to every viewer
+	SFUMode  SessionMode = "sfu"  // the client publishes once and the server forwards to viewers
+)
+
+// Check if the mode is one the server supports
+func (m SessionMode) IsValid() bool {
+	return m == MeshMode || m == SFUMode
+}
+
+// SFU defaults
+const (
+	SFU_EVENT_BUFFER     = 256                    // queued signals and track events before the SFU drops them
+	SFU_PACKET_SIZE      = 1500                   // largest RTP packet forwarded
+	SFU_KEYFRAME_SPACING = 500 * time.Millisecond // minimum time between keyframe requests per track
+)
+
+// Work items for the SFU loop
+const (
+	sfuClientSignal = iota
+	sfuViewerSignal
+	sfuSubscribe
+	sfuUnsubscribe
+	sfuTrackStarted
+	sfuTrackEnded
+	sfuKeyframe
+)
+
+type sfuEvent struct {
+	kind    int
+	peer    string // viewer ID for viewer events
+	payload json.RawMessage
+	send    func(ResponseMessage)
+	pc      *webrtc.PeerConnection // publisher the track arrived on
+	remote  *webrtc.TrackRemote
+	track   *sfuTrack
+	ssrc    webrtc.SSRC
+}
+
+// Local track fanned out to every subscriber, fed by one of the client's tracks
+type sfuTrack struct {
+	local        *webrtc.TrackLocalStaticRTP
+	ssrc         webrtc.SSRC // of the remote track feeding it, 0 while idle
+	lastKeyframe time.Time
+}
+
+// Viewer receiving the forwarded tracks
+type sfuSubscriber struct {
+	id          string
+	send        func(ResponseMessage)
+	pc          *webrtc.PeerConnection
+	candidates  []webrtc.ICECandidateInit // received before the answer
+	renegotiate bool                      // tracks changed while an offer was outstanding
+}
+
+// Selective forwarding unit for one session. The client publishes its tracks
+// to the SFU like to any viewer and the SFU offers them to each viewer.
+// All peer connection state is owned by the run goroutine.
+type SFU struct {
+	ID          string
+	code        string
+	sendClient  func(ResponseMessage)
+	events      chan sfuEvent
+	publisher   *webrtc.PeerConnection
+	candidates  []webrtc.ICECandidateInit // client candidates received before its offer
+	tracks      []*sfuTrack
+	subscribers map[string]*sfuSubscriber
+	closed      bool
+	mu          sync.Mutex // guards closed and sends on events
+}
+
+// Create an SFU for a session, sendClient delivers signals to the client
+func NewSFU(code string, sendClient func(ResponseMessage)) *SFU {
+	f := &SFU{
+		ID:          "sfu-" + code,
+		code:        code,
+		sendClient:  sendClient,
+		events:      make(chan sfuEvent, SFU_EVENT_BUFFER),
+		subscribers: make(map[string]*sfuSubscriber),
+	}
+	go f.run()
+	return f
+}
+
+// Queue a signal from the client
+func (f *SFU) ClientSignal(payload json.RawMessage) {
+	f.post(sfuEvent{kind: sfuClientSignal, payload: payload})
+}
+
+// Queue a signal from a viewer
+func (f *SFU) ViewerSignal(viewerID string, payload json.RawMessage) {
+	f.post(sfuEvent{kind: sfuViewerSignal, peer: viewerID, payload: payload})
+}
+
+// Start forwarding to a viewer, restarting its peer connection if it has one
+func (f *SFU) Subscribe(viewerID string, send func(ResponseMessage)) {
+	f.post(sfuEvent{kind: sfuSubscribe, peer: viewerID, send: send})
+}
+
+// Stop forwarding to a viewer
+func (f *SFU) Unsubscribe(viewerID string) {
+	f.post(sfuEvent{kind: sfuUnsubscribe, peer: viewerID})
+}
+
+// Close every peer connection
+func (f *SFU) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	f.closed = true
+	close(f.events)
+}
+
+// Queue an event, never blocks the caller
+func (f *SFU) post(event sfuEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return
+	}
+
+	select {
+	case f.events <- event:
+	default:
+		log.Printf("⚠️ SFU for %s is falling behind, dropping event", f.code)
+	}
+}
+
+// Apply events in order, then tear down
+func (f *SFU) run() {
+	for event := range f.events {
+		var err error
+		switch event.kind {
+		case sfuClientSignal:
+			err = f.handleClientSignal(event.payload)
+		case sfuViewerSignal:
+			err = f.handleViewerSignal(event.peer, event.payload)
+		case sfuSubscribe:
+			err = f.subscribe(event.peer, event.send)
+		case sfuUnsubscribe:
+			f.unsubscribe(event.peer)
+		case sfuTrackStarted:
+			f.trackStarted(event.pc, event.remote)
+		case sfuTrackEnded:
+			if event.track.ssrc == event.ssrc {
+				event.track.ssrc = 0
+			}
+		case sfuKeyframe:
+			f.requestKeyframe(event.track)
+		}
+		if err != nil {
+			log.Printf("⚠️ SFU for %s: %v", f.code, err)
+		}
+	}
+
+	if f.publisher != nil {
+		f.publisher.Close()
+	}
+	for _, subscriber := range f.subscribers {
+		subscriber.pc.Close()
+	}
+}
+
+// Signal kinds the SFU understands
+type sfuSignal struct {
+	Type      string          `json:"type"`
+	Candidate json.RawMessage `json:"candidate"`
+}
+
+func parseSFUSignal(payload json.RawMessage) (sfuSignal, error) {
+	var signal sfuSignal
+	if err := json.Unmarshal(payload, &signal); err != nil {
+		return signal, fmt.Errorf("malformed signal: %w", err)
+	}
+	return signal, nil
+}
+
+func (f *SFU) handleClientSignal(payload json.RawMessage) error {
+	signal, err := parseSFUSignal(payload)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case signal.Type == "offer":
+		var offer webrtc.SessionDescription
+		if err := json.Unmarshal(payload, &offer); err != nil {
+			return fmt.Errorf("malformed offer: %w", err)
+		}
+		return f.answerClient(offer)
+
+	case signal.Candidate != nil:
+		var candidate webrtc.ICECandidateInit
+		if err := json.Unmarshal(payload, &candidate); err != nil {
+			return fmt.Errorf("malformed ICE candidate: %w", err)
+		}
+		if f.publisher == nil || f.publisher.RemoteDescription() == nil {
+			f.candidates = append(f.candidates, candidate)
+			return nil
+		}
+		return f.publisher.AddICECandidate(candidate)
+	}
+
+	return nil
+}
+
+// Answer the client's offer on a fresh peer connection, replacing any earlier one
+func (f *SFU) answerClient(offer webrtc.SessionDescription) error {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return fmt.Errorf("creating peer connection: %w", err)
+	}
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate != nil {
+			f.sendClient(f.signal(candidate.ToJSON()))
+		}
+	})
+	pc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		f.post(sfuEvent{kind: sfuTrackStarted, pc: pc, remote: remote})
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Printf("📡 SFU publisher for %s is %s", f.code, state)
+	})
+
+	// A new offer means the client restarted its side, its new tracks take
+	// over the existing local ones
+	if f.publisher != nil {
+		f.publisher.Close()
+	}
+	for _, track := range f.tracks {
+		track.ssrc = 0
+	}
+	f.publisher = pc
+	candidates := f.candidates
+	f.candidates = nil
+
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		return fmt.Errorf("applying offer: %w", err)
+	}
+	for _, candidate := range candidates {
+		if err := pc.AddICECandidate(candidate); err != nil {
+			log.Printf("⚠️ SFU for %s rejected client ICE candidate: %v", f.code, err)
+		}
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return fmt.Errorf("creating answer: %w", err)
+	}
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return fmt.Errorf("applying answer: %w", err)
+	}
+
+	f.sendClient(f.signal(answer))
+	return nil
+}
+
+// Bind a new client track to a local track, reusing an idle one of the same
+// kind so viewers keep their streams across client restarts
+func (f *SFU) trackStarted(pc *webrtc.PeerConnection, remote *webrtc.TrackRemote) {
+	if pc != f.publisher {
+		return
+	}
+
+	codec := remote.Codec().RTPCodecCapability
+	var track *sfuTrack
+	for _, candidate := range f.tracks {
+		if candidate.ssrc == 0 && candidate.local.Kind() == remote.Kind() &&
+			strings.EqualFold(candidate.local.Codec().MimeType, codec.MimeType) {
+			track = candidate
+			break
+		}
+	}
+
+	if track == nil {
+		local, err := webrtc.NewTrackLocalStaticRTP(codec, remote.ID(), remote.StreamID())
+		if err != nil {
+			log.Printf("Error creating forwarded track for %s: %v", f.code, err)
+			return
+		}
+		track = &sfuTrack{local: local}
+		f.tracks = append(f.tracks, track)
+
+		for _, subscriber := range f.subscribers {
+			if err := f.addTrack(subscriber, track); err != nil {
+				log.Printf("⚠️ SFU for %s could not add track for %s: %v", f.code, subscriber.id, err)
+				continue
+			}
+			f.negotiate(subscriber)
+		}
+	}
+	track.ssrc = remote.SSRC()
+
+	log.Printf("📡 SFU for %s forwarding %s track to %d viewer(s)", f.code, codec.MimeType, len(f.subscribers))
+
+	go f.forward(track, remote)
+	f.requestKeyframe(track)
+}
+
+// Copy packets from a client track to its local track until it ends
+func (f *SFU) forward(track *sfuTrack, remote *webrtc.TrackRemote) {
+	buffer := make([]byte, SFU_PACKET_SIZE)
+	for {
+		n, _, err := remote.Read(buffer)
+		if err != nil {
+			break
+		}
+		if _, err := track.local.Write(buffer[:n]); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			log.Printf("Error forwarding packet for %s: %v", f.code, err)
+			break
+		}
+	}
+	f.post(sfuEvent{kind: sfuTrackEnded, track: track, ssrc: remote.SSRC()})
+}
+
+// Ask the client for a keyframe so a viewer can start decoding
+func (f *SFU) requestKeyframe(track *sfuTrack) {
+	if f.publisher == nil || track.ssrc == 0 || time.Since(track.lastKeyframe) < SFU_KEYFRAME_SPACING {
+		return
+	}
+	track.lastKeyframe = time.Now()
+	pli := &rtcp.PictureLossIndication{MediaSSRC: uint32(track.ssrc)}
+	if err := f.publisher.WriteRTCP([]rtcp.Packet{pli}); err != nil {
+		log.Printf("⚠️ SFU for %s could not request keyframe: %v", f.code, err)
+	}
+}
+
+// Open a peer connection to a viewer carrying every forwarded track
+func (f *SFU) subscribe(viewerID string, send func(ResponseMessage)) error {
+	f.unsubscribe(viewerID)
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return fmt.Errorf("creating peer connection for %s: %w", viewerID, err)
+	}
+	subscriber := &sfuSubscriber{id: viewerID, send: send, pc: pc}
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate != nil {
+			send(f.signal(candidate.ToJSON()))
+		}
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Printf("📡 SFU subscriber %s for %s is %s", viewerID, f.code, state)
+	})
+
+	for _, track := range f.tracks {
+		if err := f.addTrack(subscriber, track); err != nil {
+			pc.Close()
+			return fmt.Errorf("adding track for %s: %w", viewerID, err)
+		}
+	}
+	f.subscribers[viewerID] = subscriber
+
+	// Offer once there is something to watch
+	if len(f.tracks) > 0 {
+		f.negotiate(subscriber)
+	}
+	return nil
+}
+
+// Add a track to a subscriber and pass its keyframe requests to the client
+func (f *SFU) addTrack(subscriber *sfuSubscriber, track *sfuTrack) error {
+	sender, err := subscriber.pc.AddTrack(track.local)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			packets, _, err := sender.ReadRTCP()
+			if err != nil {
+				return
+			}
+			for _, packet := range packets {
+				switch packet.(type) {
+				case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+					f.post(sfuEvent{kind: sfuKeyframe, track: track})
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Send a subscriber a new offer, or note that one is due once the current one is answered
+func (f *SFU) negotiate(subscriber *sfuSubscriber) {
+	if subscriber.pc.SignalingState() != webrtc.SignalingStateStable {
+		subscriber.renegotiate = true
+		return
+	}
+	subscriber.renegotiate = false
+
+	offer, err := subscriber.pc.CreateOffer(nil)
+	if err != nil {
+		log.Printf("⚠️ SFU for %s could not create offer for %s: %v", f.code, subscriber.id, err)
+		return
+	}
+	if err := subscriber.pc.SetLocalDescription(offer); err != nil {
+		log.Printf("⚠️ SFU for %s could not apply offer for %s: %v", f.code, subscriber.id, err)
+		return
+	}
+	subscriber.send(f.signal(offer))
+}
+
+func (f *SFU) handleViewerSignal(viewerID string, payload json.RawMessage) error {
+	subscriber := f.subscribers[viewerID]
+	if subscriber == nil {
+		return fmt.Errorf("signal from %s, which is not subscribed", viewerID)
+	}
+
+	signal, err := parseSFUSignal(payload)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case signal.Type == "answer":
+		var answer webrtc.SessionDescription
+		if err := json.Unmarshal(payload, &answer); err != nil {
+			return fmt.Errorf("malformed answer: %w", err)
+		}
+		if err := subscriber.pc.SetRemoteDescription(answer); err != nil {
+			return fmt.Errorf("applying answer from %s: %w", viewerID, err)
+		}
+		for _, candidate := range subscriber.candidates {
+			if err := subscriber.pc.AddICECandidate(candidate); err != nil {
+				log.Printf("⚠️ SFU for %s rejected ICE candidate from %s: %v", f.code, viewerID, err)
+			}
+		}
+		subscriber.candidates = nil
+
+		if subscriber.renegotiate {
+			f.negotiate(subscriber)
+		}
+		for _, track := range f.tracks {
+			f.requestKeyframe(track)
+		}
+
+	case signal.Type == "offer":
+		return fmt.Errorf("ignoring offer from %s, the SFU makes the offers", viewerID)
+
+	case signal.Candidate != nil:
+		var candidate webrtc.ICECandidateInit
+		if err := json.Unmarshal(payload, &candidate); err != nil {
+			return fmt.Errorf("malformed ICE candidate: %w", err)
+		}
+		if subscriber.pc.RemoteDescription() == nil {
+			subscriber.candidates = append(subscriber.candidates, candidate)
+			return nil
+		}
+		return subscriber.pc.AddICECandidate(candidate)
+	}
+
+	return nil
+}
+
+func (f *SFU) unsubscribe(viewerID string) {
+	if subscriber := f.subscribers[viewerID]; subscriber != nil {
+		subscriber.pc.Close()
+		delete(f.subscribers, viewerID)
+	}
+}
+
+// Signal message sent on behalf of the SFU
+func (f *SFU) signal(payload interface{}) ResponseMessage {
+	response := createResponseMessage(Signal, payload)
+	response.From = f.ID
+	return response
+}
+
+// Request for the client to publish to the SFU
+func (f *SFU) connectMessage(message string) ResponseMessage {
+	response := createSimpleResponseMessage(Connect, ConnectPayload{
+		Timestamp: getCurrentTimestamp(),
+		Message:   message,
+	})
+	response.From = f.ID
+	return response
+}
+
+// Start the session's SFU and subscribe everyone already watching, caller must hold session.mu
+func (s *Server) startSFU(code string, session *Session) {
+	if session.sfu != nil {
+		return
+	}
+	session.sfu = NewSFU(code, func(response ResponseMessage) {
+		s.sendToClient(code, session, response)
+	})
+	for _, viewer := range session.openViewers() {
+		s.subscribeViewer(code, session, viewer)
+	}
+	for id := range session.remoteViewers {
+		s.subscribeRemoteViewer(code, session, id)
+	}
+	log.Printf("📡 Session %s forwarding through the SFU", code)
+}
+
+// Forward the session's tracks to a local viewer, caller must hold session.mu
+func (s *Server) subscribeViewer(code string, session *Session, viewer *Connection) {
+	session.sfu.Subscribe(viewer.ID, func(response ResponseMessage) {
+		if viewer.IsOpen() {
+			viewer.Send(response)
+			s.metrics.MessageRelayed(response.Type, 1)
+		}
+	})
+}
+
+// Forward the session's tracks to a viewer on another node, caller must hold session.mu
+func (s *Server) subscribeRemoteViewer(code string, session *Session, viewerID string) {
+	session.sfu.Subscribe(viewerID, func(response ResponseMessage) {
+		s.relayRemote(code, ViewerRole, viewerID, response)
+		s.metrics.MessageRelayed(response.Type, 1)
+	})
+}
+
+// Mode for new codes, falling back to the server default
+func (s *Server) sessionMode(requested SessionMode) SessionMode {
+	if requested != "" {
+		return requested
+	}
+	return SessionMode(s.config.SessionMode)
+}
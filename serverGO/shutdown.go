@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Shutdown defaults
+const (
+	DEFAULT_SHUTDOWN_DRAIN = 10 * time.Second       // time peers get to wrap up before sockets close
+	SHUTDOWN_POLL_INTERVAL = 250 * time.Millisecond // how often draining checks for remaining peers
+	SHUTDOWN_HTTP_TIMEOUT  = 5 * time.Second        // wait for in-flight HTTP requests
+)
+
+// Whether the server has started shutting down
+func (s *Server) isDraining() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.draining
+}
+
+// Shut down on SIGTERM or SIGINT, closing done once finished
+func (s *Server) handleShutdownSignals(done chan<- struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		log.Printf("🛑 Received %s, shutting down", sig)
+		s.Shutdown()
+		close(done)
+	}()
+}
+
+// Drain every connection and stop the server. Session state stays in the
+// store and codes stay held so peers can resume after the restart.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	if s.draining {
+		s.mu.Unlock()
+		return
+	}
+	s.draining = true
+	conns := make([]*Connection, 0, len(s.connections))
+	for _, conn := range s.connections {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+
+	// Tell every peer to come back after the restart
+	notice := createSimpleResponseMessage(ServerShutdown, ServerShutdownPayload{
+		Timestamp:      getCurrentTimestamp(),
+		Reason:         "Server is restarting",
+		Reconnect:      true,
+		ReconnectAfter: int(s.config.ShutdownDrain.Seconds()) + 1,
+		ClosesIn:       int(s.config.ShutdownDrain.Seconds()),
+	})
+	for _, conn := range conns {
+		if conn.IsOpen() {
+			conn.Send(notice)
+		}
+	}
+	log.Printf("🛑 Draining %d connection(s) for up to %s", len(conns), s.config.ShutdownDrain)
+
+	// Wait out the drain period, or less if everyone has already left
+	deadline := time.Now().Add(s.config.ShutdownDrain)
+	for time.Now().Before(deadline) && s.connectionCount() > 0 {
+		time.Sleep(SHUTDOWN_POLL_INTERVAL)
+	}
+
+	// Stop periodic cleanup so nothing expires while we tear down
+	close(s.stop)
+
+	// Persist the latest session info, finalize recordings and stop forwarding
+	s.flushDirtySessions()
+	s.mu.RLock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.mu.RUnlock()
+	for _, session := range sessions {
+		session.mu.Lock()
+		recorder := session.recorder
+		session.recorder = nil
+		if session.detector != nil {
+			session.detector.stop()
+			session.detector = nil
+		}
+		if session.sfu != nil {
+			session.sfu.Close()
+			session.sfu = nil
+		}
+		session.mu.Unlock()
+		if recorder != nil {
+			recorder.Close()
+		}
+	}
+
+	s.mu.RLock()
+	remaining := make([]*Connection, 0, len(s.connections))
+	for _, conn := range s.connections {
+		remaining = append(remaining, conn)
+	}
+	s.mu.RUnlock()
+	for _, conn := range remaining {
+		conn.CloseWithCode(websocket.CloseServiceRestart, "Server restarting")
+	}
+	if len(remaining) > 0 {
+		log.Printf("🔌 Closed %d remaining connection(s)", len(remaining))
+	}
+
+	for _, server := range []*http.Server{s.httpServer, s.redirectServer} {
+		if server == nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_HTTP_TIMEOUT)
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down HTTP server: %v", err)
+		}
+		cancel()
+	}
+
+	if s.relay != nil {
+		if err := s.relay.Close(); err != nil {
+			log.Printf("Error closing TURN server: %v", err)
+		}
+	}
+	if s.audit != nil {
+		s.audit.Close()
+	}
+	if err := s.store.Close(); err != nil {
+		log.Printf("Error closing session store: %v", err)
+	}
+	s.outbox.Close(CLUSTER_OUTBOX_FLUSH_WAIT)
+	if err := s.backplane.Close(); err != nil {
+		log.Printf("Error closing backplane: %v", err)
+	}
+
+	log.Printf("👋 Shutdown complete")
+}
+
+// Number of open WebSocket connections
+func (s *Server) connectionCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.connections)
+}
+
+// Give a final error time to reach the peer, then close with code
+func (s *Server) closeAfterError(conn *Connection, code int, text string) {
+	time.Sleep(s.config.ErrorCloseDelay)
+	conn.CloseWithCode(code, text)
+}
+
+// Send a close frame with the given code, then close the socket
+func (c *Connection) CloseWithCode(code int, text string) {
+	c.mu.Lock()
+	ws := c.WS
+	c.mu.Unlock()
+
+	if ws != nil {
+		message := websocket.FormatCloseMessage(code, text)
+		ws.WriteControl(websocket.CloseMessage, message, time.Now().Add(CONTROL_WRITE_WAIT))
+	}
+	c.Close()
+}
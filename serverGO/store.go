@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Session store settings
+const (
+	DEFAULT_STORE_PATH   = "sessions.journal"
+	STORE_FLUSH_INTERVAL = 5 * time.Second // how often changed SessionInfo is persisted
+)
+
+// Session store kinds
+const (
+	StoreMemory = "memory" // state is lost on restart
+	StoreFile   = "file"   // JSON lines journal at sessionStorePath
+)
+
+// Persisted pending code
+type PendingRecord struct {
+	Code      string      `json:"code"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Subject   string      `json:"subject,omitempty"`
+	Mode      SessionMode `json:"mode,omitempty"`
+}
+
+// Persisted session metadata
+type SessionRecord struct {
+	Code      string       `json:"code"`
+	Info      *SessionInfo `json:"info"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+}
+
+// Everything a store holds, used to restore state at startup
+type StoreSnapshot struct {
+	Pending    []PendingRecord
+	Sessions   []SessionRecord
+	Interviews []ScheduledInterview
+}
+
+// SessionStore persists pending codes, session metadata and scheduled
+// interviews across restarts.
+// Live connections are never stored, peers re-register after a restart.
+type SessionStore interface {
+	SavePending(record PendingRecord) error
+	DeletePending(code string) error
+	SaveSession(record SessionRecord) error
+	DeleteSession(code string) error
+	SaveInterview(interview ScheduledInterview) error
+	DeleteInterview(code string) error
+	Load() (*StoreSnapshot, error)
+	Close() error
+}
+
+// Open the store selected by the sessionStore setting, journal lines are
+// bounded by maxBuffer
+func NewSessionStore(c *Config) (SessionStore, error) {
+	switch c.SessionStore {
+	case StoreMemory:
+		return NewMemorySessionStore(), nil
+	case StoreFile:
+		return OpenFileSessionStore(c.SessionStorePath, c.MaxBuffer)
+	default:
+		return nil, fmt.Errorf("unknown session store %q", c.SessionStore)
+	}
+}
+
+// In-memory session store, state is lost on restart
+type MemorySessionStore struct {
+	pending    map[string]PendingRecord
+	sessions   map[string]SessionRecord
+	interviews map[string]ScheduledInterview
+	mu         sync.Mutex
+}
+
+// Create new in-memory session store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		pending:    make(map[string]PendingRecord),
+		sessions:   make(map[string]SessionRecord),
+		interviews: make(map[string]ScheduledInterview),
+	}
+}
+
+func (m *MemorySessionStore) SavePending(record PendingRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending[record.Code] = record
+	return nil
+}
+
+func (m *MemorySessionStore) DeletePending(code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.pending, code)
+	return nil
+}
+
+func (m *MemorySessionStore) SaveSession(record SessionRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[record.Code] = record
+	return nil
+}
+
+func (m *MemorySessionStore) DeleteSession(code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, code)
+	return nil
+}
+
+func (m *MemorySessionStore) SaveInterview(interview ScheduledInterview) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.interviews[interview.Code] = interview
+	return nil
+}
+
+func (m *MemorySessionStore) DeleteInterview(code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.interviews, code)
+	return nil
+}
+
+func (m *MemorySessionStore) Load() (*StoreSnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := &StoreSnapshot{}
+	for _, record := range m.pending {
+		snapshot.Pending = append(snapshot.Pending, record)
+	}
+	for _, record := range m.sessions {
+		snapshot.Sessions = append(snapshot.Sessions, record)
+	}
+	for _, interview := range m.interviews {
+		snapshot.Interviews = append(snapshot.Interviews, interview)
+	}
+	return snapshot, nil
+}
+
+func (m *MemorySessionStore) Close() error {
+	return nil
+}
+
+// Persist a pending code, logging rather than failing the request
+func (s *Server) persistPending(code string, pending *PendingCode) {
+	err := s.store.SavePending(PendingRecord{
+		Code:      code,
+		CreatedAt: pending.CreatedAt,
+		Subject:   pending.Subject,
+		Mode:      pending.Mode,
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to persist pending code %s: %v", code, err)
+	}
+}
+
+// Remove a pending code from the store
+func (s *Server) forgetPending(code string) {
+	if err := s.store.DeletePending(code); err != nil {
+		log.Printf("Warning: Failed to remove pending code %s from store: %v", code, err)
+	}
+}
+
+// Persist a scheduled interview
+func (s *Server) persistInterview(interview *ScheduledInterview) {
+	if err := s.store.SaveInterview(*interview); err != nil {
+		log.Printf("Warning: Failed to persist interview %s: %v", interview.Code, err)
+	}
+}
+
+// Remove a scheduled interview from the store
+func (s *Server) forgetInterview(code string) {
+	if err := s.store.DeleteInterview(code); err != nil {
+		log.Printf("Warning: Failed to remove interview %s from store: %v", code, err)
+	}
+}
+
+// Persist session metadata, caller must hold session.mu. The process list
+// is left out, clients report a fresh one after a restart.
+func (s *Server) persistSession(code string, session *Session) {
+	if session.Info == nil {
+		return
+	}
+
+	info := *session.Info
+	info.ProcessInfo = nil
+	session.dirty = false
+
+	err := s.store.SaveSession(SessionRecord{
+		Code:      code,
+		Info:      &info,
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to persist session %s: %v", code, err)
+	}
+}
+
+// Remove a session from the store
+func (s *Server) forgetSession(code string) {
+	if err := s.store.DeleteSession(code); err != nil {
+		log.Printf("Warning: Failed to remove session %s from store: %v", code, err)
+	}
+}
+
+// Persist sessions whose info changed since the last flush
+func (s *Server) flushDirtySessions() {
+	s.mu.RLock()
+	sessions := make(map[string]*Session, len(s.sessions))
+	for code, session := range s.sessions {
+		sessions[code] = session
+	}
+	s.mu.RUnlock()
+
+	for code, session := range sessions {
+		session.mu.Lock()
+		if session.dirty {
+			s.persistSession(code, session)
+		}
+		session.mu.Unlock()
+	}
+}
+
+// Start the routine that persists changed session info
+func (s *Server) startStoreFlushRoutine() {
+	ticker := time.NewTicker(STORE_FLUSH_INTERVAL)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.flushDirtySessions()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Restore pending codes and sessions saved before a restart
+func (s *Server) restoreFromStore() error {
+	snapshot, err := s.store.Load()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	interviews := 0
+	for i := range snapshot.Interviews {
+		interview := &snapshot.Interviews[i]
+		if interview.Status(now) == InterviewEnded {
+			s.forgetInterview(interview.Code)
+			continue
+		}
+		s.schedule.Add(interview)
+		s.activeCodes[interview.Code] = true
+		s.holdCode(interview.Code)
+		interviews++
+	}
+
+	for _, record := range snapshot.Pending {
+		if now.Sub(record.CreatedAt) > s.config.PendingCodeTTL {
+			s.forgetPending(record.Code)
+			continue
+		}
+		s.pendingCodes[record.Code] = &PendingCode{
+			ID:        newSessionID(record.Code, record.CreatedAt),
+			CreatedAt: record.CreatedAt,
+			Subject:   record.Subject,
+			Mode:      record.Mode,
+			Viewers:   make(map[string]*Connection),
+		}
+		s.activeCodes[record.Code] = true
+		s.holdCode(record.Code)
+	}
+
+	for _, record := range snapshot.Sessions {
+		if record.Info == nil {
+			record.Info = &SessionInfo{CreatedAt: now}
+		}
+		if record.Info.ID == "" {
+			record.Info.ID = newSessionID(record.Code, record.Info.CreatedAt)
+		}
+		s.sessions[record.Code] = &Session{
+			Viewers:    make(map[string]*Connection),
+			Info:       record.Info,
+			restoredAt: now,
+		}
+		s.activeCodes[record.Code] = true
+		s.holdCode(record.Code)
+	}
+
+	if len(snapshot.Pending) > 0 || len(snapshot.Sessions) > 0 {
+		log.Printf("♻️ Restored %d pending code(s) and %d session(s) from store", len(s.pendingCodes), len(snapshot.Sessions))
+	}
+	if interviews > 0 {
+		log.Printf("♻️ Restored %d scheduled interview(s) from store", interviews)
+	}
+
+	return nil
+}
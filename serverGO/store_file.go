@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Journal entries appended before the file is compacted
+const JOURNAL_COMPACT_THRESHOLD = 1000
+
+// Journal operations
+const (
+	journalPutPending      = "putPending"
+	journalDeletePending   = "deletePending"
+	journalPutSession      = "putSession"
+	journalDeleteSession   = "deleteSession"
+	journalPutInterview    = "putInterview"
+	journalDeleteInterview = "deleteInterview"
+)
+
+// Single line in the journal
+type journalEntry struct {
+	Op        string              `json:"op"`
+	Code      string              `json:"code"`
+	Pending   *PendingRecord      `json:"pending,omitempty"`
+	Session   *SessionRecord      `json:"session,omitempty"`
+	Interview *ScheduledInterview `json:"interview,omitempty"`
+	At        time.Time           `json:"at"`
+}
+
+// File-backed session store using an append-only JSON lines journal.
+// The journal is replayed on open and compacted once it grows too long.
+// Changes apply to memory at once and are written and synced by a single
+// writer goroutine, so callers holding server locks never wait on the disk.
+type FileSessionStore struct {
+	path    string
+	maxLine int // longest journal line replay accepts
+	file    *os.File
+	state   *MemorySessionStore
+	entries int
+	queue   [][]byte      // encoded entries awaiting the writer
+	wake    chan struct{} // signals the writer, closed on Close
+	done    chan struct{} // closed once the writer has flushed and exited
+	closed  bool
+	mu      sync.Mutex
+}
+
+// Open or create a journal at path
+func OpenFileSessionStore(path string, maxLine int) (*FileSessionStore, error) {
+	store := &FileSessionStore{
+		path:    path,
+		maxLine: maxLine,
+		state:   NewMemorySessionStore(),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	if err := store.replay(); err != nil {
+		return nil, err
+	}
+	if err := store.compact(); err != nil {
+		return nil, err
+	}
+
+	go store.writeLoop()
+	return store, nil
+}
+
+// Replay the journal into memory. Lines that are torn, unreadable or longer
+// than maxLine are skipped so one bad entry cannot prevent a restart.
+func (f *FileSessionStore) replay() error {
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("opening journal: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	line := 0
+	for {
+		data, tooLong, err := readJournalLine(reader, f.maxLine)
+		if err == io.EOF && len(data) == 0 && !tooLong {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("reading journal: %w", err)
+		}
+		line++
+
+		if tooLong {
+			log.Printf("Warning: Skipping journal entry at %s:%d, longer than %d bytes", f.path, line, f.maxLine)
+		} else if len(data) > 0 {
+			var entry journalEntry
+			if jsonErr := json.Unmarshal(data, &entry); jsonErr != nil {
+				log.Printf("Warning: Skipping unreadable journal entry at %s:%d: %v", f.path, line, jsonErr)
+			} else {
+				f.apply(&entry)
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// Read one journal line, discarding the rest of any line longer than max
+func readJournalLine(reader *bufio.Reader, max int) ([]byte, bool, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return line, tooLong, err
+		}
+		if !tooLong {
+			if len(line)+len(chunk) > max {
+				tooLong = true
+				line = nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if !isPrefix {
+			return line, tooLong, nil
+		}
+	}
+}
+
+// Apply an entry to the in-memory state
+func (f *FileSessionStore) apply(entry *journalEntry) {
+	switch entry.Op {
+	case journalPutPending:
+		if entry.Pending != nil {
+			f.state.SavePending(*entry.Pending)
+		}
+	case journalDeletePending:
+		f.state.DeletePending(entry.Code)
+	case journalPutSession:
+		if entry.Session != nil {
+			f.state.SaveSession(*entry.Session)
+		}
+	case journalDeleteSession:
+		f.state.DeleteSession(entry.Code)
+	case journalPutInterview:
+		if entry.Interview != nil {
+			f.state.SaveInterview(*entry.Interview)
+		}
+	case journalDeleteInterview:
+		f.state.DeleteInterview(entry.Code)
+	}
+}
+
+// Rewrite the journal as a minimal snapshot and reopen it for appending.
+// The snapshot is copied under f.mu but written without it, so appends never
+// wait on the disk. Once the store is open only the writer goroutine compacts.
+func (f *FileSessionStore) compact() error {
+	f.mu.Lock()
+	snapshot, _ := f.state.Load()
+	// The snapshot already holds everything still queued
+	covered := f.queue
+	f.queue = nil
+	f.mu.Unlock()
+
+	file, entries, err := f.writeSnapshot(snapshot)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil {
+		// Keep appending to the old journal, which still lacks the covered entries
+		f.queue = append(covered, f.queue...)
+		return err
+	}
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file = file
+	f.entries = entries
+	return nil
+}
+
+// Write a snapshot beside the journal and swap it in, returning it open for
+// appending. The handle follows the file through the rename, so a failure
+// always leaves the old journal in use.
+func (f *FileSessionStore) writeSnapshot(snapshot *StoreSnapshot) (*os.File, int, error) {
+	tmp := f.path + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, 0, fmt.Errorf("compacting journal: %w", err)
+	}
+
+	var entries []journalEntry
+	now := time.Now()
+	for i := range snapshot.Pending {
+		entries = append(entries, journalEntry{Op: journalPutPending, Code: snapshot.Pending[i].Code, Pending: &snapshot.Pending[i], At: now})
+	}
+	for i := range snapshot.Sessions {
+		entries = append(entries, journalEntry{Op: journalPutSession, Code: snapshot.Sessions[i].Code, Session: &snapshot.Sessions[i], At: now})
+	}
+	for i := range snapshot.Interviews {
+		entries = append(entries, journalEntry{Op: journalPutInterview, Code: snapshot.Interviews[i].Code, Interview: &snapshot.Interviews[i], At: now})
+	}
+
+	writer := bufio.NewWriter(out)
+	encoder := json.NewEncoder(writer)
+	for i := range entries {
+		if err := encoder.Encode(&entries[i]); err != nil {
+			out.Close()
+			return nil, 0, fmt.Errorf("compacting journal: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		out.Close()
+		return nil, 0, fmt.Errorf("compacting journal: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return nil, 0, fmt.Errorf("compacting journal: %w", err)
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		out.Close()
+		return nil, 0, fmt.Errorf("compacting journal: %w", err)
+	}
+	return out, len(entries), nil
+}
+
+// Apply an entry and queue it for the writer
+func (f *FileSessionStore) append(entry journalEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return fmt.Errorf("journal is closed")
+	}
+
+	entry.At = time.Now()
+	data, err := json.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	f.apply(&entry)
+	f.queue = append(f.queue, data)
+
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Write queued entries until the store closes, then flush what is left
+func (f *FileSessionStore) writeLoop() {
+	defer close(f.done)
+	for range f.wake {
+		f.flush()
+	}
+	f.flush()
+}
+
+// Write and sync the queued entries as one batch, compacting once the
+// journal grows too long. Only the writer goroutine writes to f.file after
+// open, and it swaps in the compacted file under f.mu.
+func (f *FileSessionStore) flush() {
+	f.mu.Lock()
+	batch := f.queue
+	f.queue = nil
+	file := f.file
+	f.mu.Unlock()
+
+	if len(batch) == 0 || file == nil {
+		return
+	}
+
+	for _, data := range batch {
+		if _, err := file.Write(data); err != nil {
+			log.Printf("Error writing journal %s: %v", f.path, err)
+			return
+		}
+	}
+	if err := file.Sync(); err != nil {
+		log.Printf("Error syncing journal %s: %v", f.path, err)
+		return
+	}
+
+	f.mu.Lock()
+	f.entries += len(batch)
+	full := f.entries > JOURNAL_COMPACT_THRESHOLD
+	f.mu.Unlock()
+
+	if full {
+		if err := f.compact(); err != nil {
+			log.Printf("Error compacting journal %s: %v", f.path, err)
+		}
+	}
+}
+
+func (f *FileSessionStore) SavePending(record PendingRecord) error {
+	return f.append(journalEntry{Op: journalPutPending, Code: record.Code, Pending: &record})
+}
+
+func (f *FileSessionStore) DeletePending(code string) error {
+	return f.append(journalEntry{Op: journalDeletePending, Code: code})
+}
+
+func (f *FileSessionStore) SaveSession(record SessionRecord) error {
+	return f.append(journalEntry{Op: journalPutSession, Code: record.Code, Session: &record})
+}
+
+func (f *FileSessionStore) DeleteSession(code string) error {
+	return f.append(journalEntry{Op: journalDeleteSession, Code: code})
+}
+
+func (f *FileSessionStore) SaveInterview(interview ScheduledInterview) error {
+	return f.append(journalEntry{Op: journalPutInterview, Code: interview.Code, Interview: &interview})
+}
+
+func (f *FileSessionStore) DeleteInterview(code string) error {
+	return f.append(journalEntry{Op: journalDeleteInterview, Code: code})
+}
+
+func (f *FileSessionStore) Load() (*StoreSnapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.state.Load()
+}
+
+// Flush queued entries and close the journal
+func (f *FileSessionStore) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	close(f.wake)
+	f.mu.Unlock()
+
+	<-f.done
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T, path string) *FileSessionStore {
+	t.Helper()
+	store, err := OpenFileSessionStore(path, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// Codes of the pending records and sessions a store holds
+func storedCodes(t *testing.T, store SessionStore) (pending, sessions []string) {
+	t.Helper()
+	snapshot, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range snapshot.Pending {
+		pending = append(pending, record.Code)
+	}
+	for _, record := range snapshot.Sessions {
+		sessions = append(sessions, record.Code)
+	}
+	sort.Strings(pending)
+	sort.Strings(sessions)
+	return pending, sessions
+}
+
+func TestFileStoreReplaysAfterCrashMidWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl")
+
+	store := openTestStore(t, path)
+	store.SavePending(PendingRecord{Code: "111111", CreatedAt: time.Now(), Subject: "alice"})
+	store.SavePending(PendingRecord{Code: "222222", CreatedAt: time.Now()})
+	store.SaveSession(SessionRecord{Code: "333333", Info: &SessionInfo{CreatedAt: time.Now(), Subject: "alice"}})
+	store.DeletePending("222222")
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The process died while writing: an oversized line, garbage and a torn entry
+	journal, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(journal, "{\"op\":\"putPending\",\"code\":\"444444\",\"pending\":{\"code\":\"%s\"}}\n", strings.Repeat("4", 5000))
+	journal.WriteString("not json\n")
+	journal.WriteString(`{"op":"deleteSession","code":"333`)
+	journal.Close()
+
+	store = openTestStore(t, path)
+	pending, sessions := storedCodes(t, store)
+	if strings.Join(pending, ",") != "111111" || strings.Join(sessions, ",") != "333333" {
+		t.Fatalf("replayed pending %v, sessions %v", pending, sessions)
+	}
+
+	// Opening compacted the damage away, so new entries replay cleanly
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("not json")) || !bytes.HasSuffix(data, []byte("\n")) {
+		t.Fatalf("journal not compacted:\n%s", data)
+	}
+	store.SavePending(PendingRecord{Code: "555555", CreatedAt: time.Now()})
+	store.Close()
+
+	store = openTestStore(t, path)
+	defer store.Close()
+	if pending, _ := storedCodes(t, store); strings.Join(pending, ",") != "111111,555555" {
+		t.Fatalf("pending after reopening %v", pending)
+	}
+}
+
+func TestFileStoreCompactsWithoutLosingAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl")
+	store := openTestStore(t, path)
+
+	// Enough churn to compact several times while appends keep coming
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < JOURNAL_COMPACT_THRESHOLD; i++ {
+				code := fmt.Sprintf("%d%05d", worker, i%50)
+				store.SavePending(PendingRecord{Code: code, CreatedAt: time.Now()})
+				store.DeletePending(code)
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	if left, _ := storedCodes(t, store); len(left) != 0 {
+		t.Fatalf("pending codes left in memory: %v", left)
+	}
+	for worker := 0; worker < 4; worker++ {
+		store.SavePending(PendingRecord{Code: fmt.Sprintf("%d99999", worker), CreatedAt: time.Now()})
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines > JOURNAL_COMPACT_THRESHOLD+4 {
+		t.Fatalf("journal has %d lines, compaction never ran", lines)
+	}
+
+	store = openTestStore(t, path)
+	defer store.Close()
+	if pending, _ := storedCodes(t, store); strings.Join(pending, ",") != "099999,199999,299999,399999" {
+		t.Fatalf("pending after reopening %v", pending)
+	}
+}
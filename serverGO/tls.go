@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLS defaults
+const DEFAULT_TLS_RELOAD_INTERVAL = 30 * time.Second // how often certificate files are checked for changes
+
+// Client certificate policies for tlsClientAuth
+const (
+	ClientAuthNone     = "none"     // never ask for a certificate
+	ClientAuthOptional = "optional" // verify a certificate if the peer sends one
+	ClientAuthRequire  = "require"  // refuse peers without a valid certificate
+)
+
+// TLS settings, disabled unless a certificate and key are configured
+type TLSConfig struct {
+	CertFile       string
+	KeyFile        string
+	ClientCAFile   string
+	ClientAuth     string
+	RedirectPort   string
+	ReloadInterval time.Duration
+}
+
+// Whether the server should speak TLS
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// TLS settings from the tls* config fields
+func NewTLSConfig(c *Config) (TLSConfig, error) {
+	config := TLSConfig{
+		CertFile:       c.TLSCertFile,
+		KeyFile:        c.TLSKeyFile,
+		ClientCAFile:   c.TLSClientCAFile,
+		ClientAuth:     c.TLSClientAuth,
+		RedirectPort:   c.TLSRedirectPort,
+		ReloadInterval: c.TLSReloadInterval,
+	}
+	return config, config.validate()
+}
+
+func (c *TLSConfig) validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("tlsCertFile and tlsKeyFile must be set together")
+	}
+	if c.ReloadInterval <= 0 {
+		return fmt.Errorf("tlsReloadInterval must be positive, got %s", c.ReloadInterval)
+	}
+
+	if c.ClientAuth == "" {
+		c.ClientAuth = ClientAuthNone
+		if c.ClientCAFile != "" {
+			c.ClientAuth = ClientAuthOptional
+		}
+	}
+	switch c.ClientAuth {
+	case ClientAuthNone, ClientAuthOptional, ClientAuthRequire:
+	default:
+		return fmt.Errorf("invalid tlsClientAuth %q (want none, optional or require)", c.ClientAuth)
+	}
+
+	if !c.Enabled() {
+		if c.ClientCAFile != "" || c.RedirectPort != "" {
+			return fmt.Errorf("tlsClientCaFile and tlsRedirectPort need tlsCertFile")
+		}
+		return nil
+	}
+	if c.ClientAuth != ClientAuthNone && c.ClientCAFile == "" {
+		return fmt.Errorf("tlsClientAuth %s needs tlsClientCaFile", c.ClientAuth)
+	}
+	return nil
+}
+
+// Serves the current certificate, reloading it when the files change
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time // newest modification time of the loaded pair
+	mu       sync.RWMutex
+}
+
+// Load a certificate pair for hot reloading
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Newest modification time of the certificate and key files
+func (r *CertReloader) filesModTime() (time.Time, error) {
+	var newest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return newest, err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest, nil
+}
+
+// Read the pair from disk and swap it in
+func (r *CertReloader) reload() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return fmt.Errorf("reading certificate: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+		cert.Leaf = leaf
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// Reload if either file changed, keeping the old pair on failure
+func (r *CertReloader) check() {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		log.Printf("Warning: Cannot check certificate files: %v", err)
+		return
+	}
+
+	r.mu.RLock()
+	changed := !modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if !changed {
+		return
+	}
+
+	// Files written one after the other can be briefly mismatched, retry next tick
+	if err := r.reload(); err != nil {
+		log.Printf("Warning: Keeping previous certificate: %v", err)
+		return
+	}
+	log.Printf("🔐 Reloaded TLS certificate from %s", r.certFile)
+}
+
+// Certificate callback for tls.Config
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Poll the certificate files until stop is closed
+func (r *CertReloader) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.check()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Build the listener TLS settings, loading certificates and the client CA
+func (s *Server) buildTLSConfig() (*tls.Config, error) {
+	reloader, err := NewCertReloader(s.tls.CertFile, s.tls.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	reloader.watch(s.tls.ReloadInterval, s.stop)
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if s.tls.ClientAuth != ClientAuthNone {
+		pem, err := os.ReadFile(s.tls.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", s.tls.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if s.tls.ClientAuth == ClientAuthRequire {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return config, nil
+}
+
+// Verified client certificate of a request, nil without mTLS
+func clientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// Serve plain HTTP on the redirect port, sending everything to HTTPS
+func (s *Server) startRedirectServer(tlsPort string) {
+	s.redirectServer = &http.Server{
+		Addr: ":" + s.tls.RedirectPort,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(r.Host); err == nil {
+				host = h
+			}
+			if tlsPort != "443" {
+				host = net.JoinHostPort(host, tlsPort)
+			}
+			http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+		}),
+	}
+
+	go func() {
+		log.Printf("↪️ Redirecting http://localhost:%s to HTTPS", s.tls.RedirectPort)
+		if err := s.redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Error serving HTTPS redirect: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/turn/v4"
+)
+
+// TURN defaults
+const (
+	DEFAULT_TURN_PORT           = 3478
+	DEFAULT_TURN_REALM          = "interview"
+	DEFAULT_TURN_CREDENTIAL_TTL = 2 * time.Hour // allocations cannot be refreshed once credentials expire
+)
+
+// Ranges relays never reach, even when allowed: "this network", shared
+// carrier-grade NAT space and the limited broadcast address
+var turnRefusedPeers = []*net.IPNet{
+	{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+	{IP: net.IPv4bcast, Mask: net.CIDRMask(32, 32)},
+}
+
+// Embedded TURN server settings, disabled unless a public IP is configured
+type TURNConfig struct {
+	PublicIP      net.IP
+	Host          string // advertised in ICE server URLs
+	Port          int
+	Realm         string
+	Secret        string
+	CredentialTTL time.Duration
+	RelayMinPort  uint16
+	RelayMaxPort  uint16
+	AllowedPeers  []*net.IPNet // internal networks relays may still reach
+}
+
+// Whether the embedded TURN server should run
+func (c TURNConfig) Enabled() bool {
+	return c.PublicIP != nil
+}
+
+// Build TURN settings from the server configuration
+func NewTURNConfig(c *Config) (TURNConfig, error) {
+	config := TURNConfig{
+		Host:          c.TURNHost,
+		Port:          c.TURNPort,
+		Realm:         c.TURNRealm,
+		Secret:        c.TURNSecret,
+		CredentialTTL: c.TURNCredentialTTL,
+	}
+
+	if config.Port < 1 || config.Port > 65535 {
+		return config, fmt.Errorf("invalid turnPort %d", config.Port)
+	}
+	if config.Realm == "" {
+		return config, fmt.Errorf("turnRealm must not be empty")
+	}
+	if config.CredentialTTL <= 0 {
+		return config, fmt.Errorf("turnCredentialTtl must be positive, got %s", config.CredentialTTL)
+	}
+
+	// Relay ports as min-max, any free port when unset
+	if raw := c.TURNRelayPorts; raw != "" {
+		rawMin, rawMax, _ := strings.Cut(raw, "-")
+		min, errMin := strconv.ParseUint(strings.TrimSpace(rawMin), 10, 16)
+		max, errMax := strconv.ParseUint(strings.TrimSpace(rawMax), 10, 16)
+		if errMin != nil || errMax != nil || min == 0 || min > max {
+			return config, fmt.Errorf("invalid turnRelayPorts %q (want min-max)", raw)
+		}
+		config.RelayMinPort, config.RelayMaxPort = uint16(min), uint16(max)
+	}
+
+	for _, entry := range strings.Split(c.TURNAllowedPeers, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return config, fmt.Errorf("invalid turnAllowedPeers entry %q (want a CIDR like 10.0.0.0/8)", entry)
+		}
+		config.AllowedPeers = append(config.AllowedPeers, network)
+	}
+
+	if c.TURNPublicIP == "" {
+		return config, nil
+	}
+	config.PublicIP = net.ParseIP(c.TURNPublicIP)
+	if config.PublicIP == nil {
+		return config, fmt.Errorf("invalid turnPublicIp %q", c.TURNPublicIP)
+	}
+	if config.Host == "" {
+		config.Host = config.PublicIP.String()
+	}
+	return config, nil
+}
+
+// Whether relays may send to a peer. Loopback, private and link-local
+// addresses are refused unless allowed, so credentials cannot be used to
+// reach the server's own network. The server itself, multicast and the
+// special ranges in turnRefusedPeers are always refused.
+func (c TURNConfig) permitsPeer(ip net.IP) bool {
+	if ip.Equal(c.PublicIP) || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range turnRefusedPeers {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	for _, network := range c.AllowedPeers {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified())
+}
+
+// TURN PermissionHandler refusing internal peers
+func (c TURNConfig) permissionHandler(clientAddr net.Addr, peerIP net.IP) bool {
+	if c.permitsPeer(peerIP) {
+		return true
+	}
+	log.Printf("🚫 Refused TURN permission from %s to internal peer %s", clientAddr, peerIP)
+	return false
+}
+
+// Embedded TURN and STUN server on UDP and TCP
+type TURNServer struct {
+	config TURNConfig
+	server *turn.Server
+}
+
+// Listen on the configured port and start relaying
+func StartTURNServer(config TURNConfig) (*TURNServer, error) {
+	if config.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("generating TURN secret: %w", err)
+		}
+		config.Secret = base64.StdEncoding.EncodeToString(secret)
+		log.Println("Warning: turnSecret not set, TURN credentials will not survive a restart")
+	}
+
+	address := net.JoinHostPort("0.0.0.0", strconv.Itoa(config.Port))
+
+	udp, err := net.ListenPacket("udp4", address)
+	if err != nil {
+		return nil, fmt.Errorf("listening on UDP %s: %w", address, err)
+	}
+	tcp, err := net.Listen("tcp4", address)
+	if err != nil {
+		udp.Close()
+		return nil, fmt.Errorf("listening on TCP %s: %w", address, err)
+	}
+
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm:       config.Realm,
+		AuthHandler: turn.LongTermTURNRESTAuthHandler(config.Secret, nil),
+		PacketConnConfigs: []turn.PacketConnConfig{{
+			PacketConn:            udp,
+			RelayAddressGenerator: config.relayGenerator(),
+			PermissionHandler:     config.permissionHandler,
+		}},
+		ListenerConfigs: []turn.ListenerConfig{{
+			Listener:              tcp,
+			RelayAddressGenerator: config.relayGenerator(),
+			PermissionHandler:     config.permissionHandler,
+		}},
+	})
+	if err != nil {
+		udp.Close()
+		tcp.Close()
+		return nil, err
+	}
+
+	return &TURNServer{config: config, server: server}, nil
+}
+
+func (c TURNConfig) relayGenerator() turn.RelayAddressGenerator {
+	if c.RelayMinPort != 0 {
+		return &turn.RelayAddressGeneratorPortRange{
+			RelayAddress: c.PublicIP,
+			Address:      "0.0.0.0",
+			MinPort:      c.RelayMinPort,
+			MaxPort:      c.RelayMaxPort,
+		}
+	}
+	return &turn.RelayAddressGeneratorStatic{RelayAddress: c.PublicIP, Address: "0.0.0.0"}
+}
+
+// ICE servers with fresh credentials scoped to a session
+func (t *TURNServer) ICEServers(code string) ([]ICEServer, error) {
+	username, password, err := turn.GenerateLongTermTURNRESTCredentials(t.config.Secret, code, t.config.CredentialTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	hostPort := net.JoinHostPort(t.config.Host, strconv.Itoa(t.config.Port))
+	return []ICEServer{
+		{URLs: []string{"stun:" + hostPort}},
+		{
+			URLs:       []string{"turn:" + hostPort + "?transport=udp", "turn:" + hostPort + "?transport=tcp"},
+			Username:   username,
+			Credential: password,
+		},
+	}, nil
+}
+
+// Number of live relay allocations
+func (t *TURNServer) Allocations() int {
+	return t.server.AllocationCount()
+}
+
+// Stop relaying and close the listeners
+func (t *TURNServer) Close() error {
+	return t.server.Close()
+}
+
+// ICE servers to hand peers of a session, nil without the embedded TURN server
+func (s *Server) iceServers(code string) []ICEServer {
+	if s.relay == nil {
+		return nil
+	}
+	servers, err := s.relay.ICEServers(code)
+	if err != nil {
+		log.Printf("Error issuing TURN credentials for %s: %v", code, err)
+		return nil
+	}
+	return servers
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNewTURNConfig(t *testing.T) {
+	config := DefaultConfig()
+	turnConfig, err := NewTURNConfig(config)
+	if err != nil || turnConfig.Enabled() {
+		t.Fatalf("default TURN config %+v, %v", turnConfig, err)
+	}
+
+	config.TURNPublicIP = "203.0.113.7"
+	config.TURNRelayPorts = "50000-50100"
+	config.TURNAllowedPeers = "10.1.0.0/16, 192.168.5.9/32"
+	turnConfig, err = NewTURNConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !turnConfig.Enabled() || turnConfig.Host != "203.0.113.7" || turnConfig.RelayMinPort != 50000 || turnConfig.RelayMaxPort != 50100 {
+		t.Fatalf("TURN config %+v", turnConfig)
+	}
+	if len(turnConfig.AllowedPeers) != 2 {
+		t.Fatalf("allowed peers %v", turnConfig.AllowedPeers)
+	}
+
+	for _, bad := range []func(*Config){
+		func(c *Config) { c.TURNPublicIP = "turn.example.com" },
+		func(c *Config) { c.TURNPort = 70000 },
+		func(c *Config) { c.TURNRelayPorts = "60000-50000" },
+		func(c *Config) { c.TURNRelayPorts = "50000" },
+		func(c *Config) { c.TURNAllowedPeers = "10.0.0.1" },
+		func(c *Config) { c.TURNCredentialTTL = 0 },
+	} {
+		config := DefaultConfig()
+		bad(config)
+		if _, err := NewTURNConfig(config); err == nil {
+			t.Errorf("accepted %+v", config)
+		}
+	}
+}
+
+func TestTURNPermitsPeer(t *testing.T) {
+	var allowed []*net.IPNet
+	for _, cidr := range []string{"10.1.0.0/16", "100.64.0.0/16", "224.0.0.0/4"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		allowed = append(allowed, network)
+	}
+	config := TURNConfig{PublicIP: net.ParseIP("203.0.113.10"), AllowedPeers: allowed}
+	cases := []struct {
+		ip   string
+		want bool
+	}{
+		{"198.51.100.20", true},
+		{"2001:db8::1", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.2.3.4", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"10.1.2.3", true},
+		{"::ffff:127.0.0.1", false},
+		{"203.0.113.10", false},
+		{"::ffff:203.0.113.10", false},
+		{"203.0.113.11", true},
+		{"0.1.2.3", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"100.128.0.1", true},
+		{"224.0.0.1", false},
+		{"239.1.2.3", false},
+		{"ff01::1", false},
+		{"ff02::1", false},
+		{"ff0e::1", false},
+		{"255.255.255.255", false},
+	}
+	for _, c := range cases {
+		if got := config.permitsPeer(net.ParseIP(c.ip)); got != c.want {
+			t.Errorf("%s permitted = %t, want %t", c.ip, got, c.want)
+		}
+	}
+}
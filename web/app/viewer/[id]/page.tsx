@@ -4,6 +4,7 @@ import { useState, useEffect, useRef, useMemo } from "react";
 import { useParams } from "next/navigation";
 import Image from "next/image";
 import MonitorInfo from "@/components/monitor-info";
+import { authenticatedServerUrl, getSession } from "@/lib/auth";
 
 type ProcessInfo = {
   timestamp: number;
@@ -60,9 +61,6 @@ type Payload = {
   newStreamCount?: number;
 };
 
-const serverAddress =
-  process.env.NEXT_PUBLIC_WEBSOCKET_URL || "ws://localhost:3004";
-
 export default function ViewerIdPage() {
   const params = useParams();
   const code = params.id as string;
@@ -121,9 +119,21 @@ export default function ViewerIdPage() {
     }
 
     function setupConnection() {
+      // Viewers register with an interviewer token from the sign in page
+      const session = getSession();
+      if (!session) {
+        setStatus("❌ Not signed in");
+        setErrorMessage(
+          "Please sign in as an interviewer to watch this session."
+        );
+        reconnectTimerRef.current = setTimeout(() => {
+          window.location.href = "/viewer";
+        }, 3000);
+        return;
+      }
+
       setStatus("Connecting to signaling server...");
-      socket = new WebSocket(serverAddress);
-      socket = new WebSocket(serverAddress.replace(/\/$/, ""));
+      socket = new WebSocket(authenticatedServerUrl(session));
       socketRef.current = socket;
 
       socket.onopen = () => {
@@ -352,6 +362,8 @@ export default function ViewerIdPage() {
             setErrorMessage(
               "The client has disconnected. Waiting for reconnection..."
             );
+          } else if (message.type === "error") {
+            setErrorMessage(message.payload?.message || "Server error");
           } else if (message.type === "adminCommandResponse") {
             if (message.payload?.command === "disconnect") {
               setStatus("Client disconnected by request");
@@ -381,7 +393,7 @@ export default function ViewerIdPage() {
 
     setupConnection();
     return cleanup;
-  }, [code, serverAddress]);
+  }, [code]);
   // Format date for display - use UTC to avoid hydration errors
   const formattedRefreshDate = useMemo(() => {
     return lastRefresh.toLocaleTimeString("en-US", {
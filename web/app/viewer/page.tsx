@@ -1,6 +1,6 @@
 "use client";
 
-import { useState, useEffect, useRef } from "react";
+import { useState, useEffect, useRef, FormEvent } from "react";
 import { useRouter } from "next/navigation";
 import { Button } from "@/components/ui/button";
 import {
@@ -21,11 +21,20 @@ import {
   Monitor,
   Share2,
   ChevronRight,
+  LogIn,
+  LogOut,
 } from "lucide-react";
 import Image from "next/image";
+import {
+  InterviewerSession,
+  authenticatedServerUrl,
+  getSession,
+  login,
+  logout,
+} from "@/lib/auth";
 
-const serverUrl =
-  process.env.NEXT_PUBLIC_WEBSOCKET_URL || "ws://localhost:3004";
+// How long the code's socket outlives this page
+const HANDOFF_DELAY = 10000;
 
 export default function ViewerPage() {
   const [generatedCode, setGeneratedCode] = useState("");
@@ -34,16 +43,28 @@ export default function ViewerPage() {
   // const [isConnecting, setIsConnecting] = useState(false);
   const [isCopied, setIsCopied] = useState(false);
   const [showInstructions, setShowInstructions] = useState(false);
+  const [session, setSession] = useState<InterviewerSession | null>(null);
+  const [username, setUsername] = useState("");
+  const [password, setPassword] = useState("");
+  const [isSigningIn, setIsSigningIn] = useState(false);
   const router = useRouter();
   const wsRef = useRef<WebSocket | null>(null);
   const reconnectTimerRef = useRef<NodeJS.Timeout | null>(null);
   const copiedTimerRef = useRef<NodeJS.Timeout | null>(null);
 
   useEffect(() => {
+    // Requesting codes needs an interviewer token
+    setSession(getSession());
+
     // Clean up on unmount
     return () => {
-      if (wsRef.current) {
-        wsRef.current.close();
+      const ws = wsRef.current;
+      if (ws) {
+        // The server releases a code once its last viewer leaves, so give the
+        // monitoring page time to register on it before closing
+        ws.onclose = null;
+        ws.onerror = null;
+        setTimeout(() => ws.close(), HANDOFF_DELAY);
       }
       if (reconnectTimerRef.current) {
         clearTimeout(reconnectTimerRef.current);
@@ -61,8 +82,14 @@ export default function ViewerPage() {
         return;
       }
 
+      const current = getSession();
+      if (!current) {
+        setSession(null);
+        return;
+      }
+
       setError("");
-      const ws = new WebSocket(serverUrl);
+      const ws = new WebSocket(authenticatedServerUrl(current));
       wsRef.current = ws;
 
       ws.onopen = () => {
@@ -77,6 +104,12 @@ export default function ViewerPage() {
           if (message.type === "codeAssigned") {
             setGeneratedCode(message.payload.code);
             setIsGenerating(false);
+          } else if (message.type === "error") {
+            if (message.payload?.code === "unauthenticated") {
+              handleLogout();
+            }
+            setError(message.payload?.message || "Server error");
+            setIsGenerating(false);
           }
         } catch (err) {
           console.error("Error parsing WebSocket message:", err);
@@ -110,10 +143,41 @@ export default function ViewerPage() {
     }
 
     reconnectTimerRef.current = setTimeout(() => {
-      connectToServer();
+      if (getSession()) connectToServer();
     }, 5000);
   };
 
+  // Sign in as an interviewer
+  const handleLogin = async (event: FormEvent) => {
+    event.preventDefault();
+    setIsSigningIn(true);
+    setError("");
+
+    try {
+      setSession(await login(username, password));
+      setPassword("");
+    } catch (err) {
+      setError(err instanceof Error ? err.message : "Could not sign in.");
+    } finally {
+      setIsSigningIn(false);
+    }
+  };
+
+  const handleLogout = () => {
+    logout();
+    setSession(null);
+    setGeneratedCode("");
+    if (reconnectTimerRef.current) {
+      clearTimeout(reconnectTimerRef.current);
+      reconnectTimerRef.current = null;
+    }
+    if (wsRef.current) {
+      wsRef.current.onclose = null;
+      wsRef.current.close();
+      wsRef.current = null;
+    }
+  };
+
   // Generate a new code
   const handleGenerateCode = async () => {
     setIsGenerating(true);
@@ -203,8 +267,47 @@ export default function ViewerPage() {
           </CardHeader>
 
           <CardContent className="space-y-6 relative">
-            {/* Generated code section */}
-            {generatedCode ? (
+            {/* Sign in section */}
+            {!session ? (
+              <form
+                onSubmit={handleLogin}
+                className="flex flex-col py-8 px-6 space-y-4"
+              >
+                <p className="text-zinc-400 text-sm">
+                  Sign in with your interviewer account to create sessions
+                </p>
+                <input
+                  type="text"
+                  autoComplete="username"
+                  placeholder="Username"
+                  value={username}
+                  onChange={(e) => setUsername(e.target.value)}
+                  className="bg-zinc-900 border border-zinc-800 rounded-md px-3 py-2 text-sm text-zinc-200 focus:outline-none focus:border-blue-600"
+                  required
+                />
+                <input
+                  type="password"
+                  autoComplete="current-password"
+                  placeholder="Password"
+                  value={password}
+                  onChange={(e) => setPassword(e.target.value)}
+                  className="bg-zinc-900 border border-zinc-800 rounded-md px-3 py-2 text-sm text-zinc-200 focus:outline-none focus:border-blue-600"
+                  required
+                />
+                <Button
+                  type="submit"
+                  disabled={isSigningIn}
+                  className="bg-blue-600 hover:bg-blue-700 text-white w-full py-5"
+                >
+                  {isSigningIn ? (
+                    <Loader2 size={18} className="mr-2 animate-spin" />
+                  ) : (
+                    <LogIn size={18} className="mr-2" />
+                  )}
+                  Sign In
+                </Button>
+              </form>
+            ) : generatedCode ? (
               <div className="group relative bg-zinc-900 border border-zinc-800 rounded-xl p-6 transition-all">
                 <div className="absolute inset-0 bg-blue-500/5 rounded-xl opacity-0 group-hover:opacity-100 transition-opacity duration-500 pointer-events-none"></div>
 
@@ -373,6 +476,20 @@ export default function ViewerPage() {
             )}
           </CardContent>
         </Card>
+        {session && (
+          <div className="flex justify-center items-center mt-4 text-xs text-zinc-500">
+            Signed in as {session.subject}
+            <Button
+              variant="ghost"
+              size="sm"
+              className="h-7 ml-2 text-zinc-400 hover:text-zinc-200 hover:bg-zinc-900/40"
+              onClick={handleLogout}
+            >
+              <LogOut size={12} className="mr-1" />
+              Sign out
+            </Button>
+          </div>
+        )}
         <p className="text-zinc-500 text-xs text-center mt-6">
           InterView • Secure Remote Interview Monitoring
         </p>
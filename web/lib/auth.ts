@@ -0,0 +1,65 @@
+export const serverUrl =
+  process.env.NEXT_PUBLIC_WEBSOCKET_URL || "ws://localhost:3004";
+
+const SESSION_KEY = "interviewerSession";
+
+export type InterviewerSession = {
+  token: string;
+  subject: string;
+  expiresAt: number; // ms since epoch
+};
+
+// HTTP address of the signaling server, which serves login on the same port
+function httpUrl(path: string) {
+  return serverUrl.replace(/^ws/, "http").replace(/\/$/, "") + path;
+}
+
+// The signed-in interviewer, or null when signed out or the token expired
+export function getSession(): InterviewerSession | null {
+  const raw = sessionStorage.getItem(SESSION_KEY);
+  if (!raw) return null;
+  try {
+    const session = JSON.parse(raw) as InterviewerSession;
+    if (session.expiresAt > Date.now()) return session;
+  } catch {
+    // Fall through and drop the unreadable entry
+  }
+  sessionStorage.removeItem(SESSION_KEY);
+  return null;
+}
+
+// Exchange a username and password for a token
+export async function login(
+  username: string,
+  password: string
+): Promise<InterviewerSession> {
+  const response = await fetch(httpUrl("/auth/login"), {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ username, password }),
+  });
+  if (response.status === 401) {
+    throw new Error("Invalid username or password.");
+  }
+  if (response.status === 429) {
+    throw new Error("Too many failed attempts. Please try again later.");
+  }
+  if (!response.ok) {
+    throw new Error("Could not sign in. Please try again.");
+  }
+
+  const session = (await response.json()) as InterviewerSession;
+  sessionStorage.setItem(SESSION_KEY, JSON.stringify(session));
+  return session;
+}
+
+export function logout() {
+  sessionStorage.removeItem(SESSION_KEY);
+}
+
+// WebSocket address authenticated as the signed-in interviewer
+export function authenticatedServerUrl(session: InterviewerSession) {
+  const url = new URL(serverUrl);
+  url.searchParams.set("token", session.token);
+  return url.toString();
+}